@@ -0,0 +1,168 @@
+package sql_exporter
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net"
+
+	"cloud.google.com/go/auth"
+	"cloud.google.com/go/auth/credentials"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	rdsauth "github.com/aws/aws-sdk-go-v2/feature/rds/auth"
+	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/xo/dburl"
+)
+
+const (
+	// OAuth scope of Cloud SQL IAM database authentication tokens.
+	cloudSQLLoginScope = "https://www.googleapis.com/auth/sqlservice.login"
+	// Scope of Azure AD tokens for Azure Database for PostgreSQL and MySQL.
+	azureDatabaseScope = "https://ossrdbms-aad.database.windows.net/.default"
+)
+
+// tokenSource mints short-lived authentication tokens, used as passwords.
+type tokenSource interface {
+	// token returns a token for connecting to the database with the provided URL.
+	token(ctx context.Context, u *dburl.URL) (string, error)
+}
+
+// tokenSources is the registry of token sources by auth type.
+var tokenSources = map[string]func(ctx context.Context, ac *config.AuthConfig) (tokenSource, error){
+	"aws_rds_iam":      newAWSRDSTokenSource,
+	"gcp_cloudsql_iam": newGCPCloudSQLTokenSource,
+	"azure_ad":         newAzureADTokenSource,
+}
+
+// newTokenSource returns the token source for the auth config.
+func newTokenSource(ctx context.Context, ac *config.AuthConfig) (tokenSource, error) {
+	newSource, ok := tokenSources[ac.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported auth type %q", ac.Type)
+	}
+	return newSource(ctx, ac)
+}
+
+// tokenConnector is a driver.Connector opening each connection with a freshly minted token as the password, so that
+// connections can be opened for longer than a single token is valid.
+type tokenConnector struct {
+	driver driver.Driver
+	open   connectorFunc
+	url    *dburl.URL
+	source tokenSource
+}
+
+// newTokenConnector returns a connector opening connections to the provided URL with the connectors returned by open.
+func newTokenConnector(u *dburl.URL, source tokenSource, open connectorFunc) (*tokenConnector, error) {
+	// Doesn't connect, only looks up the driver.
+	connector, err := open(u.DSN)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenConnector{driver: connector.Driver(), open: open, url: u, source: source}, nil
+}
+
+// Connect implements driver.Connector.
+func (c *tokenConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	token, err := c.source.token(ctx, c.url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get authentication token: %w", err)
+	}
+	u, err := withCredentials(c.url, config.Credentials{Password: config.Secret(token)})
+	if err != nil {
+		return nil, err
+	}
+	connector, err := c.open(u.DSN)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+// Driver implements driver.Connector.
+func (c *tokenConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// awsRDSTokenSource mints RDS IAM authentication tokens, signed with the default AWS credentials.
+type awsRDSTokenSource struct {
+	region      string
+	credentials aws.CredentialsProvider
+}
+
+func newAWSRDSTokenSource(ctx context.Context, ac *config.AuthConfig) (tokenSource, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if ac.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(ac.Region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS config: %w", err)
+	}
+	return &awsRDSTokenSource{region: cfg.Region, credentials: cfg.Credentials}, nil
+}
+
+func (s *awsRDSTokenSource) token(ctx context.Context, u *dburl.URL) (string, error) {
+	port := u.Port()
+	if port == "" {
+		port = defaultPort(u.UnaliasedDriver)
+	}
+	return rdsauth.BuildAuthToken(ctx, net.JoinHostPort(u.Hostname(), port), s.region, u.User.Username(),
+		s.credentials)
+}
+
+// gcpCloudSQLTokenSource mints Cloud SQL IAM database authentication tokens for the default Google credentials.
+type gcpCloudSQLTokenSource struct {
+	credentials *auth.Credentials
+}
+
+func newGCPCloudSQLTokenSource(_ context.Context, _ *config.AuthConfig) (tokenSource, error) {
+	creds, err := credentials.DetectDefault(&credentials.DetectOptions{Scopes: []string{cloudSQLLoginScope}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to detect Google credentials: %w", err)
+	}
+	return &gcpCloudSQLTokenSource{credentials: creds}, nil
+}
+
+func (s *gcpCloudSQLTokenSource) token(ctx context.Context, _ *dburl.URL) (string, error) {
+	// Tokens are cached and refreshed by the credentials.
+	t, err := s.credentials.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	return t.Value, nil
+}
+
+// azureADTokenSource mints Azure AD (Entra ID) tokens for the default Azure credentials.
+type azureADTokenSource struct {
+	credential *azidentity.DefaultAzureCredential
+}
+
+func newAzureADTokenSource(_ context.Context, _ *config.AuthConfig) (tokenSource, error) {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load Azure credentials: %w", err)
+	}
+	return &azureADTokenSource{credential: cred}, nil
+}
+
+func (s *azureADTokenSource) token(ctx context.Context, _ *dburl.URL) (string, error) {
+	t, err := s.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureDatabaseScope}})
+	if err != nil {
+		return "", err
+	}
+	return t.Token, nil
+}
+
+// defaultPort returns the default port of the driver's database, for building RDS tokens.
+func defaultPort(driver string) string {
+	switch driver {
+	case "mysql":
+		return "3306"
+	default:
+		return "5432"
+	}
+}
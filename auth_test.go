@@ -0,0 +1,95 @@
+package sql_exporter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/xo/dburl"
+)
+
+// fakeTokenSource mints numbered tokens.
+type fakeTokenSource struct {
+	mu     sync.Mutex
+	minted int
+}
+
+func (s *fakeTokenSource) token(_ context.Context, u *dburl.URL) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.minted++
+	return fmt.Sprintf("token-%d-for-%s", s.minted, u.User.Username()), nil
+}
+
+// recordingDriver records the DSNs connections are opened with.
+type recordingDriver struct {
+	mu   sync.Mutex
+	dsns []string
+}
+
+func (d *recordingDriver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dsns = append(d.dsns, dsn)
+	return fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not implemented") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not implemented") }
+
+func TestTokenConnector(t *testing.T) {
+	u, err := safeParse("postgres://monitor@db1.example.com:5432/postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &fakeTokenSource{}
+	drv := &recordingDriver{}
+	db := sql.OpenDB(&tokenConnector{driver: drv, open: driverConnectorFunc(drv), url: u, source: source})
+	defer db.Close()
+
+	// Two concurrently used connections, each opened with its own token.
+	ctx := context.Background()
+	c1, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	if len(drv.dsns) != 2 {
+		t.Fatalf("expected 2 connections, got %d", len(drv.dsns))
+	}
+	for i, dsn := range drv.dsns {
+		if want := fmt.Sprintf("password=token-%d-for-monitor", i+1); !strings.Contains(dsn, want) {
+			t.Errorf("expected DSN %q to contain %q", dsn, want)
+		}
+	}
+}
+
+func TestNewTokenSource(t *testing.T) {
+	orig := tokenSources
+	defer func() { tokenSources = orig }()
+	tokenSources = map[string]func(context.Context, *config.AuthConfig) (tokenSource, error){
+		"aws_rds_iam": func(context.Context, *config.AuthConfig) (tokenSource, error) { return &fakeTokenSource{}, nil },
+	}
+
+	if _, err := newTokenSource(context.Background(), &config.AuthConfig{Type: "aws_rds_iam"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTokenSource(context.Background(), &config.AuthConfig{Type: "kerberos"}); err == nil {
+		t.Fatal("expected an unsupported auth type error")
+	}
+}
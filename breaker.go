@@ -0,0 +1,93 @@
+package sql_exporter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+)
+
+const (
+	breakerStateName = "circuit_breaker_state"
+	breakerStateHelp = "State of the target's circuit breaker: 0 closed, 1 half-open (retrying), 2 open"
+	breakerRetryName = "circuit_breaker_next_retry_timestamp_seconds"
+	breakerRetryHelp = "Unix time after which the target is retried while its circuit breaker is open, 0 otherwise"
+)
+
+// breakerState is the state of a circuitBreaker.
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // connecting normally
+	breakerHalfOpen                     // retrying once the backoff has passed
+	breakerOpen                         // failing fast until the backoff has passed
+)
+
+// circuitBreaker keeps a target from connecting again right after it failed to. It opens on a failed attempt and lets
+// a single attempt through once the backoff has passed, which closes it on success and doubles the backoff on failure.
+type circuitBreaker struct {
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu        sync.Mutex
+	state     breakerState
+	backoff   time.Duration
+	nextRetry time.Time
+}
+
+// newCircuitBreaker returns a circuit breaker with the configured backoff, or nil if not configured.
+func newCircuitBreaker(cfg *config.CircuitBreakerConfig) *circuitBreaker {
+	if cfg == nil {
+		return nil
+	}
+	return &circuitBreaker{initialBackoff: time.Duration(cfg.InitialBackoff), maxBackoff: time.Duration(cfg.MaxBackoff)}
+}
+
+// allow returns whether an attempt may be made, and if not, the time after which it may. Once the backoff has passed,
+// the breaker turns half-open and allows a single attempt, whose outcome must be recorded.
+func (b *circuitBreaker) allow(now time.Time) (bool, time.Time) {
+	if b == nil {
+		return true, time.Time{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.state == breakerClosed:
+		return true, time.Time{}
+	case b.state == breakerOpen && !now.Before(b.nextRetry):
+		b.state = breakerHalfOpen
+		return true, time.Time{}
+	default:
+		return false, b.nextRetry
+	}
+}
+
+// record records the outcome of an allowed attempt. Canceled attempts (e.g. by the scraper going away) say nothing
+// about the target, another attempt is allowed right away.
+func (b *circuitBreaker) record(err error, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case err == nil:
+		b.state, b.backoff, b.nextRetry = breakerClosed, 0, time.Time{}
+	case errors.Is(err, context.Canceled):
+		if b.state == breakerHalfOpen {
+			b.state = breakerOpen
+		}
+	default:
+		b.backoff = min(max(2*b.backoff, b.initialBackoff), b.maxBackoff)
+		b.state, b.nextRetry = breakerOpen, now.Add(b.backoff)
+	}
+}
+
+// status returns the state of the breaker and, while not closed, the time after which the target is retried.
+func (b *circuitBreaker) status() (breakerState, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.nextRetry
+}
@@ -0,0 +1,104 @@
+package sql_exporter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(&config.CircuitBreakerConfig{
+		InitialBackoff: model.Duration(time.Second),
+		MaxBackoff:     model.Duration(3 * time.Second),
+	})
+	now := time.Now()
+	failed := errors.New("connection refused")
+
+	if ok, _ := b.allow(now); !ok {
+		t.Fatal("expected a closed breaker to allow attempts")
+	}
+	b.record(failed, now)
+	if ok, retry := b.allow(now); ok || !retry.Equal(now.Add(time.Second)) {
+		t.Fatalf("expected the breaker to open for 1s, got %t, %s", ok, retry)
+	}
+
+	// The backoff doubles on every failed retry, up to the maximum.
+	for _, backoff := range []time.Duration{2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		now = now.Add(time.Hour)
+		if ok, _ := b.allow(now); !ok {
+			t.Fatal("expected a retry once the backoff has passed")
+		}
+		if ok, _ := b.allow(now); ok {
+			t.Fatal("expected a single retry at a time")
+		}
+		if state, _ := b.status(); state != breakerHalfOpen {
+			t.Fatalf("expected the breaker to be half-open, got %d", state)
+		}
+		b.record(failed, now)
+		if _, retry := b.allow(now); !retry.Equal(now.Add(backoff)) {
+			t.Fatalf("expected a backoff of %s, got %s", backoff, retry.Sub(now))
+		}
+	}
+
+	// A canceled retry is tried again right away, a successful one closes the breaker.
+	now = now.Add(time.Hour)
+	b.allow(now)
+	b.record(context.Canceled, now)
+	if ok, _ := b.allow(now); !ok {
+		t.Fatal("expected a retry after a canceled one")
+	}
+	b.record(nil, now)
+	if state, _ := b.status(); state != breakerClosed {
+		t.Fatalf("expected the breaker to be closed, got %d", state)
+	}
+	b.record(failed, now)
+	if _, retry := b.allow(now); !retry.Equal(now.Add(time.Second)) {
+		t.Fatalf("expected the backoff to start over, got %s", retry.Sub(now))
+	}
+}
+
+func TestTarget_CircuitBreaker(t *testing.T) {
+	gc := &config.GlobalConfig{
+		MaxConns: 1,
+		CircuitBreaker: &config.CircuitBreakerConfig{
+			InitialBackoff: model.Duration(time.Hour),
+			MaxBackoff:     model.Duration(time.Hour),
+		},
+	}
+	// Not a valid pgx data source name, so that every connection attempt fails.
+	tt, err := NewTarget("", "db1", "pg", "postgres://db1:invalid-port/app", nil, nil, gc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tt.Close()
+	target := tt.(*target)
+
+	if err := target.ping(context.Background()); err == nil || strings.Contains(err.Error(), "circuit breaker") {
+		t.Fatalf("expected the connection attempt to fail, got %v", err)
+	}
+	if err := target.ping(context.Background()); err == nil || !strings.Contains(err.Error(), "circuit breaker open") {
+		t.Fatalf("expected the breaker to fail fast, got %v", err)
+	}
+
+	ch := make(chan Metric, capMetricChan)
+	target.Collect(context.Background(), ch)
+	close(ch)
+	values := make(map[string]float64)
+	for m := range ch {
+		var dm dto.Metric
+		if m.Desc() == nil || m.Write(&dm) != nil {
+			continue
+		}
+		values[m.Desc().Name()] = dm.GetGauge().GetValue()
+	}
+	if up, ok := values[upMetricName]; !ok || up != 0 || values[breakerStateName] != float64(breakerOpen) ||
+		values[breakerRetryName] < float64(time.Now().Add(59*time.Minute).Unix()) {
+		t.Errorf("unexpected metrics while the breaker is open: %v", values)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -8,6 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 
@@ -26,6 +29,8 @@ const (
 	appName string = "sql_exporter"
 
 	httpReadHeaderTimeout time.Duration = time.Duration(time.Second * 60)
+	// Time allowed for each of stopping the web server and revoking the leases of dynamic credentials on shutdown.
+	shutdownTimeout time.Duration = time.Duration(time.Second * 10)
 )
 
 var (
@@ -33,12 +38,21 @@ var (
 	listenAddress = flag.String("web.listen-address", ":9399", "Address to listen on for web interface and telemetry")
 	metricsPath   = flag.String("web.metrics-path", "/metrics", "Path under which to expose metrics")
 	enableReload  = flag.Bool("web.enable-reload", false, "Enable reload collector data handler")
+	enableOM      = flag.Bool("web.enable-openmetrics", false,
+		"Enable OpenMetrics exposition (exemplars, _created series) for scrapers that accept it")
 	webConfigFile = flag.String("web.config.file", "", "[EXPERIMENTAL] TLS/BasicAuth configuration file path")
 	configFile    = flag.String("config.file", "sql_exporter.yml", "SQL Exporter configuration file path")
 	configCheck   = flag.Bool("config.check", false, "Check configuration and exit")
 	logFormat     = flag.String("log.format", "logfmt", "Set log output format")
 	logLevel      = flag.String("log.level", "info", "Set log level")
 	logFile       = flag.String("log.file", "", "Log file to write to, leave empty to write to stderr")
+
+	configWatch         = flag.Bool("config.watch", false, "Watch the configuration and collector files, reloading on changes")
+	configWatchDebounce = flag.Duration("config.watch-debounce", time.Second,
+		"Time to wait for changes to settle before reloading, with config.watch")
+
+	// Set up on startup, before any reload.
+	shutdown *shutdownHandler
 )
 
 func init() {
@@ -106,14 +120,42 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The configuration loaded on startup counts as a successful reload.
+	recordConfigLoaded(exporter, *configFile)
+
 	// Start the scrape_errors_total metric drop ticker if configured.
 	startScrapeErrorsDropTicker(exporter, exporter.Config().Globals.ScrapeErrorDropInterval)
 
+	server := &http.Server{Addr: *listenAddress, ReadHeaderTimeout: httpReadHeaderTimeout}
+	// Shut down gracefully and revoke the leases of dynamic credentials on SIGINT and SIGTERM, if any are in use.
+	shutdown = &shutdownHandler{exporter: exporter, server: server, done: make(chan struct{})}
+	shutdown.install()
+
 	// Start signal handler to reload collector and target data.
 	signalHandler(exporter, *configFile)
 
+	// Start watching the configuration files if requested.
+	if *configWatch {
+		if err := startConfigWatcher(exporter, *configFile, *configWatchDebounce); err != nil {
+			slog.Error("Error starting configuration watcher", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Start pushing metrics to a remote-write endpoint if configured.
+	if err := startPusher(exporter); err != nil {
+		slog.Error("Error starting pusher", "error", err)
+		os.Exit(1)
+	}
+
+	// Start exporting metrics to an OpenTelemetry collector if configured.
+	if err := startOTLPExporter(exporter); err != nil {
+		slog.Error("Error starting OTLP exporter", "error", err)
+		os.Exit(1)
+	}
+
 	metricsHandler := promhttp.InstrumentMetricHandler(
-		prometheus.DefaultRegisterer, ExporterHandlerFor(exporter, sql_exporter.SvcRegistry),
+		prometheus.DefaultRegisterer, ExporterHandlerFor(exporter, sql_exporter.SvcRegistry, *enableOM),
 	)
 
 	// Start warmup process if configured, and wrap the metrics handler with the warmup middleware to block /metrics
@@ -127,48 +169,75 @@ func main() {
 	http.HandleFunc("/", HomeHandlerFunc(*metricsPath))
 	http.HandleFunc("/config", ConfigHandlerFunc(*metricsPath, exporter))
 	http.Handle(*metricsPath, metricsHandler)
+	http.Handle("/probe", ProbeHandlerFor(exporter, *enableOM))
 	// Expose exporter metrics separately, for debugging purposes.
 	http.Handle("/sql_exporter_metrics", promhttp.HandlerFor(prometheus.DefaultGatherer,
 		promhttp.HandlerOpts{}))
 	// Expose refresh handler to reload collectors and targets
 	if *enableReload {
 		http.HandleFunc("/reload", reloadHandler(exporter, *configFile))
+		http.HandleFunc("/-/reload", reloadHandler(exporter, *configFile, http.MethodPost, http.MethodPut))
 	}
 
-	server := &http.Server{Addr: *listenAddress, ReadHeaderTimeout: httpReadHeaderTimeout}
 	if err := web.ListenAndServe(server, &web.FlagConfig{
 		WebListenAddresses: &([]string{*listenAddress}),
 		WebConfigFile:      webConfigFile, WebSystemdSocket: OfBool(false),
 	}, logConfig.logger); err != nil {
+		if errors.Is(err, http.ErrServerClosed) {
+			<-shutdown.done
+			return
+		}
 		slog.Error("Error starting web server", "error", err)
 		os.Exit(1)
 
 	}
 }
 
-// reloadHandler returns a handler that reloads collector and target data.
-func reloadHandler(e sql_exporter.Exporter, configFile string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if err := sql_exporter.Reload(e, &configFile); err != nil {
-			slog.Error("Error reloading collector and target data", "error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusOK)
+// shutdownHandler shuts the exporter down on SIGINT and SIGTERM once dynamic credentials are in use: it stops the web
+// server, closes the targets along with their connection pools, then revokes the leases of the credentials, so that the
+// database users they were issued for don't outlive the exporter. Until then, the default signal handling applies.
+type shutdownHandler struct {
+	exporter sql_exporter.Exporter
+	server   *http.Server
+	once     sync.Once
+	// Closed once the exporter is shut down.
+	done chan struct{}
+}
+
+// install starts handling SIGINT and SIGTERM if dynamic credentials are in use. Called whenever the configuration is
+// loaded, as a reload may start using them.
+func (h *shutdownHandler) install() {
+	if len(cfg.SecretLeases()) == 0 {
+		return
 	}
+	h.once.Do(func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go h.wait(c)
+	})
 }
 
-// signalHandler listens for SIGHUP signals and reloads the collector and target data.
-func signalHandler(e sql_exporter.Exporter, configFile string) {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGHUP)
-	go func() {
-		for range c {
-			if err := sql_exporter.Reload(e, &configFile); err != nil {
-				slog.Error("Error reloading collector and target data", "error", err)
-			}
-		}
-	}()
+// wait shuts the exporter down once a signal is received.
+func (h *shutdownHandler) wait(c <-chan os.Signal) {
+	sig := <-c
+	slog.Warn("Shutting down", "signal", sig)
+	defer close(h.done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := h.server.Shutdown(ctx); err != nil {
+		slog.Error("Error stopping web server", "error", err)
+	}
+
+	// Not while reloading, which would open new connection pools.
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if err := h.exporter.Close(); err != nil {
+		slog.Warn("Error closing targets", "error", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	cfg.RevokeSecretLeases(ctx)
 }
 
 // startScrapeErrorsDropTicker starts a ticker that periodically drops scrape error metrics.
@@ -186,3 +255,34 @@ func startScrapeErrorsDropTicker(exporter sql_exporter.Exporter, interval model.
 		}
 	}()
 }
+
+// startPusher starts pushing metrics to the configured remote-write endpoint in the background, if any.
+func startPusher(exporter sql_exporter.Exporter) error {
+	pushConfig := exporter.Config().Push
+	if pushConfig == nil {
+		return nil
+	}
+
+	pusher, err := sql_exporter.NewPusher(pushConfig, exporter, sql_exporter.SvcRegistry, prometheus.DefaultRegisterer)
+	if err != nil {
+		return err
+	}
+	go pusher.Run(context.Background())
+	return nil
+}
+
+// startOTLPExporter starts exporting metrics to the configured OpenTelemetry collector in the background, if any.
+func startOTLPExporter(exporter sql_exporter.Exporter) error {
+	otlpConfig := exporter.Config().Globals.OTLP
+	if otlpConfig == nil {
+		return nil
+	}
+
+	otlpExporter, err := sql_exporter.NewOTLPExporter(otlpConfig, exporter, sql_exporter.SvcRegistry,
+		prometheus.DefaultRegisterer)
+	if err != nil {
+		return err
+	}
+	go otlpExporter.Run(context.Background())
+	return nil
+}
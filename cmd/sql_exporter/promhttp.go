@@ -28,8 +28,9 @@ const (
 	noMetricsEncoded    = "No metrics encoded"
 )
 
-// ExporterHandlerFor returns an http.Handler for the provided Exporter.
-func ExporterHandlerFor(exporter sql_exporter.Exporter, registry prometheus.Gatherer) http.Handler {
+// ExporterHandlerFor returns an http.Handler for the provided Exporter. If enableOpenMetrics is true, the OpenMetrics
+// format (including exemplars and `_created` series) is offered to scrapers that accept it.
+func ExporterHandlerFor(exporter sql_exporter.Exporter, registry prometheus.Gatherer, enableOpenMetrics bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		ctx, cancel := contextFor(req, exporter)
 		defer cancel()
@@ -69,10 +70,14 @@ func ExporterHandlerFor(exporter sql_exporter.Exporter, registry prometheus.Gath
 		mfs = exporter.FilterScrapeErrorsTotal(mfs)
 
 		contentType := expfmt.Negotiate(req.Header)
+		if enableOpenMetrics {
+			contentType = expfmt.NegotiateIncludingOpenMetrics(req.Header)
+		}
 		buf := getBuf()
 		defer giveBuf(buf)
 		writer, encoding := decorateWriter(req, buf)
-		enc := expfmt.NewEncoder(writer, contentType)
+		// Created lines are only written by the OpenMetrics encoder, other formats ignore the option.
+		enc := expfmt.NewEncoder(writer, contentType, expfmt.WithCreatedLines())
 		var errs prometheus.MultiError
 		for _, mf := range mfs {
 			if err := enc.Encode(mf); err != nil {
@@ -103,9 +108,37 @@ func ExporterHandlerFor(exporter sql_exporter.Exporter, registry prometheus.Gath
 	})
 }
 
+// ProbeHandlerFor returns an http.Handler probing the target passed in the `target` parameter with the probe module
+// passed in the `module` parameter and, optionally, the auth module passed in the `auth_module` parameter.
+func ProbeHandlerFor(exporter sql_exporter.Exporter, enableOpenMetrics bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		params := req.URL.Query()
+		target, module := params.Get("target"), params.Get("module")
+		if target == "" || module == "" {
+			http.Error(w, "The target and module parameters are required", http.StatusBadRequest)
+			return
+		}
+
+		probeExporter, err := exporter.Probe(target, module, params.Get("auth_module"))
+		if err != nil {
+			slog.Error("Error probing target", "target", target, "module", module, "error", err)
+			status := http.StatusBadRequest
+			if errors.Is(err, sql_exporter.ErrProbeNotConfigured) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, "Error probing target: "+err.Error(), status)
+			return
+		}
+
+		// Only the probed target's metrics, the exporter's own are exposed on the metrics path.
+		ExporterHandlerFor(probeExporter, prometheus.Gatherers{}, enableOpenMetrics).ServeHTTP(w, req)
+	})
+}
+
 func contextFor(req *http.Request, exporter sql_exporter.Exporter) (context.Context, context.CancelFunc) {
 	timeout := time.Duration(0)
-	configTimeout := time.Duration(exporter.Config().Globals.ScrapeTimeout)
+	// The longest configured timeout, targets overriding it with a shorter one apply theirs.
+	configTimeout := time.Duration(exporter.Config().ScrapeTimeout())
 	// If a timeout is provided in the Prometheus header, use it.
 	if v := req.Header.Get(scrapeTimeoutHeader); v != "" {
 		timeoutSeconds, err := strconv.ParseFloat(v, 64)
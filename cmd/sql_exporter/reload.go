@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/burningalchemist/sql_exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Serializes reloads, which may be triggered concurrently by SIGHUP, the reload endpoint and the config watcher.
+	reloadMu sync.Mutex
+
+	lastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sql_exporter_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful",
+	})
+	lastReloadSuccessTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sql_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload",
+	})
+	reloadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sql_exporter_config_reload_failures_total",
+		Help: "Total number of failed configuration reloads",
+	})
+	configHash = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sql_exporter_config_hash",
+		Help: "Hash of the currently loaded configuration and collector files",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(lastReloadSuccessful, lastReloadSuccessTimestamp, reloadFailures, configHash)
+}
+
+// reloadResponse is the JSON body returned by the reload endpoints.
+type reloadResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	*sql_exporter.ReloadResult
+}
+
+// recordConfigLoaded records a successful (re)load of the configuration in the reload metrics.
+func recordConfigLoaded(e sql_exporter.Exporter, configFile string) {
+	lastReloadSuccessful.Set(1)
+	lastReloadSuccessTimestamp.SetToCurrentTime()
+	sum, err := configFilesHash(configFiles(configFile, e.Config().CollectorFiles))
+	if err != nil {
+		slog.Warn("Unable to hash configuration files", "error", err)
+		return
+	}
+	// Only keep 48 bits, as float64 has a 53 bit mantissa.
+	configHash.Set(float64(binary.BigEndian.Uint64(append([]byte{0, 0}, sum[:6]...))))
+}
+
+// recordReloadFailure records a failed reload attempt in the reload metrics. Must be called with reloadMu held.
+func recordReloadFailure() {
+	lastReloadSuccessful.Set(0)
+	reloadFailures.Inc()
+}
+
+// reloadConfig reloads the exporter configuration and records the outcome in the reload metrics.
+func reloadConfig(e sql_exporter.Exporter, configFile string) (*sql_exporter.ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	return reloadConfigLocked(e, configFile)
+}
+
+// reloadConfigLocked is the same as reloadConfig, but must be called with reloadMu held.
+func reloadConfigLocked(e sql_exporter.Exporter, configFile string) (*sql_exporter.ReloadResult, error) {
+	result, err := sql_exporter.Reload(e, &configFile)
+	if err != nil {
+		recordReloadFailure()
+		return nil, err
+	}
+	recordConfigLoaded(e, configFile)
+	shutdown.install()
+	slog.Info("Reloaded configuration", "collectors", result.Collectors, "targets", result.Targets)
+	return result, nil
+}
+
+// reloadHandler returns a handler that reloads collector and target data, and responds with the changes applied as
+// JSON. If methods is not empty, other request methods are rejected.
+func reloadHandler(e sql_exporter.Exporter, configFile string, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(methods) > 0 && !slices.Contains(methods, r.Method) {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			http.Error(w, "This endpoint requires a "+strings.Join(methods, " or ")+" request",
+				http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set(contentTypeHeader, "application/json")
+		result, err := reloadConfig(e, configFile)
+		if err != nil {
+			slog.Error("Error reloading collector and target data", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(reloadResponse{Status: "error", Error: err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(reloadResponse{Status: "success", ReloadResult: result})
+	}
+}
+
+// signalHandler listens for SIGHUP signals and reloads the collector and target data.
+func signalHandler(e sql_exporter.Exporter, configFile string) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			if _, err := reloadConfig(e, configFile); err != nil {
+				slog.Error("Error reloading collector and target data", "error", err)
+			}
+		}
+	}()
+}
+
+// configFiles returns the configuration file and the collector files currently matched by the collector_files globs.
+func configFiles(configFile string, collectorFiles []string) []string {
+	files := []string{configFile}
+	baseDir := filepath.Dir(configFile)
+	for _, pattern := range collectorFiles {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(baseDir, pattern)
+		}
+		matches, _ := filepath.Glob(pattern)
+		files = append(files, matches...)
+	}
+	return files
+}
+
+// configFilesHash returns a SHA-256 hash of the names and content of the provided files.
+func configFilesHash(files []string) ([]byte, error) {
+	h := sha256.New()
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		_, _ = io.WriteString(h, file+"\x00")
+		_, err = io.Copy(h, f)
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(nil), nil
+}
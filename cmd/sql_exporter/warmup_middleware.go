@@ -17,7 +17,7 @@ func initWarmup(exporter sql_exporter.Exporter) func(http.Handler) http.Handler
 
 	warmup := sql_exporter.NewWarmup()
 	go warmup.Run(exporter.Targets(), time.Duration(wd),
-		time.Duration(exporter.Config().Globals.ScrapeTimeout))
+		time.Duration(exporter.Config().ScrapeTimeout()))
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/hex"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter"
+	cfg "github.com/burningalchemist/sql_exporter/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+// configWatcher reloads the exporter whenever the content of the configuration file or of the collector files changes.
+// It watches the directories containing them rather than the files themselves, so that files replaced through renames
+// or symlink swaps (e.g. Kubernetes ConfigMap updates) and newly added collector files are picked up.
+type configWatcher struct {
+	exporter   sql_exporter.Exporter
+	configFile string
+	debounce   time.Duration
+	watcher    *fsnotify.Watcher
+	watched    map[string]struct{}
+	// Hash of the content of all files as of the last reload attempt.
+	hash string
+}
+
+// startConfigWatcher starts watching the configuration in the background.
+func startConfigWatcher(e sql_exporter.Exporter, configFile string, debounce time.Duration) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w := &configWatcher{
+		exporter:   e,
+		configFile: configFile,
+		debounce:   debounce,
+		watcher:    watcher,
+		watched:    make(map[string]struct{}),
+	}
+	w.updateWatches()
+	if w.hash, err = w.contentHash(); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	slog.Warn("Started watching configuration files", "configFile", configFile, "debounce", debounce)
+	go w.run()
+	return nil
+}
+
+// run waits for file system events, reloading once no more events have been received for the debounce duration.
+func (w *configWatcher) run() {
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			slog.Debug("Configuration file event", "event", event)
+			timer.Reset(w.debounce)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("Configuration watcher error", "error", err)
+		case <-timer.C:
+			w.reload()
+		}
+	}
+}
+
+// reload reloads the exporter if the content of the files changed since the last attempt and the new configuration
+// is valid.
+func (w *configWatcher) reload() {
+	hash, err := w.contentHash()
+	if err != nil {
+		slog.Error("Error reading configuration files", "error", err)
+		return
+	}
+	if hash == w.hash {
+		return
+	}
+	// Not retried until the files change again, should the reload fail.
+	w.hash = hash
+
+	// Serialized with other reloads, including the validation, so that the reload metrics reflect the last attempt.
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	// Validate the configuration first, so that e.g. a half-written file doesn't get the current one partly replaced.
+	if _, err := cfg.Load(w.configFile); err != nil {
+		slog.Error("Configuration changed but is invalid, not reloading", "error", err)
+		recordReloadFailure()
+		return
+	}
+	slog.Warn("Configuration changed, reloading")
+	if _, err := reloadConfigLocked(w.exporter, w.configFile); err != nil {
+		slog.Error("Error reloading collector and target data", "error", err)
+		return
+	}
+	// Collector file globs may have changed, and with them the files the hash covers.
+	w.updateWatches()
+	if hash, err := w.contentHash(); err == nil {
+		w.hash = hash
+	}
+}
+
+// files returns the configuration file and the collector files currently matched by the collector_files globs.
+func (w *configWatcher) files() []string {
+	return configFiles(w.configFile, w.exporter.Config().CollectorFiles)
+}
+
+// dirs returns the directories to watch: the configuration file's and those of the collector files and globs.
+func (w *configWatcher) dirs() []string {
+	dirs := []string{filepath.Dir(w.configFile)}
+	baseDir := filepath.Dir(w.configFile)
+	for _, pattern := range w.exporter.Config().CollectorFiles {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(baseDir, pattern)
+		}
+		// Directories with wildcards can't be watched as such, fall back to those of the matched files.
+		if dir := filepath.Dir(pattern); !hasMeta(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, file := range w.files() {
+		dirs = append(dirs, filepath.Dir(file))
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
+
+// updateWatches starts watching directories that aren't watched yet.
+func (w *configWatcher) updateWatches() {
+	for _, dir := range w.dirs() {
+		if _, found := w.watched[dir]; found {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			slog.Warn("Unable to watch configuration directory", "dir", dir, "error", err)
+			continue
+		}
+		w.watched[dir] = struct{}{}
+	}
+}
+
+// contentHash returns a hash of the names and content of all files.
+func (w *configWatcher) contentHash() (string, error) {
+	sum, err := configFilesHash(w.files())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// hasMeta reports whether path contains any of the magic characters recognized by filepath.Match.
+func hasMeta(path string) bool {
+	return slices.ContainsFunc([]rune(path), func(r rune) bool {
+		return r == '*' || r == '?' || r == '[' || r == '\\'
+	})
+}
@@ -1,18 +1,27 @@
 package sql_exporter
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/burningalchemist/sql_exporter/config"
 	"github.com/burningalchemist/sql_exporter/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 )
 
+const (
+	cacheAgeName = "sql_exporter_collector_cache_age_seconds"
+	cacheAgeHelp = "Age of the metrics served for a stale_while_revalidate collector in seconds, 0 if just collected"
+)
+
 // Collector is a self-contained group of SQL queries and metric families to collect from a specific database. It is
 // conceptually similar to a prometheus.Collector.
 type Collector interface {
@@ -65,20 +74,37 @@ func NewCollector(logContext string, cc *config.CollectorConfig, constLabels []*
 		queries:    queries,
 		logContext: logContext,
 	}
+	if c.config.Interval > 0 {
+		slog.Warn("Non-zero interval, using scheduled collector.", "logContext", logContext, "interval", c.config.Interval)
+		return newScheduledCollector(&c, constLabels), nil
+	}
 	if c.config.MinInterval > 0 {
 		slog.Warn("Non-zero min_interval, using cached collector.", "logContext", logContext, "min_interval", c.config.MinInterval)
-		return newCachingCollector(&c), nil
+		return newCachingCollector(&c, constLabels), nil
 	}
 	return &c, nil
 }
 
-// Collect implements Collector.
+// Collect implements Collector. If the collector has a timeout, queries are canceled once it expires, without affecting
+// other collectors of the same scrape.
 func (c *collector) Collect(ctx context.Context, conn *sql.DB, ch chan<- Metric) {
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.config.Timeout))
+		defer cancel()
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(len(c.queries))
 	for _, q := range c.queries {
 		go func(q *Query) {
 			defer wg.Done()
+			release, err := acquireQuerySlot(ctx, q, c.config.Priority)
+			if err != nil {
+				ch <- NewInvalidMetric(errors.Wrap(q.logContext, err))
+				return
+			}
+			defer release()
 			q.Collect(ctx, conn, ch)
 		}(q)
 	}
@@ -86,6 +112,18 @@ func (c *collector) Collect(ctx context.Context, conn *sql.DB, ch chan<- Metric)
 	wg.Wait()
 }
 
+// collectorLabelPairs returns the const labels with the collector's name added, for the collector's own metrics.
+func collectorLabelPairs(constLabels []*dto.LabelPair, name string) []*dto.LabelPair {
+	labels := make([]*dto.LabelPair, 0, len(constLabels)+1)
+	labels = append(labels, constLabels...)
+	labels = append(labels, &dto.LabelPair{
+		Name:  new(collectorLabel),
+		Value: new(name),
+	})
+	sort.Sort(labelPairSorter(labels))
+	return labels
+}
+
 // Close releases all prepared statements held by this collector's queries.
 func (c *collector) Close() error {
 	var errs []error
@@ -102,15 +140,25 @@ func (c *collector) Close() error {
 
 // Close implements Collector for cachingCollector.
 func (cc *cachingCollector) Close() error {
+	// The connection pool is closed or replaced next, a background refresh still running would only cache errors.
+	cc.revalidationMu.Lock()
+	cc.poolEpoch++
+	if cc.cancelRevalidation != nil {
+		cc.cancelRevalidation()
+	}
+	cc.revalidationMu.Unlock()
+	cc.revalidations.Wait()
 	return cc.rawColl.Close()
 }
 
 // newCachingCollector returns a new Collector wrapping the provided raw Collector.
-func newCachingCollector(rawColl *collector) Collector {
+func newCachingCollector(rawColl *collector, constLabels []*dto.LabelPair) Collector {
 	cc := &cachingCollector{
 		rawColl:     rawColl,
 		minInterval: time.Duration(rawColl.config.MinInterval),
-		cacheSem:    make(chan time.Time, 1),
+		ageDesc: NewAutomaticMetricDesc(rawColl.logContext, cacheAgeName, cacheAgeHelp, prometheus.GaugeValue,
+			collectorLabelPairs(constLabels, rawColl.config.Name)),
+		cacheSem: make(chan time.Time, 1),
 	}
 	cc.cacheSem <- time.Time{}
 	return cc
@@ -122,11 +170,20 @@ type cachingCollector struct {
 	rawColl *collector
 	// Convenience copy of rawColl.config.MinInterval.
 	minInterval time.Duration
+	ageDesc     MetricDesc
 
 	// Used as a non=blocking semaphore protecting the cache. The value in the channel is the time of the cached metrics.
 	cacheSem chan time.Time
 	// Metrics saved from the last Collect() call.
 	cache []Metric
+	// Set while expired metrics are refreshed in the background, with stale_while_revalidate.
+	revalidating atomic.Bool
+	// Tracks the background refresh, which Close cancels and waits for. poolEpoch is incremented by Close, so that the
+	// results of a refresh run on a connection pool that has since been closed or replaced are discarded.
+	revalidations      sync.WaitGroup
+	revalidationMu     sync.Mutex
+	cancelRevalidation context.CancelFunc
+	poolEpoch          uint64
 }
 
 // Collect implements Collector.
@@ -140,7 +197,20 @@ func (cc *cachingCollector) Collect(ctx context.Context, conn *sql.DB, ch chan<-
 	select {
 	case cacheTime := <-cc.cacheSem:
 		// Have the lock.
-		if age := collTime.Sub(cacheTime); age > cc.minInterval || len(cc.cache) == 0 {
+		age := collTime.Sub(cacheTime)
+		if age > cc.minInterval && cc.serveStale(age) {
+			// Expired, but still fresh enough: serve it right away and refresh it in the background.
+			slog.Debug("Returning stale cached metrics", "logContext", cc.rawColl.logContext, "min_interval",
+				cc.minInterval.Seconds(), "cache_age", age.Seconds())
+			for _, metric := range cc.cache {
+				ch <- metric
+			}
+			cc.collectAge(ch, age)
+			cc.cacheSem <- cacheTime
+			cc.revalidate(ctx, conn)
+			return
+		}
+		if age > cc.minInterval || len(cc.cache) == 0 {
 			// Cache contents are older than minInterval, collect fresh metrics, cache them and pipe them through.
 			slog.Debug("Collecting fresh metrics", "logContext", cc.rawColl.logContext, "min_interval",
 				cc.minInterval.Seconds(), "cache_age", age.Seconds())
@@ -163,12 +233,14 @@ func (cc *cachingCollector) Collect(ctx context.Context, conn *sql.DB, ch chan<-
 				ch <- metric
 			}
 			cacheTime = collTime
+			cc.collectAge(ch, 0)
 		} else {
 			slog.Debug("Returning cached metrics", "logContext", cc.rawColl.logContext, "min_interval",
 				cc.minInterval.Seconds(), "cache_age", age.Seconds())
 			for _, metric := range cc.cache {
 				ch <- metric
 			}
+			cc.collectAge(ch, age)
 		}
 		// Always replace the value in the semaphore channel.
 		cc.cacheSem <- cacheTime
@@ -178,3 +250,67 @@ func (cc *cachingCollector) Collect(ctx context.Context, conn *sql.DB, ch chan<-
 		ch <- NewInvalidMetric(errors.Wrap(cc.rawColl.logContext, ctx.Err()))
 	}
 }
+
+// collectAge sends the age of the metrics served, for stale_while_revalidate collectors only.
+func (cc *cachingCollector) collectAge(ch chan<- Metric, age time.Duration) {
+	if cc.rawColl.config.StaleWhileRevalidate {
+		ch <- NewMetric(cc.ageDesc, age.Seconds())
+	}
+}
+
+// serveStale returns whether cached metrics of the provided age may be served while being refreshed in the background.
+func (cc *cachingCollector) serveStale(age time.Duration) bool {
+	cfg := cc.rawColl.config
+	return cfg.StaleWhileRevalidate && len(cc.cache) > 0 && (cfg.MaxStaleness == 0 || age <= time.Duration(cfg.MaxStaleness))
+}
+
+// revalidate refreshes the cached metrics in the background, unless already doing so. The refresh outlives the scrape,
+// bounded by the collector's timeout or else min_interval; if it doesn't complete the cached metrics are kept.
+func (cc *cachingCollector) revalidate(ctx context.Context, conn *sql.DB) {
+	if !cc.revalidating.CompareAndSwap(false, true) {
+		return
+	}
+	timeout := cmp.Or(time.Duration(cc.rawColl.config.Timeout), cc.minInterval)
+	// Keeps the context's values, e.g. the query limiter.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	// Not racing with Close, which waits for in-flight scrapes.
+	cc.revalidationMu.Lock()
+	epoch := cc.poolEpoch
+	cc.cancelRevalidation = cancel
+	cc.revalidations.Add(1)
+	cc.revalidationMu.Unlock()
+	go func() {
+		defer cc.revalidations.Done()
+		defer cc.revalidating.Store(false)
+		defer cancel()
+
+		start := time.Now()
+		ch := make(chan Metric, capMetricChan)
+		go func() {
+			cc.rawColl.Collect(ctx, conn, ch)
+			close(ch)
+		}()
+		var cache []Metric
+		for metric := range ch {
+			cache = append(cache, metric)
+		}
+		if ctx.Err() != nil {
+			slog.Warn("Background refresh of cached metrics did not complete, keeping stale metrics", "logContext",
+				cc.rawColl.logContext, "error", ctx.Err())
+			return
+		}
+		cc.revalidationMu.Lock()
+		defer cc.revalidationMu.Unlock()
+		if cc.poolEpoch != epoch {
+			slog.Debug("Connection pool replaced during the background refresh, discarding its metrics", "logContext",
+				cc.rawColl.logContext)
+			return
+		}
+
+		<-cc.cacheSem
+		cc.cache = cache
+		cc.cacheSem <- start
+		slog.Debug("Refreshed cached metrics in the background", "logContext", cc.rawColl.logContext, "duration",
+			time.Since(start).Seconds(), "metrics", len(cache))
+	}()
+}
@@ -0,0 +1,95 @@
+package sql_exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+func TestCachingCollectorStaleWhileRevalidate(t *testing.T) {
+	cfg := &config.CollectorConfig{
+		Name:                 "slow",
+		MinInterval:          model.Duration(time.Minute),
+		StaleWhileRevalidate: true,
+		MaxStaleness:         model.Duration(time.Hour),
+	}
+	targetName, targetVal := "target", "db1"
+	constLabels := []*dto.LabelPair{{Name: &targetName, Value: &targetVal}}
+	cc := newCachingCollector(&collector{config: cfg, logContext: "collector=slow"}, constLabels).(*cachingCollector)
+	cachedDesc := NewAutomaticMetricDesc("", "cached", "Cached metric.", prometheus.GaugeValue, nil)
+
+	// setCache replaces the cached metrics with a single one, collected the provided time ago.
+	setCache := func(age time.Duration) {
+		<-cc.cacheSem
+		cc.cache = []Metric{NewMetric(cachedDesc, 1)}
+		cc.cacheSem <- time.Now().Add(-age)
+	}
+	// collect returns the values of the collected metrics, by name.
+	collect := func() map[string]float64 {
+		ch := make(chan Metric, capMetricChan)
+		cc.Collect(context.Background(), nil, ch)
+		close(ch)
+		values := make(map[string]float64)
+		for m := range ch {
+			var dm dto.Metric
+			if err := m.Write(&dm); err != nil {
+				t.Fatal(err)
+			}
+			values[m.Desc().Name()] = dm.GetGauge().GetValue()
+		}
+		return values
+	}
+
+	// Expired metrics are served along with their age, while being refreshed in the background.
+	setCache(10 * time.Minute)
+	values := collect()
+	if _, ok := values["cached"]; !ok || values[cacheAgeName] < 600 {
+		t.Fatalf("expected the stale metrics and their age, got %v", values)
+	}
+	for range 100 {
+		if !cc.revalidating.Load() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	values = collect()
+	if _, ok := values["cached"]; ok || values[cacheAgeName] > 60 {
+		t.Fatalf("expected the metrics refreshed in the background, got %v", values)
+	}
+
+	// Beyond max_staleness the scrape waits for fresh metrics.
+	setCache(2 * time.Hour)
+	values = collect()
+	if _, ok := values["cached"]; ok || values[cacheAgeName] != 0 {
+		t.Fatalf("expected fresh metrics, got %v", values)
+	}
+
+	// Closing the collector waits for the background refresh.
+	setCache(10 * time.Minute)
+	collect()
+	if err := cc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if cc.revalidating.Load() {
+		t.Fatal("expected Close to wait for the background refresh")
+	}
+
+	// Other cached collectors don't export the age of their metrics.
+	plain := newCachingCollector(&collector{config: &config.CollectorConfig{
+		Name:        "plain",
+		MinInterval: model.Duration(time.Minute),
+	}, logContext: "collector=plain"}, constLabels).(*cachingCollector)
+	ch := make(chan Metric, capMetricChan)
+	plain.Collect(context.Background(), nil, ch)
+	close(ch)
+	for m := range ch {
+		if m.Desc().Name() == cacheAgeName {
+			t.Fatal("expected no cache age metric without stale_while_revalidate")
+		}
+	}
+}
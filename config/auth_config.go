@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// AuthTypes are the supported token based authentication types.
+var AuthTypes = []string{"aws_rds_iam", "gcp_cloudsql_iam", "azure_ad"}
+
+// AuthConfig defines a token based authentication for a target: short-lived tokens are minted for each new connection
+// and used as the password, e.g. for IAM database authentication.
+type AuthConfig struct {
+	Type   string `yaml:"type"`             // one of AuthTypes
+	Region string `yaml:"region,omitempty"` // AWS region of the database, for aws_rds_iam; defaults to the SDK's
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for AuthConfig.
+func (a *AuthConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	type plain AuthConfig
+	if err := unmarshal((*plain)(a)); err != nil {
+		return err
+	}
+
+	if !slices.Contains(AuthTypes, a.Type) {
+		return fmt.Errorf("unsupported auth type %q, must be one of %v", a.Type, AuthTypes)
+	}
+	if a.Region != "" && a.Type != "aws_rds_iam" {
+		return fmt.Errorf("region only applies to auth type aws_rds_iam, have %q", a.Type)
+	}
+
+	return checkOverflow(a.XXX, "auth")
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"testing"
+
+	"go.yaml.in/yaml/v3"
+)
+
+func TestAuthConfig(t *testing.T) {
+	content := `
+targets:
+  db1: postgres://db1:5432/postgres
+  db2:
+    data_source_name: postgres://monitor@db2.example.com:5432/postgres
+    auth:
+      type: aws_rds_iam
+      region: eu-west-1
+`
+	sc := &StaticConfig{}
+	if err := yaml.Unmarshal([]byte(content), sc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if sc.Auth("db1") != nil {
+		t.Errorf("expected no auth for db1, got %+v", sc.Auth("db1"))
+	}
+	if a := sc.Auth("db2"); a == nil || a.Type != "aws_rds_iam" || a.Region != "eu-west-1" {
+		t.Errorf("unexpected auth for db2: %+v", a)
+	}
+
+	for _, invalid := range []string{
+		"type: kerberos",
+		"type: azure_ad\nregion: westeurope",
+		"type: gcp_cloudsql_iam\nscope: all",
+	} {
+		if err := yaml.Unmarshal([]byte(invalid), &AuthConfig{}); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// CircuitBreakerConfig defines the backoff of the per-target circuit breaker. Once a target fails to connect or ping,
+// scrapes report it down without trying again until the backoff has passed, doubling it on every failed retry.
+type CircuitBreakerConfig struct {
+	InitialBackoff model.Duration `yaml:"initial_backoff"` // backoff after the first failure
+	MaxBackoff     model.Duration `yaml:"max_backoff"`     // upper bound of the backoff
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for CircuitBreakerConfig.
+func (c *CircuitBreakerConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	c.InitialBackoff = model.Duration(time.Second)
+	c.MaxBackoff = model.Duration(5 * time.Minute)
+
+	type plain CircuitBreakerConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	if c.InitialBackoff <= 0 {
+		return fmt.Errorf("circuit_breaker.initial_backoff must be strictly positive, have %s", c.InitialBackoff)
+	}
+	if c.MaxBackoff < c.InitialBackoff {
+		return fmt.Errorf("circuit_breaker.max_backoff must not be less than initial_backoff, have %s", c.MaxBackoff)
+	}
+
+	return checkOverflow(c.XXX, "circuit_breaker")
+}
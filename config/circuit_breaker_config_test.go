@@ -0,0 +1,30 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"go.yaml.in/yaml/v3"
+)
+
+func TestCircuitBreakerConfig(t *testing.T) {
+	g := &GlobalConfig{}
+	if err := yaml.Unmarshal([]byte("circuit_breaker:\n  max_backoff: 1m\n"), g); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if cb := g.CircuitBreaker; cb == nil || cb.InitialBackoff != model.Duration(time.Second) ||
+		cb.MaxBackoff != model.Duration(time.Minute) {
+		t.Errorf("unexpected circuit_breaker: %+v", cb)
+	}
+
+	for _, invalid := range []string{
+		"initial_backoff: 0s",
+		"initial_backoff: 1m\nmax_backoff: 10s",
+		"initial_backoff: 1s\nmultiplier: 2",
+	} {
+		if err := yaml.Unmarshal([]byte(invalid), &CircuitBreakerConfig{}); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
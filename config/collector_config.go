@@ -12,10 +12,17 @@ import (
 
 // CollectorConfig defines a set of metrics and how they are collected.
 type CollectorConfig struct {
-	Name        string          `yaml:"collector_name"`         // name of this collector
-	MinInterval model.Duration  `yaml:"min_interval,omitempty"` // minimum interval between query executions
-	Metrics     []*MetricConfig `yaml:"metrics"`                // metrics/queries defined by this collector
-	Queries     []*QueryConfig  `yaml:"queries,omitempty"`      // named queries defined by this collector
+	Name        string         `yaml:"collector_name"`         // name of this collector
+	MinInterval model.Duration `yaml:"min_interval,omitempty"` // minimum interval between query executions
+	Interval    model.Duration `yaml:"interval,omitempty"`     // run in the background at this interval, independent of scrapes
+	Timeout     model.Duration `yaml:"timeout,omitempty"`      // maximum duration of a single collector run, on top of the scrape timeout
+	Priority    int            `yaml:"priority,omitempty"`     // queries of higher priority collectors run first when max_concurrent_queries is reached
+
+	StaleWhileRevalidate bool           `yaml:"stale_while_revalidate,omitempty"` // serve expired cached metrics while refreshing them in the background
+	MaxStaleness         model.Duration `yaml:"max_staleness,omitempty"`          // age beyond which cached metrics aren't served anymore, 0 for no limit
+
+	Metrics []*MetricConfig `yaml:"metrics"`           // metrics/queries defined by this collector
+	Queries []*QueryConfig  `yaml:"queries,omitempty"` // named queries defined by this collector
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]any `yaml:",inline" json:"-"`
@@ -34,6 +41,24 @@ func (c *CollectorConfig) UnmarshalYAML(unmarshal func(any) error) error {
 	if len(c.Metrics) == 0 {
 		return fmt.Errorf("no metrics defined for collector %q", c.Name)
 	}
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must not be negative for collector %q", c.Name)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative for collector %q", c.Name)
+	}
+	if c.Interval > 0 && c.MinInterval >= 0 {
+		return fmt.Errorf("min_interval and interval are mutually exclusive for collector %q", c.Name)
+	}
+	if c.Interval > 0 && c.StaleWhileRevalidate {
+		return fmt.Errorf("stale_while_revalidate and interval are mutually exclusive for collector %q", c.Name)
+	}
+	if c.MaxStaleness < 0 {
+		return fmt.Errorf("max_staleness must not be negative for collector %q", c.Name)
+	}
+	if c.MaxStaleness > 0 && !c.StaleWhileRevalidate {
+		return fmt.Errorf("max_staleness requires stale_while_revalidate for collector %q", c.Name)
+	}
 
 	// Set metric.query for all metrics: resolve query references (if any) and generate QueryConfigs for literal queries.
 	queries := make(map[string]*QueryConfig, len(c.Queries))
@@ -54,6 +79,10 @@ func (c *CollectorConfig) UnmarshalYAML(unmarshal func(any) error) error {
 				Name:                metric.Name,
 				Query:               metric.QueryLiteral,
 				NoPreparedStatement: metric.NoPreparedStatement,
+				Params:              metric.Params,
+			}
+			if err := metric.query.parseTemplates(); err != nil {
+				return err
 			}
 		}
 	}
@@ -8,8 +8,10 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
+	"github.com/prometheus/common/model"
 	"github.com/sethvargo/go-envconfig"
 	"go.yaml.in/yaml/v3"
 )
@@ -72,6 +74,8 @@ type Config struct {
 	Target         *TargetConfig      `yaml:"target,omitempty" env:", prefix=TARGET_"`
 	Jobs           []*JobConfig       `yaml:"jobs,omitempty"`
 	Collectors     []*CollectorConfig `yaml:"collectors,omitempty"`
+	Push           *PushConfig        `yaml:"push,omitempty"`
+	Probe          *ProbeConfig       `yaml:"probe,omitempty"`
 
 	configFile string
 
@@ -95,6 +99,9 @@ func (c *Config) UnmarshalYAML(unmarshal func(any) error) error {
 		return err
 	}
 
+	// Merge the connection settings of the target/jobs with the globals.
+	c.mergeConnectionConfigs()
+
 	// Load any externally defined collectors.
 	if err := c.loadCollectorFiles(); err != nil {
 		return err
@@ -110,6 +117,13 @@ func (c *Config) UnmarshalYAML(unmarshal func(any) error) error {
 		return err
 	}
 
+	c.resolveFileSDPaths()
+
+	// Make sure query templates can be rendered for every target.
+	if err := c.checkQueryTemplates(); err != nil {
+		return err
+	}
+
 	return checkOverflow(c.XXX, "config")
 }
 
@@ -143,8 +157,9 @@ func (c *Config) processEnvConfig() error {
 
 // checkRequiredFields checks that all required fields are present.
 func (c *Config) checkRequiredFields() error {
-	if (len(c.Jobs) == 0) == (c.Target == nil) {
-		return fmt.Errorf("exactly one of `jobs` and `target` must be defined")
+	// A probe-only exporter needs neither jobs nor a target.
+	if len(c.Jobs) > 0 && c.Target != nil || len(c.Jobs) == 0 && c.Target == nil && c.Probe == nil {
+		return fmt.Errorf("exactly one of `jobs` and `target` must be defined, or neither with `probe`")
 	}
 
 	// Check target configuration
@@ -165,8 +180,8 @@ func (c *Config) checkRequiredFields() error {
 		if len(job.CollectorRefs) == 0 {
 			return fmt.Errorf("job[%d].collectors is required", i)
 		}
-		if len(job.StaticConfigs) == 0 {
-			return fmt.Errorf("job[%d].static_configs is required", i)
+		if len(job.StaticConfigs) == 0 && len(job.SDConfigs()) == 0 {
+			return fmt.Errorf("job[%d].static_configs or a service discovery config is required", i)
 		}
 		for j, staticConfig := range job.StaticConfigs {
 			if len(staticConfig.Targets) == 0 {
@@ -178,13 +193,61 @@ func (c *Config) checkRequiredFields() error {
 	return nil
 }
 
+// mergeConnectionConfigs sets the connection settings the target, jobs and static configs don't override to the ones
+// they inherit, so that each holds its effective settings.
+func (c *Config) mergeConnectionConfigs() {
+	globals := globalConnectionConfig(c.Globals)
+	if c.Target != nil {
+		c.Target.inherit(globals)
+	}
+	for _, j := range c.Jobs {
+		j.inherit(globals)
+		for _, sc := range j.StaticConfigs {
+			sc.inherit(j.ConnectionConfig)
+		}
+	}
+}
+
+// ScrapeTimeout returns the longest scrape timeout of the globals, target and jobs, or 0 if any is unlimited.
+func (c *Config) ScrapeTimeout() model.Duration {
+	timeouts := []model.Duration{c.Globals.ScrapeTimeout}
+	add := func(cc ConnectionConfig) {
+		if cc.ScrapeTimeout != nil {
+			timeouts = append(timeouts, *cc.ScrapeTimeout)
+		}
+	}
+	if c.Target != nil {
+		add(c.Target.ConnectionConfig)
+	}
+	for _, j := range c.Jobs {
+		add(j.ConnectionConfig)
+		for _, sc := range j.StaticConfigs {
+			add(sc.ConnectionConfig)
+		}
+	}
+	if slices.Contains(timeouts, 0) {
+		return 0
+	}
+	return slices.Max(timeouts)
+}
+
 // populateCollectorReferences populates collector references for the target/jobs.
 func (c *Config) populateCollectorReferences() error {
 	colls := make(map[string]*CollectorConfig)
 	for _, coll := range c.Collectors {
-		if coll.MinInterval < 0 {
+		// Scheduled collectors are never cached, the global min_interval doesn't apply to them.
+		if coll.Interval > 0 {
+			coll.MinInterval = 0
+		} else if coll.MinInterval < 0 {
 			coll.MinInterval = c.Globals.MinInterval
 		}
+		// Only cached collectors have expired metrics to serve.
+		if coll.StaleWhileRevalidate && coll.MinInterval == 0 {
+			return fmt.Errorf("stale_while_revalidate requires a min_interval for collector %q", coll.Name)
+		}
+		if coll.MaxStaleness > 0 && coll.MaxStaleness < coll.MinInterval {
+			return fmt.Errorf("max_staleness must not be less than min_interval for collector %q", coll.Name)
+		}
 		if _, found := colls[coll.Name]; found {
 			return fmt.Errorf("duplicate collector name: %s", coll.Name)
 		}
@@ -207,6 +270,81 @@ func (c *Config) populateCollectorReferences() error {
 		}
 		j.collectors = cs
 	}
+
+	if c.Probe != nil {
+		for _, m := range c.Probe.Modules {
+			cs, err := resolveCollectorRefs(m.CollectorRefs, colls, fmt.Sprintf("probe module %q", m.Name))
+			if err != nil {
+				return err
+			}
+			// Probe targets only live as long as they're probed, they don't run collectors in the background.
+			for _, coll := range cs {
+				if coll.Interval > 0 {
+					return fmt.Errorf("scheduled collector %q (with an interval) is not supported by probe module %q",
+						coll.Name, m.Name)
+				}
+			}
+			m.collectors = cs
+		}
+	}
+	return nil
+}
+
+// resolveFileSDPaths resolves relative file_sd paths by joining them to the configuration file's directory.
+func (c *Config) resolveFileSDPaths() {
+	baseDir := filepath.Dir(c.configFile)
+	for _, job := range c.Jobs {
+		for _, sd := range job.FileSDConfigs {
+			for i, file := range sd.Files {
+				if !filepath.IsAbs(file) {
+					sd.Files[i] = filepath.Join(baseDir, file)
+				}
+			}
+		}
+	}
+}
+
+// checkQueryTemplates renders the query templates of all collectors for every target they apply to, so that e.g.
+// references to missing labels are reported on load rather than on the first scrape.
+func (c *Config) checkQueryTemplates() error {
+	if c.Target != nil {
+		data := QueryTemplateData{Target: c.Target.Name, Labels: map[string]string{}}
+		if c.Target.Name != "" {
+			data.Labels[TargetLabel] = c.Target.Name
+		}
+		if err := renderQueryTemplates(c.Target.collectors, data, "target"); err != nil {
+			return err
+		}
+	}
+
+	for _, j := range c.Jobs {
+		for _, sc := range j.StaticConfigs {
+			for tname := range sc.Targets {
+				// Same labels as applied to the target's metrics.
+				labels := map[string]string{"job": j.Name, TargetLabel: tname}
+				for name, value := range sc.Labels {
+					labels[name] = value
+				}
+				data := QueryTemplateData{Target: tname, Job: j.Name, Labels: labels}
+				if err := renderQueryTemplates(j.collectors, data, fmt.Sprintf("target %q of job %q", tname,
+					j.Name)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// renderQueryTemplates renders the query templates of the provided collectors with the provided data.
+func renderQueryTemplates(collectors []*CollectorConfig, data QueryTemplateData, ctx string) error {
+	for _, coll := range collectors {
+		for _, m := range coll.Metrics {
+			if _, _, err := m.Query().Render(data); err != nil {
+				return fmt.Errorf("%w, in collector %q for %s", err, coll.Name, ctx)
+			}
+		}
+	}
 	return nil
 }
 
@@ -292,34 +430,70 @@ func (c *Config) resolveSecrets() error {
 	resolver := &secretResolver{} // scoped here, GC'd when resolveSecrets returns
 
 	if c.Target != nil {
-		if isSecretRef(string(c.Target.DSN)) {
-			dsn, err := resolver.resolve(ctx, string(c.Target.DSN))
-			if err != nil {
-				return fmt.Errorf("error resolving target DSN: %w", err)
-			}
-			c.Target.DSN = Secret(dsn)
+		refs, err := resolver.resolveTarget(ctx, &c.Target.DSN, &c.Target.Username, &c.Target.Password)
+		if err != nil {
+			return fmt.Errorf("error resolving target DSN or credentials: %w", err)
 		}
+		// Keep the references, so that the secrets can be re-resolved later.
+		c.Target.refs = refs
 	}
 	// Maps are reference types, so this will update the DSNs in place for all targets defined in jobs.
 	for _, job := range c.Jobs {
 		for _, staticConfig := range job.StaticConfigs {
 			for targetName, dsn := range staticConfig.Targets {
-				if !isSecretRef(string(dsn)) {
-					continue
-				}
-				resolved, err := resolver.resolve(ctx, string(dsn))
+				creds := staticConfig.credentials[targetName]
+				refs, err := resolver.resolveTarget(ctx, &dsn, &creds.Username, &creds.Password)
 				if err != nil {
-					return fmt.Errorf("error resolving DSN for target %q in job %q: %w", targetName,
+					return fmt.Errorf("error resolving DSN or credentials for target %q in job %q: %w", targetName,
 						job.Name, err)
 				}
-				staticConfig.Targets[targetName] = Secret(resolved)
+				if refs.IsZero() {
+					continue
+				}
+				if staticConfig.refs == nil {
+					staticConfig.refs = make(map[string]SecretRefs)
+				}
+				staticConfig.refs[targetName] = refs
+				staticConfig.Targets[targetName] = dsn
+				if creds != (Credentials{}) {
+					staticConfig.credentials[targetName] = creds
+				}
 			}
 		}
+		// Discovered targets share the credentials of their discovery config, the data source name is a template.
+		for _, sd := range job.SDConfigs() {
+			st := sd.Target()
+			var dsn Secret
+			refs, err := resolver.resolveTarget(ctx, &dsn, &st.Username, &st.Password)
+			if err != nil {
+				return fmt.Errorf("error resolving credentials of %s_sd_config in job %q: %w", sd.Mechanism(), job.Name,
+					err)
+			}
+			st.refs = refs
+		}
 	}
 
 	return nil
 }
 
+// ResolveSecrets resolves the provided secret references, returning the resolved values by reference. Secrets
+// referenced more than once are only fetched once.
+func ResolveSecrets(ctx context.Context, refs []string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, secretResolutionTimeout)
+	defer cancel()
+	resolver := &secretResolver{}
+
+	resolved := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		value, err := resolver.resolve(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		resolved[ref] = value
+	}
+	return resolved, nil
+}
+
 // isSecretRef checks if the given value is a secret reference by parsing it as a URL and checking if the scheme matches
 // any registered secret provider.
 func isSecretRef(value string) bool {
@@ -3,6 +3,9 @@ package config
 import (
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
 )
 
 func TestResolveCollectorRefs(t *testing.T) {
@@ -88,3 +91,27 @@ func TestResolveCollectorRefs(t *testing.T) {
 		}
 	})
 }
+
+func TestPopulateCollectorReferences_StaleWhileRevalidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		coll    CollectorConfig
+		wantErr bool
+	}{
+		{"GlobalMinInterval", CollectorConfig{MinInterval: -1, StaleWhileRevalidate: true}, false},
+		{"NoMinInterval", CollectorConfig{MinInterval: 0, StaleWhileRevalidate: true}, true},
+		{"MaxStaleness", CollectorConfig{MinInterval: -1, StaleWhileRevalidate: true, MaxStaleness: model.Duration(time.Hour)}, false},
+		{"MaxStalenessBelowMinInterval", CollectorConfig{MinInterval: -1, StaleWhileRevalidate: true, MaxStaleness: model.Duration(time.Second)}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			coll := tc.coll
+			c := &Config{
+				Globals:    &GlobalConfig{MinInterval: model.Duration(time.Minute)},
+				Collectors: []*CollectorConfig{&coll},
+			}
+			if err := c.populateCollectorReferences(); (err != nil) != tc.wantErr {
+				t.Fatalf("expected error: %t, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// ConnectionConfig overrides the global connection settings and scrape timeout for the targets of a job, static config
+// or the single target. Unset settings are inherited at config load: static configs from their job, jobs and the
+// single target from the globals.
+type ConnectionConfig struct {
+	MaxConns        *int            `yaml:"max_connections,omitempty"`         // maximum number of open connections to any one target
+	MaxIdleConns    *int            `yaml:"max_idle_connections,omitempty"`    // maximum number of idle connections to any one target
+	MaxConnLifetime *time.Duration  `yaml:"max_connection_lifetime,omitempty"` // maximum amount of time a connection may be reused
+	PingInterval    *model.Duration `yaml:"ping_interval,omitempty"`           // interval between database pings
+	ScrapeTimeout   *model.Duration `yaml:"scrape_timeout,omitempty"`          // per-scrape timeout
+}
+
+// check checks that the settings are non-negative.
+func (c *ConnectionConfig) check(context string) error {
+	switch {
+	case c.MaxConns != nil && *c.MaxConns < 0:
+		return fmt.Errorf("%s: max_connections must be non-negative, have %d", context, *c.MaxConns)
+	case c.MaxIdleConns != nil && *c.MaxIdleConns < 0:
+		return fmt.Errorf("%s: max_idle_connections must be non-negative, have %d", context, *c.MaxIdleConns)
+	case c.MaxConnLifetime != nil && *c.MaxConnLifetime < 0:
+		return fmt.Errorf("%s: max_connection_lifetime must be non-negative, have %s", context, *c.MaxConnLifetime)
+	case c.PingInterval != nil && *c.PingInterval < 0:
+		return fmt.Errorf("%s: ping_interval must be non-negative, have %s", context, *c.PingInterval)
+	case c.ScrapeTimeout != nil && *c.ScrapeTimeout < 0:
+		return fmt.Errorf("%s: scrape_timeout must be non-negative, have %s", context, *c.ScrapeTimeout)
+	}
+	return nil
+}
+
+// inherit sets the unset settings to the parent's.
+func (c *ConnectionConfig) inherit(parent ConnectionConfig) {
+	if c.MaxConns == nil {
+		c.MaxConns = parent.MaxConns
+	}
+	if c.MaxIdleConns == nil {
+		c.MaxIdleConns = parent.MaxIdleConns
+	}
+	if c.MaxConnLifetime == nil {
+		c.MaxConnLifetime = parent.MaxConnLifetime
+	}
+	if c.PingInterval == nil {
+		c.PingInterval = parent.PingInterval
+	}
+	if c.ScrapeTimeout == nil {
+		c.ScrapeTimeout = parent.ScrapeTimeout
+	}
+}
+
+// Apply returns the global config with the settings that are set replacing the global ones.
+func (c ConnectionConfig) Apply(g *GlobalConfig) *GlobalConfig {
+	if c == (ConnectionConfig{}) {
+		return g
+	}
+	merged := *g
+	if c.MaxConns != nil {
+		merged.MaxConns = *c.MaxConns
+	}
+	if c.MaxIdleConns != nil {
+		merged.MaxIdleConns = *c.MaxIdleConns
+	}
+	if c.MaxConnLifetime != nil {
+		merged.MaxConnLifetime = *c.MaxConnLifetime
+	}
+	if c.PingInterval != nil {
+		merged.PingInterval = *c.PingInterval
+	}
+	if c.ScrapeTimeout != nil {
+		merged.ScrapeTimeout = *c.ScrapeTimeout
+	}
+	return &merged
+}
+
+// globalConnectionConfig returns the global connection settings, as the root all overrides inherit from.
+func globalConnectionConfig(g *GlobalConfig) ConnectionConfig {
+	// Copies, so that the merged configs don't share the globals' fields.
+	c := *g
+	return ConnectionConfig{
+		MaxConns:        &c.MaxConns,
+		MaxIdleConns:    &c.MaxIdleConns,
+		MaxConnLifetime: &c.MaxConnLifetime,
+		PingInterval:    &c.PingInterval,
+		ScrapeTimeout:   &c.ScrapeTimeout,
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+const connectionConfig = `
+global:
+  max_connection_lifetime: 5m
+jobs:
+  - job_name: warehouse
+    collectors: [pg]
+    max_connections: 20
+    scrape_timeout: 1m
+    static_configs:
+      - targets:
+          dwh: postgres://dwh:5432/app
+      - targets:
+          staging: postgres://staging:5432/app
+        max_idle_connections: 1
+        %s
+collectors:
+  - collector_name: pg
+    metrics:
+      - metric_name: up_rows
+        type: gauge
+        help: Rows.
+        values: [n]
+        query: SELECT 1 AS n
+`
+
+func TestConnectionConfig(t *testing.T) {
+	c, err := loadConfigString(t, strings.Replace(connectionConfig, "%s", "", 1))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	job := c.Jobs[0]
+	if *job.MaxConns != 20 || *job.MaxIdleConns != 3 || *job.MaxConnLifetime != 5*time.Minute ||
+		*job.ScrapeTimeout != model.Duration(time.Minute) {
+		t.Errorf("unexpected job connection settings: %+v", job.ConnectionConfig)
+	}
+	gc := job.StaticConfigs[1].Apply(c.Globals)
+	if gc.MaxConns != 20 || gc.MaxIdleConns != 1 || gc.MaxConnLifetime != 5*time.Minute ||
+		gc.ScrapeTimeout != model.Duration(time.Minute) {
+		t.Errorf("unexpected static config connection settings: %+v", gc)
+	}
+	if c.Globals.MaxConns != 3 || c.Globals.ScrapeTimeout != model.Duration(10*time.Second) {
+		t.Errorf("expected the globals to be left alone, got %+v", c.Globals)
+	}
+	if c.ScrapeTimeout() != model.Duration(time.Minute) {
+		t.Errorf("ScrapeTimeout = %s, want the longest one", c.ScrapeTimeout())
+	}
+	// The effective settings are shown on the /config page.
+	if y, err := c.YAML(); err != nil || !strings.Contains(string(y), "max_idle_connections: 1") {
+		t.Errorf("expected the merged settings in the config YAML, got %s (%v)", y, err)
+	}
+
+	// An unlimited scrape timeout makes the request's context unlimited too.
+	c, err = loadConfigString(t, strings.Replace(connectionConfig, "%s", "scrape_timeout: 0s", 1))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.ScrapeTimeout() != 0 {
+		t.Errorf("ScrapeTimeout = %s, want 0", c.ScrapeTimeout())
+	}
+
+	_, err = loadConfigString(t, strings.Replace(connectionConfig, "%s", "max_connections: -1", 1))
+	if err == nil || !strings.Contains(err.Error(), "max_connections must be non-negative") {
+		t.Errorf("expected an error for a negative max_connections, got %v", err)
+	}
+
+	// Negative durations can't be written in YAML, but are rejected all the same.
+	negative := model.Duration(-time.Second)
+	for name, cc := range map[string]ConnectionConfig{
+		"ping_interval":  {PingInterval: &negative},
+		"scrape_timeout": {ScrapeTimeout: &negative},
+	} {
+		if err := cc.check("job"); err == nil || !strings.Contains(err.Error(), name+" must be non-negative") {
+			t.Errorf("expected an error for a negative %s, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// ProxySchemes are the supported proxy_url schemes: SOCKS5 (both resolve host names on the proxy) and HTTP CONNECT
+// proxies.
+var ProxySchemes = []string{"socks5", "socks5h", "http"}
+
+// SSHTunnelConfig defines an SSH server (e.g. a bastion host) the connections to a target are forwarded through.
+type SSHTunnelConfig struct {
+	Address               string `yaml:"address"`                            // host:port of the SSH server; port defaults to 22
+	User                  string `yaml:"user"`                               // SSH user
+	Password              Secret `yaml:"password,omitempty"`                 // password authentication
+	PrivateKeyFile        string `yaml:"private_key_file,omitempty"`         // public key authentication, unencrypted key
+	KnownHostsFile        string `yaml:"known_hosts_file,omitempty"`         // defaults to ~/.ssh/known_hosts
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key,omitempty"` // disables host key verification
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for SSHTunnelConfig.
+func (c *SSHTunnelConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	type plain SSHTunnelConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	if c.Address == "" || c.User == "" {
+		return fmt.Errorf("missing address or user for ssh_tunnel")
+	}
+	if c.Password == "" && c.PrivateKeyFile == "" {
+		return fmt.Errorf("ssh_tunnel requires a password or private_key_file")
+	}
+	if c.KnownHostsFile != "" && c.InsecureIgnoreHostKey {
+		return fmt.Errorf("known_hosts_file and insecure_ignore_host_key are mutually exclusive for ssh_tunnel")
+	}
+
+	return checkOverflow(c.XXX, "ssh_tunnel")
+}
+
+// checkDialer checks the proxy URL and SSH tunnel of a target, of which at most one may be set.
+func checkDialer(proxyURL string, tunnel *SSHTunnelConfig) error {
+	if proxyURL == "" {
+		return nil
+	}
+	if tunnel != nil {
+		return fmt.Errorf("proxy_url and ssh_tunnel are mutually exclusive")
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy_url: %w", err)
+	}
+	if !slices.Contains(ProxySchemes, u.Scheme) || u.Host == "" {
+		return fmt.Errorf("unsupported proxy_url %q, must be <scheme>://<host>:<port> with a scheme of %v",
+			u.Redacted(), ProxySchemes)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+
+	"go.yaml.in/yaml/v3"
+)
+
+func TestDialerConfig(t *testing.T) {
+	content := `
+targets:
+  db1:
+    data_source_name: postgres://db1.internal:5432/postgres
+    proxy_url: socks5h://bastion:1080
+  db2:
+    data_source_name: postgres://db2.internal:5432/postgres
+    ssh_tunnel:
+      address: bastion:22
+      user: monitor
+      private_key_file: /etc/sql_exporter/id_ed25519
+`
+	sc := &StaticConfig{}
+	if err := yaml.Unmarshal([]byte(content), sc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if sc.ProxyURL("db1") != "socks5h://bastion:1080" || sc.SSHTunnel("db1") != nil {
+		t.Errorf("unexpected proxy_url/ssh_tunnel for db1: %q, %+v", sc.ProxyURL("db1"), sc.SSHTunnel("db1"))
+	}
+	if st := sc.SSHTunnel("db2"); st == nil || st.User != "monitor" || sc.ProxyURL("db2") != "" {
+		t.Errorf("unexpected proxy_url/ssh_tunnel for db2: %q, %+v", sc.ProxyURL("db2"), st)
+	}
+
+	for _, invalid := range []string{
+		"data_source_name: x\nproxy_url: ftp://bastion:21",
+		"data_source_name: x\nproxy_url: socks5://",
+		"data_source_name: x\nproxy_url: http://bastion:3128\nssh_tunnel: {address: bastion, user: u, password: p}",
+		"data_source_name: x\nssh_tunnel: {address: bastion, user: u}",
+		"data_source_name: x\nssh_tunnel: {address: bastion, user: u, password: p, port: 22}",
+		"data_source_name: x\nssh_tunnel: {address: bastion, user: u, password: p, known_hosts_file: k, insecure_ignore_host_key: true}",
+	} {
+		if err := yaml.Unmarshal([]byte(invalid), &staticTarget{}); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
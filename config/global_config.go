@@ -15,14 +15,21 @@ type GlobalConfig struct {
 	ScrapeErrorDropInterval model.Duration `yaml:"scrape_error_drop_interval" env:"SCRAPE_ERROR_DROP_INTERVAL"` // interval to drop scrape errors from the error counter, default is 0
 	MaxConnLifetime         time.Duration  `yaml:"max_connection_lifetime" env:"MAX_CONNECTION_LIFETIME"`       // maximum amount of time a connection may be reused to any one target
 	PingInterval            model.Duration `yaml:"ping_interval" env:"PING_INTERVAL"`                           // interval between database pings, default is 0
+	SecretRefreshInterval   model.Duration `yaml:"secret_refresh_interval" env:"SECRET_REFRESH_INTERVAL"`       // interval between secret reference re-resolutions, default is 0 (disabled)
 
 	WarmupDelay model.Duration `yaml:"warmup_delay,omitempty" env:"WARMUP_DELAY"` // delay between executing collectors during cache population at startup, default is 0
 
 	MaxConns     int `yaml:"max_connections" env:"MAX_CONNECTIONS"`           // maximum number of open connections to any one target
 	MaxIdleConns int `yaml:"max_idle_connections" env:"MAX_IDLE_CONNECTIONS"` // maximum number of idle connections to any one target
 
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries,omitempty" env:"MAX_CONCURRENT_QUERIES"` // maximum number of queries running at once across all targets, default is 0 (unlimited)
+
 	EnableQueryMetrics bool `yaml:"enable_query_metrics,omitempty" env:"ENABLE_QUERY_METRICS"` // expose per-query duration and row count metrics
 
+	OTLP *OTLPConfig `yaml:"otlp,omitempty"` // export metrics to an OpenTelemetry collector, disabled if not set
+
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty"` // back off from unreachable targets, disabled if not set
+
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]any `yaml:",inline" json:"-"`
 }
@@ -39,6 +46,8 @@ func (g *GlobalConfig) UnmarshalYAML(unmarshal func(any) error) error {
 	g.TimeoutOffset = model.Duration(500 * time.Millisecond)
 	// Default to 0 for ping interval (no interval, ping every time when call target.ping()).
 	g.PingInterval = model.Duration(0)
+	// Default to 0 for secret refresh interval (secrets are only resolved on load).
+	g.SecretRefreshInterval = model.Duration(0)
 	g.MaxConns = 3
 	g.MaxIdleConns = 3
 	g.MaxConnLifetime = time.Duration(0)
@@ -50,6 +59,12 @@ func (g *GlobalConfig) UnmarshalYAML(unmarshal func(any) error) error {
 		return err
 	}
 
+	if g.SecretRefreshInterval < 0 {
+		return fmt.Errorf("global.secret_refresh_interval must be non-negative, have %s", g.SecretRefreshInterval)
+	}
+	if g.MaxConcurrentQueries < 0 {
+		return fmt.Errorf("global.max_concurrent_queries must be non-negative, have %d", g.MaxConcurrentQueries)
+	}
 	if g.TimeoutOffset <= 0 {
 		return fmt.Errorf("global.scrape_timeout_offset must be strictly positive, have %s", g.TimeoutOffset)
 	}
@@ -8,14 +8,22 @@ import "fmt"
 
 // JobConfig defines a set of collectors to be executed on a set of targets.
 type JobConfig struct {
-	Name          string          `yaml:"job_name"`       // name of this job
-	CollectorRefs []string        `yaml:"collectors"`     // names of collectors to apply to all targets in this job
-	StaticConfigs []*StaticConfig `yaml:"static_configs"` // collections of statically defined targets
+	Name          string          `yaml:"job_name"`                  // name of this job
+	CollectorRefs []string        `yaml:"collectors"`                // names of collectors to apply to all targets in this job
+	StaticConfigs []*StaticConfig `yaml:"static_configs"`            // collections of statically defined targets
+	FileSDConfigs []*FileSDConfig `yaml:"file_sd_configs,omitempty"` // targets discovered from files
+	DNSSDConfigs  []*DNSSDConfig  `yaml:"dns_sd_configs,omitempty"`  // targets discovered from DNS records
+	HTTPSDConfigs []*HTTPSDConfig `yaml:"http_sd_configs,omitempty"` // targets discovered from an HTTP endpoint
+	TLS           *TLSConfig      `yaml:"tls_config,omitempty"`      // TLS configuration of the connections to all targets
 
 	collectors []*CollectorConfig // resolved collector references
 
 	EnablePing *bool `yaml:"enable_ping,omitempty"` // ping the target before executing the collectors
 
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries,omitempty"` // maximum number of queries running at once on any one target
+
+	ConnectionConfig `yaml:",inline"` // connection settings overriding the global ones for all targets in this job
+
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]any `yaml:",inline" json:"-"`
 }
@@ -25,6 +33,21 @@ func (j *JobConfig) Collectors() []*CollectorConfig {
 	return j.collectors
 }
 
+// SDConfigs returns all the service discovery configs of the job.
+func (j *JobConfig) SDConfigs() []SDConfig {
+	sds := make([]SDConfig, 0, len(j.FileSDConfigs)+len(j.DNSSDConfigs)+len(j.HTTPSDConfigs))
+	for _, sd := range j.FileSDConfigs {
+		sds = append(sds, sd)
+	}
+	for _, sd := range j.DNSSDConfigs {
+		sds = append(sds, sd)
+	}
+	for _, sd := range j.HTTPSDConfigs {
+		sds = append(sds, sd)
+	}
+	return sds
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface for JobConfig.
 func (j *JobConfig) UnmarshalYAML(unmarshal func(any) error) error {
 	type plain JobConfig
@@ -40,8 +63,14 @@ func (j *JobConfig) UnmarshalYAML(unmarshal func(any) error) error {
 		return err
 	}
 
-	if len(j.StaticConfigs) == 0 {
-		return fmt.Errorf("no targets defined for job %q", j.Name)
+	if len(j.StaticConfigs) == 0 && len(j.SDConfigs()) == 0 {
+		return fmt.Errorf("no targets or service discovery configs defined for job %q", j.Name)
+	}
+	if err := j.ConnectionConfig.check(fmt.Sprintf("job %q", j.Name)); err != nil {
+		return err
+	}
+	if j.MaxConcurrentQueries < 0 {
+		return fmt.Errorf("max_concurrent_queries must be non-negative for job %q", j.Name)
 	}
 
 	return checkOverflow(j.XXX, "job")
@@ -77,18 +106,136 @@ type StaticConfig struct {
 	Targets map[string]Secret `yaml:"targets"`          // map of target names to data source names
 	Labels  map[string]string `yaml:"labels,omitempty"` // labels to apply to all metrics collected from the targets
 
+	ConnectionConfig `yaml:",inline"` // connection settings overriding the job's for the targets
+
+	credentials map[string]Credentials      // credentials to merge into the data source names, by target name
+	auth        map[string]*AuthConfig      // token based authentication, by target name
+	tls         map[string]*TLSConfig       // TLS configuration of the connections, by target name
+	proxyURLs   map[string]string           // proxies to connect through, by target name
+	sshTunnels  map[string]*SSHTunnelConfig // SSH servers to forward the connections through, by target name
+	refs        map[string]SecretRefs       // secret references the DSNs and credentials were resolved from, by target name
+
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]any `yaml:",inline" json:"-"`
 }
 
+// Credentials returns the username and password to merge into the target's data source name.
+func (s *StaticConfig) Credentials(target string) Credentials {
+	return s.credentials[target]
+}
+
+// Auth returns the target's token based authentication, if any.
+func (s *StaticConfig) Auth(target string) *AuthConfig {
+	return s.auth[target]
+}
+
+// TLS returns the TLS configuration of the connections to the target, if any.
+func (s *StaticConfig) TLS(target string) *TLSConfig {
+	return s.tls[target]
+}
+
+// ProxyURL returns the URL of the proxy to connect to the target through, if any.
+func (s *StaticConfig) ProxyURL(target string) string {
+	return s.proxyURLs[target]
+}
+
+// SSHTunnel returns the SSH server to forward the connections to the target through, if any.
+func (s *StaticConfig) SSHTunnel(target string) *SSHTunnelConfig {
+	return s.sshTunnels[target]
+}
+
+// SecretRefs returns the secret references the target's data source name and credentials were resolved from.
+func (s *StaticConfig) SecretRefs(target string) SecretRefs {
+	return s.refs[target]
+}
+
+// staticTarget is a target in a static config: either a data source name or an object with a data source name and
+// credentials.
+type staticTarget struct {
+	DSN         Secret `yaml:"data_source_name"`
+	Credentials `yaml:",inline"`
+	Auth        *AuthConfig      `yaml:"auth,omitempty"`
+	TLS         *TLSConfig       `yaml:"tls_config,omitempty"`
+	ProxyURL    string           `yaml:"proxy_url,omitempty"`
+	SSHTunnel   *SSHTunnelConfig `yaml:"ssh_tunnel,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for staticTarget.
+func (t *staticTarget) UnmarshalYAML(unmarshal func(any) error) error {
+	var dsn string
+	if err := unmarshal(&dsn); err == nil {
+		t.DSN = Secret(dsn)
+		return nil
+	}
+
+	type plain staticTarget
+	if err := unmarshal((*plain)(t)); err != nil {
+		return err
+	}
+	if err := checkDialer(t.ProxyURL, t.SSHTunnel); err != nil {
+		return err
+	}
+	return checkOverflow(t.XXX, "static_config target")
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface for StaticConfig.
 func (s *StaticConfig) UnmarshalYAML(unmarshal func(any) error) error {
-	type plain StaticConfig
-	if err := unmarshal((*plain)(s)); err != nil {
+	// Same as StaticConfig, except for targets which may also be objects.
+	var raw struct {
+		Targets map[string]staticTarget `yaml:"targets"`
+		Labels  map[string]string       `yaml:"labels,omitempty"`
+
+		ConnectionConfig `yaml:",inline"`
+
+		XXX map[string]any `yaml:",inline"`
+	}
+	if err := unmarshal(&raw); err != nil {
 		return err
 	}
+	if err := raw.ConnectionConfig.check("static_config"); err != nil {
+		return err
+	}
+	s.Labels, s.ConnectionConfig, s.XXX = raw.Labels, raw.ConnectionConfig, raw.XXX
+	s.Targets = make(map[string]Secret, len(raw.Targets))
+	for tname, t := range raw.Targets {
+		s.Targets[tname] = t.DSN
+		if t.Credentials != (Credentials{}) {
+			if s.credentials == nil {
+				s.credentials = make(map[string]Credentials)
+			}
+			s.credentials[tname] = t.Credentials
+		}
+		if t.Auth != nil {
+			if s.auth == nil {
+				s.auth = make(map[string]*AuthConfig)
+			}
+			s.auth[tname] = t.Auth
+		}
+		if t.TLS != nil {
+			if s.tls == nil {
+				s.tls = make(map[string]*TLSConfig)
+			}
+			s.tls[tname] = t.TLS
+		}
+		if t.ProxyURL != "" {
+			if s.proxyURLs == nil {
+				s.proxyURLs = make(map[string]string)
+			}
+			s.proxyURLs[tname] = t.ProxyURL
+		}
+		if t.SSHTunnel != nil {
+			if s.sshTunnels == nil {
+				s.sshTunnels = make(map[string]*SSHTunnelConfig)
+			}
+			s.sshTunnels[tname] = t.SSHTunnel
+		}
+	}
 
-	// Check for empty/duplicate target names/data source names
+	// Check for empty/duplicate target names/data source names. The same data source name may be used with different
+	// usernames.
 	tnames := make(map[string]any)
 	dsns := make(map[string]any)
 	for tname, dsn := range s.Targets {
@@ -102,10 +249,11 @@ func (s *StaticConfig) UnmarshalYAML(unmarshal func(any) error) error {
 		if dsn == "" {
 			return fmt.Errorf("empty data source name in static config %+v", s)
 		}
-		if _, ok := dsns[string(dsn)]; ok {
+		key := string(dsn) + "\x00" + string(s.credentials[tname].Username)
+		if _, ok := dsns[key]; ok {
 			return fmt.Errorf("duplicate data source name %q in static_config %+v", tname, s)
 		}
-		dsns[string(dsn)] = nil
+		dsns[key] = nil
 	}
 
 	return checkOverflow(s.XXX, "static_config")
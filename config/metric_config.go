@@ -22,21 +22,55 @@ type MetricConfig struct {
 	QueryRef     string            `yaml:"query_ref,omitempty"`     // references a query in the query map
 
 	NoPreparedStatement bool     `yaml:"no_prepared_statement,omitempty"` // do not prepare statement
+	Params              []string `yaml:"params,omitempty"`                // values bound to the literal query placeholders, in order
 	StaticValue         *float64 `yaml:"static_value,omitempty"`
 	TimestampValue      string   `yaml:"timestamp_value,omitempty"` // optional column name containing a valid timestamp value
 
-	valueType prometheus.ValueType // TypeString converted to prometheus.ValueType
-	query     *QueryConfig         // QueryConfig resolved from QueryRef or generated from Query
+	LeValue       string `yaml:"le_value,omitempty"`       // histogram only: column containing the bucket upper bound
+	QuantileValue string `yaml:"quantile_value,omitempty"` // summary only: column containing the quantile (0-1)
+	SumValue      string `yaml:"sum_value,omitempty"`      // histogram/summary only: column containing the sum of observations
+	CountValue    string `yaml:"count_value,omitempty"`    // histogram/summary only: column containing the count of observations
+
+	StateValue string   `yaml:"state_value,omitempty"` // stateset only: column containing the current state
+	States     []string `yaml:"states,omitempty"`      // stateset only: the enumerated states
+
+	CreatedTimestampValue string   `yaml:"created_timestamp_value,omitempty"` // counter only: column containing the counter creation time
+	ExemplarLabels        []string `yaml:"exemplar_labels,omitempty"`         // counter only: columns exposed as exemplar labels
+	ExemplarValue         string   `yaml:"exemplar_value,omitempty"`          // counter only: column containing the exemplar value
+
+	metricType MetricType           // TypeString converted to MetricType
+	valueType  prometheus.ValueType // TypeString converted to prometheus.ValueType
+	query      *QueryConfig         // QueryConfig resolved from QueryRef or generated from Query
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]any `yaml:",inline" json:"-"`
 }
 
-// ValueType returns the metric type, converted to a prometheus.ValueType.
+// MetricType enumerates the metric types that can be populated from query results.
+type MetricType int
+
+const (
+	MetricTypeCounter MetricType = iota
+	MetricTypeGauge
+	MetricTypeHistogram
+	MetricTypeSummary
+	MetricTypeUntyped
+	MetricTypeInfo
+	MetricTypeStateset
+)
+
+// ValueType returns the metric type, converted to a prometheus.ValueType. Histograms and summaries have no
+// prometheus.ValueType equivalent and are reported as prometheus.UntypedValue. Info and stateset metrics are exposed as
+// gauges, as the Prometheus data model has no dedicated types for them.
 func (m *MetricConfig) ValueType() prometheus.ValueType {
 	return m.valueType
 }
 
+// MetricType returns the metric type.
+func (m *MetricConfig) MetricType() MetricType {
+	return m.metricType
+}
+
 // Query returns the query defined (as a literal) or referenced by the metric.
 func (m *MetricConfig) Query() *QueryConfig {
 	return m.query
@@ -61,6 +95,18 @@ func (m *MetricConfig) UnmarshalYAML(unmarshal func(any) error) error {
 	if err := m.validateValues(); err != nil {
 		return err
 	}
+	if err := m.validateDistribution(); err != nil {
+		return err
+	}
+	if err := m.validateInfo(); err != nil {
+		return err
+	}
+	if err := m.validateStateset(); err != nil {
+		return err
+	}
+	if err := m.validateOpenMetrics(); err != nil {
+		return err
+	}
 
 	return checkOverflow(m.XXX, "metric")
 }
@@ -79,6 +125,9 @@ func (m *MetricConfig) validateRequiredFields() error {
 	if (m.QueryLiteral == "") == (m.QueryRef == "") {
 		return fmt.Errorf("exactly one of query and query_ref must be specified for metric %q", m.Name)
 	}
+	if m.QueryRef != "" && len(m.Params) > 0 {
+		return fmt.Errorf("params must be defined in the referenced query rather than in metric %q", m.Name)
+	}
 
 	return nil
 }
@@ -87,8 +136,25 @@ func (m *MetricConfig) validateRequiredFields() error {
 func (m *MetricConfig) setValueType() error {
 	switch strings.ToLower(m.TypeString) {
 	case "counter":
+		m.metricType = MetricTypeCounter
 		m.valueType = prometheus.CounterValue
 	case "gauge":
+		m.metricType = MetricTypeGauge
+		m.valueType = prometheus.GaugeValue
+	case "histogram":
+		m.metricType = MetricTypeHistogram
+		m.valueType = prometheus.UntypedValue
+	case "summary":
+		m.metricType = MetricTypeSummary
+		m.valueType = prometheus.UntypedValue
+	case "untyped":
+		m.metricType = MetricTypeUntyped
+		m.valueType = prometheus.UntypedValue
+	case "info":
+		m.metricType = MetricTypeInfo
+		m.valueType = prometheus.GaugeValue
+	case "stateset":
+		m.metricType = MetricTypeStateset
 		m.valueType = prometheus.GaugeValue
 	default:
 		return fmt.Errorf("unsupported metric type: %s", m.TypeString)
@@ -116,6 +182,15 @@ func (m *MetricConfig) validateKeyLabels() error {
 
 // Check for duplicate values
 func (m *MetricConfig) validateValues() error {
+	// Info and stateset metrics derive their value from the row itself.
+	if m.metricType == MetricTypeInfo || m.metricType == MetricTypeStateset {
+		if len(m.Values) > 0 || m.StaticValue != nil || m.ValueLabel != "" {
+			return fmt.Errorf("values, static_value and value_label are not supported by %s metric %q",
+				m.TypeString, m.Name)
+		}
+		return nil
+	}
+
 	if len(m.Values) == 0 && m.StaticValue == nil {
 		return fmt.Errorf("no values defined for metric %q", m.Name)
 	}
@@ -136,3 +211,119 @@ func (m *MetricConfig) validateValues() error {
 
 	return nil
 }
+
+// Check the histogram/summary specific columns. A histogram is built from one row per bucket, with the cumulative
+// bucket count in the single value column and the upper bound in le_value. A summary is built from one row per
+// quantile, with the quantile value in the single value column and the quantile in quantile_value. Rows are grouped
+// into series by key labels, sum_value and count_value are read from the last row of each series.
+func (m *MetricConfig) validateDistribution() error {
+	isHistogram := m.metricType == MetricTypeHistogram
+	isSummary := m.metricType == MetricTypeSummary
+
+	if !isHistogram && !isSummary {
+		if m.LeValue != "" || m.QuantileValue != "" || m.SumValue != "" || m.CountValue != "" {
+			return fmt.Errorf("le_value, quantile_value, sum_value and count_value are only supported by histogram "+
+				"and summary metrics, metric %q", m.Name)
+		}
+		return nil
+	}
+
+	if len(m.Values) != 1 {
+		return fmt.Errorf("%s metric %q must define exactly one value column", m.TypeString, m.Name)
+	}
+	if m.TimestampValue != "" {
+		return fmt.Errorf("timestamp_value is not supported by %s metric %q", m.TypeString, m.Name)
+	}
+	if m.SumValue == "" || m.CountValue == "" {
+		return fmt.Errorf("sum_value and count_value are required for %s metric %q", m.TypeString, m.Name)
+	}
+
+	reserved := "quantile"
+	if isHistogram {
+		if m.LeValue == "" {
+			return fmt.Errorf("le_value is required for histogram metric %q", m.Name)
+		}
+		if m.QuantileValue != "" {
+			return fmt.Errorf("quantile_value is not supported by histogram metric %q", m.Name)
+		}
+		reserved = "le"
+	} else {
+		if m.QuantileValue == "" {
+			return fmt.Errorf("quantile_value is required for summary metric %q", m.Name)
+		}
+		if m.LeValue != "" {
+			return fmt.Errorf("le_value is not supported by summary metric %q", m.Name)
+		}
+	}
+	if slices.Contains(m.KeyLabels, reserved) {
+		return fmt.Errorf("reserved label %q used as key label in %s metric %q", reserved, m.TypeString, m.Name)
+	}
+
+	return nil
+}
+
+// Check the info specific fields. An info metric has a value of 1 and exposes its key labels, plus any columns returned
+// by its query that aren't used by other metrics of the same query, as labels.
+func (m *MetricConfig) validateInfo() error {
+	if m.metricType == MetricTypeInfo && !strings.HasSuffix(m.Name, "_info") {
+		return fmt.Errorf("info metric %q must have a name ending in _info", m.Name)
+	}
+	return nil
+}
+
+// Check the stateset specific fields. A stateset metric exposes one series per state, labeled with the metric name as
+// per OpenMetrics, with a value of 1 for the state found in the state_value column and 0 for all others.
+func (m *MetricConfig) validateStateset() error {
+	if m.metricType != MetricTypeStateset {
+		if m.StateValue != "" || len(m.States) > 0 {
+			return fmt.Errorf("state_value and states are only supported by stateset metrics, metric %q", m.Name)
+		}
+		return nil
+	}
+
+	if m.StateValue == "" {
+		return fmt.Errorf("state_value is required for stateset metric %q", m.Name)
+	}
+	if len(m.States) == 0 {
+		return fmt.Errorf("no states defined for stateset metric %q", m.Name)
+	}
+	for i, state := range m.States {
+		if slices.Contains(m.States[i+1:], state) {
+			return fmt.Errorf("duplicate state %q for stateset metric %q", state, m.Name)
+		}
+	}
+	if slices.Contains(m.KeyLabels, m.Name) {
+		return fmt.Errorf("reserved label %q used as key label in stateset metric %q", m.Name, m.Name)
+	}
+	if slices.Contains(m.KeyLabels, m.StateValue) {
+		return fmt.Errorf("state_value column %q cannot also be a key label in stateset metric %q", m.StateValue,
+			m.Name)
+	}
+
+	return nil
+}
+
+// Check the OpenMetrics specific fields. Created timestamps and exemplars are only exposed to scrapers negotiating the
+// OpenMetrics format, and only supported for counters.
+func (m *MetricConfig) validateOpenMetrics() error {
+	if m.CreatedTimestampValue == "" && len(m.ExemplarLabels) == 0 && m.ExemplarValue == "" {
+		return nil
+	}
+	if m.metricType != MetricTypeCounter {
+		return fmt.Errorf("created_timestamp_value, exemplar_labels and exemplar_value are only supported by counter "+
+			"metrics, metric %q", m.Name)
+	}
+	if m.ExemplarValue != "" && len(m.ExemplarLabels) == 0 {
+		return fmt.Errorf("exemplar_value requires exemplar_labels for metric %q", m.Name)
+	}
+	for i, l := range m.ExemplarLabels {
+		if l == "" {
+			return fmt.Errorf("empty exemplar label defined in metric %q", m.Name)
+		}
+		if slices.Contains(m.ExemplarLabels[i+1:], l) {
+			return fmt.Errorf("duplicate exemplar label %q for metric %q", l, m.Name)
+		}
+	}
+
+	return nil
+}
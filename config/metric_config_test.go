@@ -0,0 +1,112 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"go.yaml.in/yaml/v3"
+)
+
+func TestMetricConfigTypeValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name: "ValidHistogram",
+			yaml: `{metric_name: m, type: histogram, help: h, values: [bucket], le_value: le, sum_value: s,
+				count_value: c, query: q}`,
+		},
+		{
+			name: "ValidSummary",
+			yaml: `{metric_name: m, type: summary, help: h, values: [v], quantile_value: q, sum_value: s,
+				count_value: c, query: q}`,
+		},
+		{
+			name:    "HistogramMissingLe",
+			yaml:    `{metric_name: m, type: histogram, help: h, values: [bucket], sum_value: s, count_value: c, query: q}`,
+			wantErr: "le_value is required",
+		},
+		{
+			name: "HistogramMultipleValues",
+			yaml: `{metric_name: m, type: histogram, help: h, values: [a, b], value_label: v, le_value: le,
+				sum_value: s, count_value: c, query: q}`,
+			wantErr: "exactly one value column",
+		},
+		{
+			name: "HistogramReservedLabel",
+			yaml: `{metric_name: m, type: histogram, help: h, key_labels: [le], values: [b], le_value: le,
+				sum_value: s, count_value: c, query: q}`,
+			wantErr: `reserved label "le"`,
+		},
+		{
+			name:    "SummaryMissingCount",
+			yaml:    `{metric_name: m, type: summary, help: h, values: [v], quantile_value: q, sum_value: s, query: q}`,
+			wantErr: "sum_value and count_value are required",
+		},
+		{
+			name: "ValidStateset",
+			yaml: `{metric_name: role, type: stateset, help: h, state_value: r, states: [a, b], query: q}`,
+		},
+		{
+			name:    "StatesetMissingStates",
+			yaml:    `{metric_name: role, type: stateset, help: h, state_value: r, query: q}`,
+			wantErr: "no states defined",
+		},
+		{
+			name:    "StatesetWithValues",
+			yaml:    `{metric_name: role, type: stateset, help: h, values: [v], state_value: r, states: [a], query: q}`,
+			wantErr: "not supported by stateset metric",
+		},
+		{
+			name: "ValidInfo",
+			yaml: `{metric_name: db_info, type: info, help: h, key_labels: [version], query: q}`,
+		},
+		{
+			name:    "InfoNameSuffix",
+			yaml:    `{metric_name: db, type: info, help: h, key_labels: [version], query: q}`,
+			wantErr: "must have a name ending in _info",
+		},
+		{
+			name: "ValidUntyped",
+			yaml: `{metric_name: m, type: untyped, help: h, values: [v], query: q}`,
+		},
+		{
+			name: "ValidCounterWithExemplar",
+			yaml: `{metric_name: m, type: counter, help: h, values: [v], created_timestamp_value: c,
+				exemplar_labels: [trace_id], query: q}`,
+		},
+		{
+			name:    "GaugeWithCreatedTimestamp",
+			yaml:    `{metric_name: m, type: gauge, help: h, values: [v], created_timestamp_value: c, query: q}`,
+			wantErr: "only supported by counter metrics",
+		},
+		{
+			name:    "ExemplarValueWithoutLabels",
+			yaml:    `{metric_name: m, type: counter, help: h, values: [v], exemplar_value: e, query: q}`,
+			wantErr: "exemplar_value requires exemplar_labels",
+		},
+		{
+			name:    "GaugeWithLe",
+			yaml:    `{metric_name: m, type: gauge, help: h, values: [v], le_value: le, query: q}`,
+			wantErr: "only supported by histogram and summary",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := MetricConfig{}
+			err := yaml.Unmarshal([]byte(tt.yaml), &mc)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error but got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q but got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
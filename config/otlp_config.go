@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	promcfg "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+// OTLP export protocols.
+const (
+	OTLPProtocolHTTP = "http/protobuf"
+	OTLPProtocolGRPC = "grpc"
+)
+
+//
+// OTLP
+//
+
+// OTLPConfig defines an OpenTelemetry collector that metrics are periodically exported to over OTLP, alongside the
+// /metrics endpoint.
+type OTLPConfig struct {
+	Endpoint           string            `yaml:"endpoint"`                      // full URL for http/protobuf, host:port for grpc
+	Protocol           string            `yaml:"protocol"`                      // http/protobuf (default) or grpc
+	Interval           model.Duration    `yaml:"interval"`                      // interval between exports
+	Timeout            model.Duration    `yaml:"timeout"`                       // timeout for collecting and for each export request
+	Headers            map[string]string `yaml:"headers,omitempty"`             // extra headers (or gRPC metadata) sent with each export request
+	Compression        string            `yaml:"compression,omitempty"`         // gzip or none (default)
+	Insecure           bool              `yaml:"insecure,omitempty"`            // grpc only: use a plaintext connection
+	TLSConfig          promcfg.TLSConfig `yaml:"tls_config,omitempty"`          // TLS settings for the collector
+	ResourceAttributes map[string]string `yaml:"resource_attributes,omitempty"` // static attributes added to every resource
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for OTLPConfig.
+func (o *OTLPConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	o.Protocol = OTLPProtocolHTTP
+	o.Interval = model.Duration(time.Minute)
+	o.Timeout = model.Duration(10 * time.Second)
+
+	type plain OTLPConfig
+	if err := unmarshal((*plain)(o)); err != nil {
+		return err
+	}
+
+	if o.Endpoint == "" {
+		return fmt.Errorf("missing endpoint for otlp")
+	}
+	switch o.Protocol {
+	case OTLPProtocolHTTP:
+		u, err := url.Parse(o.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid otlp endpoint %q, must be an absolute http(s) URL", o.Endpoint)
+		}
+		if o.Insecure {
+			return fmt.Errorf("otlp.insecure is only supported by the grpc protocol, use an http:// endpoint instead")
+		}
+	case OTLPProtocolGRPC:
+		if _, _, err := net.SplitHostPort(o.Endpoint); err != nil {
+			return fmt.Errorf("invalid otlp endpoint %q, must be host:port for grpc: %w", o.Endpoint, err)
+		}
+	default:
+		return fmt.Errorf("unsupported otlp protocol %q, must be one of %q or %q", o.Protocol, OTLPProtocolHTTP,
+			OTLPProtocolGRPC)
+	}
+	if o.Compression != "" && o.Compression != "none" && o.Compression != "gzip" {
+		return fmt.Errorf("unsupported otlp compression %q, must be gzip or none", o.Compression)
+	}
+	if o.Interval <= 0 {
+		return fmt.Errorf("otlp.interval must be strictly positive, have %s", o.Interval)
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("otlp.timeout must be strictly positive, have %s", o.Timeout)
+	}
+	if err := o.TLSConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid otlp tls_config: %w", err)
+	}
+
+	return checkOverflow(o.XXX, "otlp")
+}
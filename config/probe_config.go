@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//
+// Probe
+//
+
+// ProbeConfig defines the modules and data source name templates available to the `/probe` endpoint, where the target
+// is chosen by the scraper (e.g. through Prometheus relabeling).
+type ProbeConfig struct {
+	MaxTargets  int                  `yaml:"max_targets,omitempty"` // maximum number of live probe targets, reused across scrapes
+	AuthModules map[string]Secret    `yaml:"auth_modules"`          // data source name templates, by name
+	Modules     []*ProbeModuleConfig `yaml:"modules"`               // sets of collectors to execute on probed targets
+
+	modules      map[string]*ProbeModuleConfig // modules by name
+	dsnTemplates map[string]*template.Template // parsed auth modules
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for ProbeConfig.
+func (p *ProbeConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	p.MaxTargets = 100
+
+	type plain ProbeConfig
+	if err := unmarshal((*plain)(p)); err != nil {
+		return err
+	}
+
+	if p.MaxTargets <= 0 {
+		return fmt.Errorf("probe.max_targets must be strictly positive, have %d", p.MaxTargets)
+	}
+	if len(p.AuthModules) == 0 {
+		return fmt.Errorf("probe.auth_modules is required")
+	}
+	if len(p.Modules) == 0 {
+		return fmt.Errorf("probe.modules is required")
+	}
+
+	p.dsnTemplates = make(map[string]*template.Template, len(p.AuthModules))
+	for name, dsn := range p.AuthModules {
+		t, err := template.New(name).Option("missingkey=error").Parse(string(dsn))
+		if err != nil {
+			return fmt.Errorf("invalid data source name template for probe auth module %q: %w", name, err)
+		}
+		p.dsnTemplates[name] = t
+	}
+
+	p.modules = make(map[string]*ProbeModuleConfig, len(p.Modules))
+	for _, m := range p.Modules {
+		if _, found := p.modules[m.Name]; found {
+			return fmt.Errorf("duplicate probe module name: %s", m.Name)
+		}
+		if _, found := p.AuthModules[m.AuthModule]; !found {
+			return fmt.Errorf("unknown auth_module %q in probe module %q", m.AuthModule, m.Name)
+		}
+		p.modules[m.Name] = m
+	}
+
+	return checkOverflow(p.XXX, "probe")
+}
+
+// Module returns the probe module with the provided name, if any.
+func (p *ProbeConfig) Module(name string) (*ProbeModuleConfig, bool) {
+	m, found := p.modules[name]
+	return m, found
+}
+
+// DataSourceName renders the data source name of the provided target with the named auth module. The target must be
+// a bare host[:port], see CheckProbeTarget.
+func (p *ProbeConfig) DataSourceName(authModule, target string) (string, error) {
+	t, found := p.dsnTemplates[authModule]
+	if !found {
+		return "", fmt.Errorf("unknown auth module %q", authModule)
+	}
+	if err := CheckProbeTarget(target); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, struct{ Target string }{target}); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// CheckProbeTarget returns an error unless the target is a bare host[:port], i.e. a host name or IP address (in
+// brackets if IPv6 with a port) and an optional port. The target comes from the scraper and is interpolated into the
+// data source name, so that anything else could inject a path, parameters or credentials.
+func CheckProbeTarget(target string) error {
+	host, port := target, ""
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		host, port = h, p
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("invalid probe target %q, invalid port", target)
+		}
+	}
+	if net.ParseIP(host) != nil && (port != "" || !strings.ContainsAny(target, "[]")) {
+		return nil
+	}
+	if host == "" || strings.IndexFunc(host, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_')
+	}) >= 0 {
+		return fmt.Errorf("invalid probe target %q, must be a host name or IP address and an optional port", target)
+	}
+	return nil
+}
+
+// ProbeModuleConfig defines a set of collectors to be executed on probed targets.
+type ProbeModuleConfig struct {
+	Name          string            `yaml:"module_name"`           // name of the module, as passed in the `module` parameter
+	CollectorRefs []string          `yaml:"collectors"`            // names of collectors to execute on the target
+	AuthModule    string            `yaml:"auth_module"`           // default data source name template
+	Labels        map[string]string `yaml:"labels,omitempty"`      // labels to apply to all metrics collected from the target
+	EnablePing    *bool             `yaml:"enable_ping,omitempty"` // ping the target before executing the collectors
+
+	collectors []*CollectorConfig // resolved collector references
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// Collectors returns the collectors referenced by the module, resolved.
+func (m *ProbeModuleConfig) Collectors() []*CollectorConfig {
+	return m.collectors
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for ProbeModuleConfig.
+func (m *ProbeModuleConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	type plain ProbeModuleConfig
+	if err := unmarshal((*plain)(m)); err != nil {
+		return err
+	}
+
+	// Check required fields
+	if m.Name == "" {
+		return fmt.Errorf("missing module_name for probe module %+v", m)
+	}
+	if m.AuthModule == "" {
+		return fmt.Errorf("missing auth_module for probe module %q", m.Name)
+	}
+	if err := checkCollectorRefs(m.CollectorRefs, fmt.Sprintf("probe module %q", m.Name)); err != nil {
+		return err
+	}
+	for name := range m.Labels {
+		if name == TargetLabel {
+			return fmt.Errorf("label %q is reserved, in probe module %q", name, m.Name)
+		}
+	}
+
+	return checkOverflow(m.XXX, "probe module")
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+const probeConfig = `
+probe:
+  auth_modules:
+    monitor: postgres://monitor@{{ .Target }}/postgres
+  modules:
+    - module_name: pg
+      collectors: [pg_*]
+      auth_module: %s
+collectors:
+  - collector_name: pg_standard
+    metrics:
+      - metric_name: pg_rows
+        type: gauge
+        help: Rows.
+        values: [n]
+        query: SELECT 1 AS n
+`
+
+func TestProbeConfig(t *testing.T) {
+	c, err := loadConfigString(t, strings.Replace(probeConfig, "%s", "monitor", 1))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.Probe.MaxTargets != 100 {
+		t.Errorf("max_targets = %d, want 100", c.Probe.MaxTargets)
+	}
+
+	m, found := c.Probe.Module("pg")
+	if !found || len(m.Collectors()) != 1 || m.Collectors()[0].Name != "pg_standard" {
+		t.Fatalf("unexpected probe module: %+v", m)
+	}
+	dsn, err := c.Probe.DataSourceName(m.AuthModule, "db1:5432")
+	if err != nil || dsn != "postgres://monitor@db1:5432/postgres" {
+		t.Errorf("DataSourceName = %q, %v", dsn, err)
+	}
+	if _, err := c.Probe.DataSourceName(m.AuthModule, "db1:5432/postgres?host=evil"); err == nil {
+		t.Error("expected an invalid target to be rejected")
+	}
+
+	_, err = loadConfigString(t, strings.Replace(probeConfig, "%s", "admin", 1))
+	if err == nil || !strings.Contains(err.Error(), `unknown auth_module "admin"`) {
+		t.Errorf("expected an unknown auth_module error, got %v", err)
+	}
+
+	scheduled := strings.Replace(strings.Replace(probeConfig, "%s", "monitor", 1), "collector_name: pg_standard",
+		"collector_name: pg_standard\n    interval: 5m", 1)
+	_, err = loadConfigString(t, scheduled)
+	if err == nil || !strings.Contains(err.Error(), `scheduled collector "pg_standard"`) {
+		t.Errorf("expected a scheduled collector error, got %v", err)
+	}
+}
+
+func TestCheckProbeTarget(t *testing.T) {
+	for _, target := range []string{"db1", "db1.example.com:5432", "10.0.0.1", "10.0.0.1:5432", "::1", "[::1]:5432"} {
+		if err := CheckProbeTarget(target); err != nil {
+			t.Errorf("expected %q to be valid, got %v", target, err)
+		}
+	}
+	for _, target := range []string{
+		"", ":5432", "db1:0", "db1:port", "[::1]", "user@db1", "db1/other", "db1:5432/db?sslmode=disable",
+		"evil:5432/db?sslmode=disable&host=attacker", "db1 db2", "db1#x", "db1;x",
+	} {
+		if err := CheckProbeTarget(target); err == nil {
+			t.Errorf("expected %q to be rejected", target)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	promcfg "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+//
+// Push
+//
+
+// PushConfig defines a Prometheus remote-write endpoint that metrics are periodically pushed to, for environments where
+// the exporter cannot be scraped.
+type PushConfig struct {
+	URL           string                 `yaml:"url"`                     // remote-write endpoint URL
+	Interval      model.Duration         `yaml:"interval"`                // interval between pushes
+	Timeout       model.Duration         `yaml:"timeout"`                 // timeout for collecting and for each push request
+	Headers       map[string]string      `yaml:"headers,omitempty"`       // extra HTTP headers sent with each push request
+	BasicAuth     *promcfg.BasicAuth     `yaml:"basic_auth,omitempty"`    // HTTP basic authentication
+	Authorization *promcfg.Authorization `yaml:"authorization,omitempty"` // HTTP authorization header (e.g. bearer token)
+	TLSConfig     promcfg.TLSConfig      `yaml:"tls_config,omitempty"`    // TLS settings for the endpoint
+	QueueCapacity int                    `yaml:"queue_capacity"`          // maximum number of pending pushes kept in memory
+	MinBackoff    model.Duration         `yaml:"min_backoff"`             // initial retry delay for failed pushes
+	MaxBackoff    model.Duration         `yaml:"max_backoff"`             // maximum retry delay for failed pushes
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for PushConfig.
+func (p *PushConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	p.Interval = model.Duration(time.Minute)
+	p.Timeout = model.Duration(10 * time.Second)
+	p.QueueCapacity = 10
+	p.MinBackoff = model.Duration(time.Second)
+	p.MaxBackoff = model.Duration(time.Minute)
+
+	type plain PushConfig
+	if err := unmarshal((*plain)(p)); err != nil {
+		return err
+	}
+
+	if p.URL == "" {
+		return fmt.Errorf("missing url for push")
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid push url %q, must be an absolute http(s) URL", p.URL)
+	}
+	if p.Interval <= 0 {
+		return fmt.Errorf("push.interval must be strictly positive, have %s", p.Interval)
+	}
+	if p.Timeout <= 0 {
+		return fmt.Errorf("push.timeout must be strictly positive, have %s", p.Timeout)
+	}
+	if p.QueueCapacity <= 0 {
+		return fmt.Errorf("push.queue_capacity must be strictly positive, have %d", p.QueueCapacity)
+	}
+	if p.MinBackoff <= 0 || p.MaxBackoff < p.MinBackoff {
+		return fmt.Errorf("push.min_backoff must be strictly positive and not greater than push.max_backoff")
+	}
+	httpConfig := p.HTTPClientConfig()
+	if err := httpConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid push configuration: %w", err)
+	}
+
+	return checkOverflow(p.XXX, "push")
+}
+
+// HTTPClientConfig returns the HTTP client settings for the push endpoint.
+func (p *PushConfig) HTTPClientConfig() promcfg.HTTPClientConfig {
+	return promcfg.HTTPClientConfig{
+		BasicAuth:       p.BasicAuth,
+		Authorization:   p.Authorization,
+		TLSConfig:       p.TLSConfig,
+		FollowRedirects: true,
+		EnableHTTP2:     true,
+	}
+}
@@ -1,15 +1,25 @@
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/prometheus/common/model"
+)
 
 // QueryConfig defines a named query, to be referenced by one or multiple metrics.
 type QueryConfig struct {
 	Name  string `yaml:"query_name"` // the query name, to be referenced via `query_ref`
 	Query string `yaml:"query"`      // the named query
 
-	NoPreparedStatement bool `yaml:"no_prepared_statement,omitempty"` // do not prepare statement
+	NoPreparedStatement bool           `yaml:"no_prepared_statement,omitempty"` // do not prepare statement
+	Timeout             model.Duration `yaml:"timeout,omitempty"`               // maximum duration of the query, on top of the collector and scrape timeouts
+	Params              []string       `yaml:"params,omitempty"`                // values bound to the query placeholders, in order
 
-	metrics []*MetricConfig // metrics referencing this query
+	metrics        []*MetricConfig      // metrics referencing this query
+	queryTemplate  *template.Template   // Query parsed as a template
+	paramTemplates []*template.Template // Params parsed as templates
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]any `yaml:",inline" json:"-"`
@@ -29,8 +39,76 @@ func (q *QueryConfig) UnmarshalYAML(unmarshal func(any) error) error {
 	if q.Query == "" {
 		return fmt.Errorf("missing query literal for query %q", q.Name)
 	}
+	if q.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative for query %q", q.Name)
+	}
 
 	q.metrics = make([]*MetricConfig, 0, 2)
 
+	if err := q.parseTemplates(); err != nil {
+		return err
+	}
+
 	return checkOverflow(q.XXX, "metric")
 }
+
+// QueryTemplateData is the per-target data available to query and params templates, e.g. `{{ .Labels.schema }}`.
+type QueryTemplateData struct {
+	Target string            // the target name
+	Job    string            // the job name, empty in single target mode
+	Labels map[string]string // the target labels, including job and target
+}
+
+// parseTemplates parses the query and its params as Go templates.
+func (q *QueryConfig) parseTemplates() error {
+	var err error
+	if q.queryTemplate, err = parseQueryTemplate(q.Name, q.Query); err != nil {
+		return fmt.Errorf("invalid template for query %q: %w", q.Name, err)
+	}
+	q.paramTemplates = make([]*template.Template, len(q.Params))
+	for i, param := range q.Params {
+		if q.paramTemplates[i], err = parseQueryTemplate(fmt.Sprintf("%s.params[%d]", q.Name, i), param); err != nil {
+			return fmt.Errorf("invalid template for param %d of query %q: %w", i, q.Name, err)
+		}
+	}
+	return nil
+}
+
+// Render expands the query and params templates with the provided data, returning the query and the arguments to
+// bind to its placeholders.
+func (q *QueryConfig) Render(data QueryTemplateData) (string, []any, error) {
+	queryTemplate, paramTemplates := q.queryTemplate, q.paramTemplates
+	// Not parsed yet, e.g. QueryConfig not created through UnmarshalYAML.
+	if queryTemplate == nil {
+		parsed := QueryConfig{Name: q.Name, Query: q.Query, Params: q.Params}
+		if err := parsed.parseTemplates(); err != nil {
+			return "", nil, err
+		}
+		queryTemplate, paramTemplates = parsed.queryTemplate, parsed.paramTemplates
+	}
+
+	query, err := executeQueryTemplate(queryTemplate, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to render query %q: %w", q.Name, err)
+	}
+	args := make([]any, len(paramTemplates))
+	for i, t := range paramTemplates {
+		if args[i], err = executeQueryTemplate(t, data); err != nil {
+			return "", nil, fmt.Errorf("failed to render param %d of query %q: %w", i, q.Name, err)
+		}
+	}
+	return query, args, nil
+}
+
+// parseQueryTemplate parses a query or param template. Missing labels are an error rather than an empty string.
+func parseQueryTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+func executeQueryTemplate(t *template.Template, data QueryTemplateData) (string, error) {
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
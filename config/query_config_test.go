@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const templatedConfig = `
+global: {}
+jobs:
+  - job_name: tenants
+    collectors: [tenant]
+    static_configs:
+      - targets:
+          db1: postgres://db1/app
+        labels:
+          schema: tenant_a
+      - targets:
+          db2: postgres://db2/app
+        labels:
+          schema: %s
+collectors:
+  - collector_name: tenant
+    metrics:
+      - metric_name: tenant_rows
+        type: gauge
+        help: Rows per tenant table.
+        values: [n]
+        query_ref: rows
+    queries:
+      - query_name: rows
+        query: SELECT count(*) AS n FROM {{ .Labels.schema }}.events WHERE host = $1 AND age > $2
+        params: ["{{ .Target }}", "30"]
+`
+
+func loadConfigString(t *testing.T, content string) (*Config, error) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "sql_exporter.yml")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(file)
+}
+
+func TestQueryTemplates(t *testing.T) {
+	c, err := loadConfigString(t, strings.Replace(templatedConfig, "%s", "tenant_b", 1))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	query, args, err := c.Collectors[0].Metrics[0].Query().Render(QueryTemplateData{
+		Target: "db2",
+		Job:    "tenants",
+		Labels: map[string]string{"schema": "tenant_b"},
+	})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if want := "SELECT count(*) AS n FROM tenant_b.events WHERE host = $1 AND age > $2"; query != want {
+		t.Errorf("query = %q, want %q", query, want)
+	}
+	if want := []any{"db2", "30"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestQueryTemplatesValidation(t *testing.T) {
+	// The second static config doesn't define the schema label.
+	content := strings.Replace(templatedConfig, "schema: %s", "region: eu", 1)
+	_, err := loadConfigString(t, content)
+	if err == nil || !strings.Contains(err.Error(), `target "db2" of job "tenants"`) {
+		t.Errorf("expected a missing label error for db2, got %v", err)
+	}
+
+	content = strings.Replace(templatedConfig, "%s", "tenant_b", 1)
+	content = strings.Replace(content, "{{ .Labels.schema }}", "{{ .Labels.schema ", 1)
+	if _, err := loadConfigString(t, content); err == nil || !strings.Contains(err.Error(), "invalid template") {
+		t.Errorf("expected a template syntax error, got %v", err)
+	}
+}
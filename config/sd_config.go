@@ -0,0 +1,250 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	promcfg "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+//
+// Service discovery
+//
+
+// SDConfig is implemented by all service discovery configs.
+type SDConfig interface {
+	// Mechanism returns the name of the discovery mechanism, e.g. "file".
+	Mechanism() string
+	// RefreshInterval returns the interval between discovery refreshes.
+	RefreshInterval() time.Duration
+	// DataSourceName renders the data source name of a discovered target from its address and labels.
+	DataSourceName(address string, labels map[string]string) (string, error)
+	// Target returns the settings applied to all discovered targets, besides the data source name.
+	Target() *SDTargetConfig
+}
+
+// SDTargetConfig holds the settings applied to all targets discovered by a service discovery config, same as those of
+// a static job target defined as an object.
+type SDTargetConfig struct {
+	Credentials `yaml:",inline"`
+	Auth        *AuthConfig      `yaml:"auth,omitempty"`       // token based authentication
+	ProxyURL    string           `yaml:"proxy_url,omitempty"`  // SOCKS5 or HTTP CONNECT proxy to connect through
+	SSHTunnel   *SSHTunnelConfig `yaml:"ssh_tunnel,omitempty"` // SSH server to forward the connections through
+	// TLS configuration of the connections, replacing the job's. Not named tls_config, which http_sd_config uses for
+	// the discovery endpoint.
+	TLS *TLSConfig `yaml:"target_tls_config,omitempty"`
+
+	refs SecretRefs // secret references the credentials were resolved from, if any
+}
+
+// SecretRefs returns the secret references the credentials were resolved from.
+func (t *SDTargetConfig) SecretRefs() SecretRefs {
+	return t.refs
+}
+
+// sdCommon holds the fields shared by all service discovery configs.
+type sdCommon struct {
+	DSN            Secret         `yaml:"data_source_name"` // template for the data source name of discovered targets
+	Refresh        model.Duration `yaml:"refresh_interval"` // interval between discovery refreshes
+	SDTargetConfig `yaml:",inline"`
+
+	dsnTemplate *template.Template
+}
+
+// init validates the common fields and parses the data source name template.
+func (s *sdCommon) init(mechanism string) error {
+	if s.DSN == "" {
+		return fmt.Errorf("missing data_source_name for %s_sd_config", mechanism)
+	}
+	if s.Refresh <= 0 {
+		return fmt.Errorf("refresh_interval must be strictly positive for %s_sd_config, have %s", mechanism, s.Refresh)
+	}
+	if err := checkDialer(s.ProxyURL, s.SSHTunnel); err != nil {
+		return fmt.Errorf("%w for %s_sd_config", err, mechanism)
+	}
+	var err error
+	s.dsnTemplate, err = template.New(mechanism).Option("missingkey=error").Parse(string(s.DSN))
+	if err != nil {
+		return fmt.Errorf("invalid data_source_name template for %s_sd_config: %w", mechanism, err)
+	}
+	return nil
+}
+
+// RefreshInterval implements SDConfig.
+func (s *sdCommon) RefreshInterval() time.Duration {
+	return time.Duration(s.Refresh)
+}
+
+// Target implements SDConfig.
+func (s *sdCommon) Target() *SDTargetConfig {
+	return &s.SDTargetConfig
+}
+
+// DataSourceName implements SDConfig. The template is rendered with `.Address` and `.Labels`, the latter including the
+// discovery specific `__meta_*` labels.
+func (s *sdCommon) DataSourceName(address string, labels map[string]string) (string, error) {
+	var sb strings.Builder
+	data := struct {
+		Address string
+		Labels  map[string]string
+	}{address, labels}
+	if err := s.dsnTemplate.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// FileSDConfig discovers targets from JSON or YAML files, in the Prometheus file_sd format.
+type FileSDConfig struct {
+	sdCommon `yaml:",inline"`
+	Files    []string `yaml:"files"` // file globs, relative to the config file
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for FileSDConfig.
+func (f *FileSDConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	f.Refresh = model.Duration(5 * time.Minute)
+
+	type plain FileSDConfig
+	if err := unmarshal((*plain)(f)); err != nil {
+		return err
+	}
+
+	if len(f.Files) == 0 {
+		return fmt.Errorf("no files defined for file_sd_config")
+	}
+	for _, file := range f.Files {
+		ext := filepath.Ext(file)
+		if ext != ".json" && ext != ".yml" && ext != ".yaml" {
+			return fmt.Errorf("invalid file_sd_config file %q, must end in .json, .yml or .yaml", file)
+		}
+		if _, err := filepath.Match(file, ""); err != nil {
+			return fmt.Errorf("invalid file_sd_config file pattern %q: %w", file, err)
+		}
+	}
+	if err := f.init(f.Mechanism()); err != nil {
+		return err
+	}
+
+	return checkOverflow(f.XXX, "file_sd_config")
+}
+
+// Mechanism implements SDConfig.
+func (f *FileSDConfig) Mechanism() string {
+	return "file"
+}
+
+// DNS record types supported by DNSSDConfig.
+const (
+	DNSTypeSRV  = "SRV"
+	DNSTypeA    = "A"
+	DNSTypeAAAA = "AAAA"
+)
+
+// DNSSDConfig discovers targets from DNS SRV, A or AAAA records.
+type DNSSDConfig struct {
+	sdCommon `yaml:",inline"`
+	Names    []string `yaml:"names"`          // DNS names to query
+	Type     string   `yaml:"type"`           // record type: SRV (default), A or AAAA
+	Port     int      `yaml:"port,omitempty"` // target port, required for A and AAAA records
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for DNSSDConfig.
+func (d *DNSSDConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	d.Refresh = model.Duration(30 * time.Second)
+	d.Type = DNSTypeSRV
+
+	type plain DNSSDConfig
+	if err := unmarshal((*plain)(d)); err != nil {
+		return err
+	}
+
+	if len(d.Names) == 0 {
+		return fmt.Errorf("no names defined for dns_sd_config")
+	}
+	d.Type = strings.ToUpper(d.Type)
+	switch d.Type {
+	case DNSTypeSRV:
+		if d.Port != 0 {
+			return fmt.Errorf("port is not supported for SRV records in dns_sd_config")
+		}
+	case DNSTypeA, DNSTypeAAAA:
+		if d.Port <= 0 || d.Port > 65535 {
+			return fmt.Errorf("a valid port is required for %s records in dns_sd_config", d.Type)
+		}
+	default:
+		return fmt.Errorf("unsupported record type %q in dns_sd_config", d.Type)
+	}
+	if err := d.init(d.Mechanism()); err != nil {
+		return err
+	}
+
+	return checkOverflow(d.XXX, "dns_sd_config")
+}
+
+// Mechanism implements SDConfig.
+func (d *DNSSDConfig) Mechanism() string {
+	return "dns"
+}
+
+// HTTPSDConfig discovers targets from an HTTP endpoint, in the Prometheus http_sd format.
+type HTTPSDConfig struct {
+	sdCommon      `yaml:",inline"`
+	URL           string                 `yaml:"url"`                     // endpoint returning the target groups
+	BasicAuth     *promcfg.BasicAuth     `yaml:"basic_auth,omitempty"`    // HTTP basic authentication
+	Authorization *promcfg.Authorization `yaml:"authorization,omitempty"` // HTTP authorization header (e.g. bearer token)
+	TLSConfig     promcfg.TLSConfig      `yaml:"tls_config,omitempty"`    // TLS settings for the endpoint
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for HTTPSDConfig.
+func (h *HTTPSDConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	h.Refresh = model.Duration(time.Minute)
+
+	type plain HTTPSDConfig
+	if err := unmarshal((*plain)(h)); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid http_sd_config url %q, must be an absolute http(s) URL", h.URL)
+	}
+	httpConfig := h.HTTPClientConfig()
+	if err := httpConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid http_sd_config: %w", err)
+	}
+	if err := h.init(h.Mechanism()); err != nil {
+		return err
+	}
+
+	return checkOverflow(h.XXX, "http_sd_config")
+}
+
+// Mechanism implements SDConfig.
+func (h *HTTPSDConfig) Mechanism() string {
+	return "http"
+}
+
+// HTTPClientConfig returns the HTTP client settings for the discovery endpoint.
+func (h *HTTPSDConfig) HTTPClientConfig() promcfg.HTTPClientConfig {
+	return promcfg.HTTPClientConfig{
+		BasicAuth:       h.BasicAuth,
+		Authorization:   h.Authorization,
+		TLSConfig:       h.TLSConfig,
+		FollowRedirects: true,
+		EnableHTTP2:     true,
+	}
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sdConfig = `
+jobs:
+  - job_name: pg
+    collectors: [pg]
+    %s
+collectors:
+  - collector_name: pg
+    metrics:
+      - metric_name: up_rows
+        type: gauge
+        help: Rows.
+        values: [n]
+        query: SELECT 1 AS n
+`
+
+func TestSDConfigs(t *testing.T) {
+	c, err := loadConfigString(t, strings.Replace(sdConfig, "%s", `file_sd_configs:
+      - files: [targets/*.json]
+        data_source_name: postgres://{{ .Address }}/app
+    dns_sd_configs:
+      - names: [db.example.com]
+        type: a
+        port: 5432
+        data_source_name: postgres://{{ .Address }}/app`, 1))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	sds := c.Jobs[0].SDConfigs()
+	if len(sds) != 2 || sds[0].Mechanism() != "file" || sds[1].Mechanism() != "dns" {
+		t.Fatalf("unexpected SD configs: %v", sds)
+	}
+	if file := c.Jobs[0].FileSDConfigs[0].Files[0]; !filepath.IsAbs(file) || filepath.Base(file) != "*.json" {
+		t.Errorf("expected file_sd path to be resolved relative to the config file, got %q", file)
+	}
+	if c.Jobs[0].DNSSDConfigs[0].Type != DNSTypeA {
+		t.Errorf("type = %q, want %q", c.Jobs[0].DNSSDConfigs[0].Type, DNSTypeA)
+	}
+	dsn, err := sds[0].DataSourceName("db1:5432", nil)
+	if err != nil || dsn != "postgres://db1:5432/app" {
+		t.Errorf("DataSourceName = %q, %v", dsn, err)
+	}
+}
+
+func TestSDConfigsValidation(t *testing.T) {
+	tests := map[string]string{
+		"invalid file_sd_config file": `file_sd_configs: [{files: [targets.txt], data_source_name: x}]`,
+		"port is not supported":       `dns_sd_configs: [{names: [db], port: 5432, data_source_name: x}]`,
+		"missing data_source_name":    `http_sd_configs: [{url: "http://sd/targets"}]`,
+		"must be an absolute":         `http_sd_configs: [{url: "/targets", data_source_name: x}]`,
+		"mutually exclusive": `dns_sd_configs: [{names: [db], data_source_name: x, proxy_url: "socks5://proxy:1080",
+      ssh_tunnel: {address: "bastion:22", user: me, password: x}}]`,
+	}
+	for want, jobConfig := range tests {
+		_, err := loadConfigString(t, strings.Replace(sdConfig, "%s", jobConfig, 1))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected an error containing %q, got %v", jobConfig, want, err)
+		}
+	}
+}
+
+func TestSDTargetConfig(t *testing.T) {
+	t.Setenv("SQL_EXPORTER_TEST_SD_PASSWORD", "secret")
+	c, err := loadConfigString(t, strings.Replace(sdConfig, "%s", `dns_sd_configs:
+      - names: [db.example.com]
+        data_source_name: postgres://{{ .Address }}/app
+        username: monitor
+        password: env://SQL_EXPORTER_TEST_SD_PASSWORD
+        auth: {type: aws_rds_iam}`, 1))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	st := c.Jobs[0].SDConfigs()[0].Target()
+	if st.Username != "monitor" || st.Password != "secret" || st.Auth == nil || st.Auth.Type != "aws_rds_iam" {
+		t.Errorf("unexpected discovered target settings: %+v", st)
+	}
+	if refs := st.SecretRefs(); refs.Password != "env://SQL_EXPORTER_TEST_SD_PASSWORD" || refs.Username != "" {
+		t.Errorf("unexpected secret references: %+v", refs)
+	}
+}
@@ -10,3 +10,23 @@ func (s Secret) MarshalYAML() (any, error) {
 	}
 	return nil, nil
 }
+
+// Credentials are a username and password merged into a data source name, replacing the ones it may contain. Each may
+// be a secret reference.
+type Credentials struct {
+	Username Secret `yaml:"username,omitempty"`
+	Password Secret `yaml:"password,omitempty"`
+}
+
+// SecretRefs are the secret references a target's data source name and credentials were resolved from. Each is empty
+// if the value was set directly.
+type SecretRefs struct {
+	DSN      string
+	Username string
+	Password string
+}
+
+// IsZero returns whether none of the values were resolved from secret references.
+func (r SecretRefs) IsZero() bool {
+	return r == SecretRefs{}
+}
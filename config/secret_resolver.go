@@ -25,6 +25,8 @@ type secretProvider interface {
 // secretProviders is the registry of supported secret store URL schemes.
 var secretProviders = map[string]secretProvider{
 	"awssecretsmanager": awsSecretsManagerProvider{},
+	"env":               envSecretProvider{},
+	"file":              fileSecretProvider{},
 	"gcpsecretmanager":  gcpSecretManagerProvider{},
 	"hashivault":        vaultProvider{},
 	"k8ssecret":         k8sSecretProvider{},
@@ -78,6 +80,27 @@ func (r *secretResolver) resolve(ctx context.Context, value string) (string, err
 	return extractKey(raw.(string), u, value)
 }
 
+// resolveTarget resolves a target's data source name and credentials in place, returning the secret references they
+// were resolved from.
+func (r *secretResolver) resolveTarget(ctx context.Context, dsn, username, password *Secret) (SecretRefs, error) {
+	var refs SecretRefs
+	for _, s := range []struct {
+		value *Secret
+		ref   *string
+	}{{dsn, &refs.DSN}, {username, &refs.Username}, {password, &refs.Password}} {
+		if !isSecretRef(string(*s.value)) {
+			continue
+		}
+		resolved, err := r.resolve(ctx, string(*s.value))
+		if err != nil {
+			return SecretRefs{}, err
+		}
+		*s.ref = string(*s.value)
+		*s.value = Secret(resolved)
+	}
+	return refs, nil
+}
+
 // extractKey pulls the appropriate key from a raw secret value (JSON or plain string).
 func extractKey(raw string, u *url.URL, originalValue string) (string, error) {
 	var resolved string
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"net/url"
 	"testing"
+
+	"go.yaml.in/yaml/v3"
 )
 
 // fakeSecretProvider is a mock implementation of secretProvider for testing purposes.
@@ -59,3 +61,86 @@ func TestSecretResolver_SharedFetch_DifferentKeys(t *testing.T) {
 		t.Fatalf("expected shared fetch (1 call), got %d", fp.calls)
 	}
 }
+
+// TestResolveSecrets_Rotation tests that secret references are kept on load and that ResolveSecrets fetches the
+// current secret values rather than those resolved on load.
+func TestResolveSecrets_Rotation(t *testing.T) {
+	orig := secretProviders
+	defer func() { secretProviders = orig }()
+
+	fp := &fakeSecretProvider{payload: map[string]string{"dsn": "postgres://user:old@db"}}
+	secretProviders = map[string]secretProvider{"k8ssecret": fp}
+
+	ref := "k8ssecret://default/my-db-secret?key=dsn"
+	c := &Config{
+		Target: &TargetConfig{DSN: Secret(ref)},
+		Jobs: []*JobConfig{{
+			Name: "job",
+			StaticConfigs: []*StaticConfig{{
+				Targets: map[string]Secret{"db1": Secret(ref), "db2": "postgres://db2"},
+			}},
+		}},
+	}
+	if err := c.resolveSecrets(); err != nil {
+		t.Fatalf("resolveSecrets: %v", err)
+	}
+	if c.Target.DSN != "postgres://user:old@db" || c.Target.SecretRefs() != (SecretRefs{DSN: ref}) {
+		t.Fatalf("unexpected target DSN %q and references %+v", c.Target.DSN, c.Target.SecretRefs())
+	}
+	sc := c.Jobs[0].StaticConfigs[0]
+	if sc.SecretRefs("db1").DSN != ref || !sc.SecretRefs("db2").IsZero() {
+		t.Fatalf("unexpected static config references %+v and %+v", sc.SecretRefs("db1"), sc.SecretRefs("db2"))
+	}
+
+	fp.payload["dsn"] = "postgres://user:new@db"
+	resolved, err := ResolveSecrets(context.Background(), []string{ref, ref})
+	if err != nil {
+		t.Fatalf("ResolveSecrets: %v", err)
+	}
+	if resolved[ref] != "postgres://user:new@db" {
+		t.Fatalf("expected the rotated DSN, got %q", resolved[ref])
+	}
+	if fp.calls != 2 {
+		t.Fatalf("expected one fetch per resolution, got %d", fp.calls)
+	}
+}
+
+// TestResolveSecrets_Credentials tests that usernames and passwords of static config targets are resolved separately
+// from the DSN, which may be set directly.
+func TestResolveSecrets_Credentials(t *testing.T) {
+	orig := secretProviders
+	defer func() { secretProviders = orig }()
+
+	fp := &fakeSecretProvider{payload: map[string]string{"password": "s3cret"}}
+	secretProviders = map[string]secretProvider{"k8ssecret": fp}
+
+	content := `
+targets:
+  db1: postgres://db1:5432/postgres
+  db2:
+    data_source_name: postgres://db2:5432/postgres
+    username: monitor
+    password: k8ssecret://default/db-creds?key=password
+`
+	sc := &StaticConfig{}
+	if err := yaml.Unmarshal([]byte(content), sc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	c := &Config{Jobs: []*JobConfig{{Name: "job", StaticConfigs: []*StaticConfig{sc}}}}
+	if err := c.resolveSecrets(); err != nil {
+		t.Fatalf("resolveSecrets: %v", err)
+	}
+
+	if sc.Targets["db2"] != "postgres://db2:5432/postgres" {
+		t.Fatalf("unexpected DSN %q", sc.Targets["db2"])
+	}
+	if creds := sc.Credentials("db2"); creds != (Credentials{Username: "monitor", Password: "s3cret"}) {
+		t.Fatalf("unexpected credentials %+v", creds)
+	}
+	if refs := sc.SecretRefs("db2"); refs != (SecretRefs{Password: "k8ssecret://default/db-creds?key=password"}) {
+		t.Fatalf("unexpected references %+v", refs)
+	}
+	if sc.Credentials("db1") != (Credentials{}) || !sc.SecretRefs("db1").IsZero() {
+		t.Fatalf("expected no credentials for db1, got %+v", sc.Credentials("db1"))
+	}
+}
@@ -11,9 +11,21 @@ import (
 
 type vaultProvider struct{}
 
-// getDSN fetches the secret value from HashiCorp Vault KV engine.
+// getDSN fetches the secret value from HashiCorp Vault KV engine, or dynamic credentials from the database engine.
 // URL format: hashivault://mount/path?key=data_source_name&engine_version=2
+// or hashivault://mount/creds/role?engine=database&key=password
 func (p vaultProvider) getDSN(ctx context.Context, ref *url.URL) (string, error) {
+	q := ref.Query()
+	secretPath := ref.Host + ref.Path
+
+	dynamic := q.Get("engine") == "database"
+	if dynamic {
+		// Leased credentials are reused until they're about to expire.
+		if value, ok := vaultLeases.get(secretPath); ok {
+			return value, nil
+		}
+	}
+
 	cfg := vault.DefaultConfig()
 	if err := cfg.ReadEnvironment(); err != nil {
 		return "", fmt.Errorf("unable to read Vault environment: %w", err)
@@ -24,15 +36,15 @@ func (p vaultProvider) getDSN(ctx context.Context, ref *url.URL) (string, error)
 		return "", fmt.Errorf("unable to create Vault client: %w", err)
 	}
 
-	q := ref.Query()
+	if dynamic {
+		return getDynamicCredentials(ctx, client, secretPath)
+	}
 
 	engineVersion := "2"
 	if v := q.Get("engine_version"); v != "" {
 		engineVersion = v
 	}
 
-	secretPath := ref.Host + ref.Path
-
 	var secret *vault.KVSecret
 	switch engineVersion {
 	case "1":
@@ -63,3 +75,31 @@ func (p vaultProvider) getDSN(ctx context.Context, ref *url.URL) (string, error)
 	}
 	return string(b), nil
 }
+
+// getDynamicCredentials requests credentials from the Vault database engine and keeps their lease renewed in the
+// background. The raw payload (username, password and any other keys) is returned as JSON, same as for KV secrets.
+func getDynamicCredentials(ctx context.Context, client *vault.Client, secretPath string) (string, error) {
+	secret, err := client.Logical().ReadWithContext(ctx, secretPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to read Vault credentials at %q: %w", secretPath, err)
+	}
+	if secret == nil {
+		return "", fmt.Errorf("no Vault credentials at %q", secretPath)
+	}
+
+	raw := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		str, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("value for key %q in Vault credentials at %q is not a string", k, secretPath)
+		}
+		raw[k] = str
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal Vault credentials at %q: %w", secretPath, err)
+	}
+
+	vaultLeases.add(client, secretPath, secret, string(b))
+	return string(b), nil
+}
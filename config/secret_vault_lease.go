@@ -0,0 +1,233 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// vaultLeases holds the dynamic credentials issued by Vault, shared by all references to the same path.
+var vaultLeases = newVaultLeaseCache()
+
+// SecretLease describes the lease of dynamic credentials currently in use.
+type SecretLease struct {
+	Path      string    // Vault path the credentials were issued from, e.g. `database/creds/readonly`
+	ExpiresAt time.Time // when the lease expires unless renewed
+}
+
+// vaultLease is the lease of dynamic credentials, renewed in the background until it can't be extended anymore.
+type vaultLease struct {
+	id     string
+	value  string
+	client *vault.Client
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	expiresAt time.Time
+	// When the lease was replaced or expired, if it was.
+	supersededAt time.Time
+}
+
+// vaultLeaseCache holds the current lease for each path. Expired leases are removed, so that the next resolution
+// requests new credentials, and revoked once the new credentials are in use.
+type vaultLeaseCache struct {
+	mu     sync.Mutex
+	leases map[string]*vaultLease
+	// Replaced or expired leases, not revoked yet.
+	superseded []*vaultLease
+	// Closed and replaced whenever a lease expires.
+	expired chan struct{}
+}
+
+func newVaultLeaseCache() *vaultLeaseCache {
+	return &vaultLeaseCache{leases: make(map[string]*vaultLease), expired: make(chan struct{})}
+}
+
+// get returns the credentials for the path, if leased.
+func (c *vaultLeaseCache) get(path string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l, found := c.leases[path]; found {
+		return l.value, true
+	}
+	return "", false
+}
+
+// add caches the credentials for the path and starts renewing their lease. Credentials without a lease aren't cached.
+func (c *vaultLeaseCache) add(client *vault.Client, path string, secret *vault.Secret, value string) {
+	duration := time.Duration(secret.LeaseDuration) * time.Second
+	if secret.LeaseID == "" || duration <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &vaultLease{
+		id: secret.LeaseID, value: value, client: client, cancel: cancel, expiresAt: time.Now().Add(duration),
+	}
+
+	c.mu.Lock()
+	if previous, found := c.leases[path]; found {
+		previous.cancel()
+		c.supersede(previous)
+	}
+	c.leases[path] = l
+	c.mu.Unlock()
+
+	slog.Debug("Leased Vault credentials", "path", path, "lease_duration", duration)
+	go c.renew(ctx, client, path, l, duration, secret.Renewable)
+}
+
+// renew renews the lease once two thirds of its duration have elapsed, and expires it once it can't be renewed for
+// more than a third of its initial duration (e.g. once the role's max TTL is reached), leaving time to request new
+// credentials and reconnect.
+func (c *vaultLeaseCache) renew(
+	ctx context.Context, client *vault.Client, path string, l *vaultLease, duration time.Duration, renewable bool,
+) {
+	margin := duration / 3
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(l.expiry()) - margin):
+		}
+		if !renewable {
+			break
+		}
+		secret, err := client.Sys().RenewWithContext(ctx, l.id, int(duration.Seconds()))
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("Unable to renew Vault lease", "path", path, "error", err)
+			break
+		}
+		ttl := time.Duration(secret.LeaseDuration) * time.Second
+		if ttl <= margin {
+			break
+		}
+		l.mu.Lock()
+		l.expiresAt = time.Now().Add(ttl)
+		l.mu.Unlock()
+		slog.Debug("Renewed Vault lease", "path", path, "ttl", ttl)
+	}
+	slog.Warn("Vault lease is about to expire, requesting new credentials", "path", path)
+	c.expire(path, l)
+}
+
+// expire removes the lease, if still current, and notifies subscribers.
+func (c *vaultLeaseCache) expire(path string, l *vaultLease) {
+	l.cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.leases[path] != l {
+		return
+	}
+	delete(c.leases, path)
+	c.supersede(l)
+	close(c.expired)
+	c.expired = make(chan struct{})
+}
+
+// supersede marks the lease as no longer current, to be revoked. Must be called with the lock held.
+func (c *vaultLeaseCache) supersede(l *vaultLease) {
+	l.mu.Lock()
+	l.supersededAt = time.Now()
+	l.mu.Unlock()
+	c.superseded = append(c.superseded, l)
+}
+
+// revoke revokes the superseded leases that were superseded before the provided time, or all leases (including the
+// current ones, whose renewal is stopped) if all is true.
+func (c *vaultLeaseCache) revoke(ctx context.Context, before time.Time, all bool) {
+	c.mu.Lock()
+	var revoked []*vaultLease
+	c.superseded = slices.DeleteFunc(c.superseded, func(l *vaultLease) bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if all || l.supersededAt.Before(before) {
+			revoked = append(revoked, l)
+			return true
+		}
+		return false
+	})
+	if all {
+		for path, l := range c.leases {
+			l.cancel()
+			revoked = append(revoked, l)
+			delete(c.leases, path)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, l := range revoked {
+		if err := l.client.Sys().RevokeWithContext(ctx, l.id); err != nil {
+			slog.Warn("Unable to revoke Vault lease", "lease_id", l.id, "error", err)
+			continue
+		}
+		slog.Debug("Revoked Vault lease", "lease_id", l.id)
+	}
+}
+
+// expiry returns when the lease expires unless renewed.
+func (l *vaultLease) expiry() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expiresAt
+}
+
+// SecretLeaseExpired returns a channel that is closed once a lease of dynamic credentials is about to expire. The
+// credentials are requested again on the next resolution of the references to them.
+func SecretLeaseExpired() <-chan struct{} {
+	vaultLeases.mu.Lock()
+	defer vaultLeases.mu.Unlock()
+	return vaultLeases.expired
+}
+
+// SecretLeases returns the leases of the dynamic credentials currently in use.
+func SecretLeases() []SecretLease {
+	vaultLeases.mu.Lock()
+	defer vaultLeases.mu.Unlock()
+	leases := make([]SecretLease, 0, len(vaultLeases.leases))
+	for path, l := range vaultLeases.leases {
+		leases = append(leases, SecretLease{Path: path, ExpiresAt: l.expiry()})
+	}
+	return leases
+}
+
+// RevokeSupersededSecretLeases revokes the leases of dynamic credentials that were replaced or expired before the
+// provided time, i.e. before the secrets currently in use were resolved, so that they don't outlive their use.
+func RevokeSupersededSecretLeases(ctx context.Context, before time.Time) {
+	vaultLeases.revoke(ctx, before, false)
+}
+
+// RevokeSecretLeases revokes the leases of all dynamic credentials, e.g. on shutdown.
+func RevokeSecretLeases(ctx context.Context) {
+	vaultLeases.revoke(ctx, time.Time{}, true)
+}
+
+// IsDynamicSecret returns whether the secret reference is to leased dynamic credentials.
+func IsDynamicSecret(ref string) bool {
+	u, err := url.Parse(ref)
+	return err == nil && u.Scheme == "hashivault" && u.Query().Get("engine") == "database"
+}
+
+// InvalidateSecrets drops the leased credentials the references point to, if any, so that new ones are requested on
+// the next resolution, e.g. after they've been rejected by the database.
+func InvalidateSecrets(refs []string) {
+	for _, ref := range refs {
+		if !IsDynamicSecret(ref) {
+			continue
+		}
+		u, _ := url.Parse(ref)
+		vaultLeases.mu.Lock()
+		l, found := vaultLeases.leases[u.Host+u.Path]
+		vaultLeases.mu.Unlock()
+		if found {
+			vaultLeases.expire(u.Host+u.Path, l)
+		}
+	}
+}
@@ -3,11 +3,16 @@ package config
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	vault "github.com/hashicorp/vault/api"
 )
@@ -172,3 +177,129 @@ func TestVaultProvider_HonorsVaultAddrEnv(t *testing.T) {
 		t.Fatalf("expected address %q, got %q", srv.URL, cfg.Address)
 	}
 }
+
+// newVaultDatabaseTestServer mimics the Vault database engine: every read of /v1/database/creds/<role> issues new
+// credentials with a 3s renewable lease, and renewals via /v1/sys/leases/renew return the provided TTLs in turn.
+func newVaultDatabaseTestServer(t *testing.T, renewTTLs ...int) (*httptest.Server, *atomic.Int32, func() []string) {
+	t.Helper()
+	var issued atomic.Int32
+	var renewals atomic.Int32
+	var (
+		mu      sync.Mutex
+		revoked []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/database/creds/"):
+			n := issued.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"lease_id":       fmt.Sprintf("database/creds/readonly/lease-%d", n),
+				"lease_duration": 3,
+				"renewable":      true,
+				"data": map[string]any{
+					"username": fmt.Sprintf("v-readonly-%d", n),
+					"password": fmt.Sprintf("password-%d", n),
+				},
+			})
+		case r.URL.Path == "/v1/sys/leases/renew":
+			ttl := 0
+			if n := int(renewals.Add(1)); n <= len(renewTTLs) {
+				ttl = renewTTLs[n-1]
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"lease_duration": ttl, "renewable": true})
+		case r.URL.Path == "/v1/sys/leases/revoke":
+			var body struct {
+				LeaseID string `json:"lease_id"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			revoked = append(revoked, body.LeaseID)
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &issued, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Sorted(slices.Values(revoked))
+	}
+}
+
+// TestVaultProvider_DynamicCredentials tests that dynamic credentials are reused while their lease is renewed, and
+// requested again once it can't be renewed anymore.
+func TestVaultProvider_DynamicCredentials(t *testing.T) {
+	// The first renewal extends the lease, the second doesn't (max TTL reached).
+	srv, issued, revoked := newVaultDatabaseTestServer(t, 3, 1)
+	setVaultAddrEnv(t, srv.URL)
+	t.Cleanup(func() {
+		InvalidateSecrets([]string{"hashivault://database/creds/readonly?engine=database"})
+		vaultLeases = newVaultLeaseCache()
+	})
+
+	ref := "hashivault://database/creds/readonly?engine=database&key=password"
+	if !IsDynamicSecret(ref) || IsDynamicSecret("hashivault://secret/my-db-secret") {
+		t.Fatal("unexpected IsDynamicSecret result")
+	}
+	expired := SecretLeaseExpired()
+
+	resolved, err := ResolveSecrets(context.Background(), []string{ref})
+	if err != nil {
+		t.Fatalf("ResolveSecrets: %v", err)
+	}
+	if resolved[ref] != "password-1" {
+		t.Fatalf("password mismatch: got %q", resolved[ref])
+	}
+	leases := SecretLeases()
+	if len(leases) != 1 || leases[0].Path != "database/creds/readonly" || time.Until(leases[0].ExpiresAt) <= 0 {
+		t.Fatalf("unexpected leases %+v", leases)
+	}
+
+	// Reused while the lease is alive.
+	if resolved, err = ResolveSecrets(context.Background(), []string{ref}); err != nil || resolved[ref] != "password-1" {
+		t.Fatalf("expected leased credentials to be reused, got %q (err: %v)", resolved[ref], err)
+	}
+
+	// Renewed after 2s, then expired after 4s.
+	select {
+	case <-expired:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the lease to expire")
+	}
+	if len(SecretLeases()) != 0 {
+		t.Fatalf("expected no leases, got %+v", SecretLeases())
+	}
+	if resolved, err = ResolveSecrets(context.Background(), []string{ref}); err != nil || resolved[ref] != "password-2" {
+		t.Fatalf("expected new credentials, got %q (err: %v)", resolved[ref], err)
+	}
+	// The expired lease is revoked once the new credentials are in use, but not the ones superseded afterwards.
+	RevokeSupersededSecretLeases(context.Background(), time.Now())
+	if got := revoked(); !slices.Equal(got, []string{"database/creds/readonly/lease-1"}) {
+		t.Fatalf("expected the expired lease to be revoked, got %v", got)
+	}
+
+	// Invalidated credentials are requested again.
+	InvalidateSecrets([]string{ref})
+	if resolved, err = ResolveSecrets(context.Background(), []string{ref}); err != nil || resolved[ref] != "password-3" {
+		t.Fatalf("expected new credentials, got %q (err: %v)", resolved[ref], err)
+	}
+	if issued.Load() != 3 {
+		t.Fatalf("expected 3 issued credentials, got %d", issued.Load())
+	}
+	RevokeSupersededSecretLeases(context.Background(), time.Now().Add(-time.Hour))
+	if got := revoked(); len(got) != 1 {
+		t.Fatalf("expected recently superseded leases to be kept, got %v", got)
+	}
+
+	// All leases are revoked on shutdown.
+	RevokeSecretLeases(context.Background())
+	want := []string{
+		"database/creds/readonly/lease-1", "database/creds/readonly/lease-2", "database/creds/readonly/lease-3",
+	}
+	if got := revoked(); !slices.Equal(got, want) || len(SecretLeases()) != 0 {
+		t.Fatalf("expected all leases to be revoked, got %v", got)
+	}
+}
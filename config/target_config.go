@@ -10,12 +10,21 @@ import (
 
 // TargetConfig defines a DSN and a set of collectors to be executed on it.
 type TargetConfig struct {
-	Name          string   `yaml:"name,omitempty" env:"NAME"`               // name of the target
-	DSN           Secret   `yaml:"data_source_name" env:"DSN"`              // data source name to connect to
-	CollectorRefs []string `yaml:"collectors" env:"COLLECTORS"`             // names of collectors to execute on the target
-	EnablePing    *bool    `yaml:"enable_ping,omitempty" env:"ENABLE_PING"` // ping the target before executing the collectors
+	Name          string           `yaml:"name,omitempty" env:"NAME"`               // name of the target
+	DSN           Secret           `yaml:"data_source_name" env:"DSN"`              // data source name to connect to
+	Username      Secret           `yaml:"username,omitempty" env:"USERNAME"`       // username merged into the data source name
+	Password      Secret           `yaml:"password,omitempty" env:"PASSWORD"`       // password merged into the data source name
+	Auth          *AuthConfig      `yaml:"auth,omitempty"`                          // token based authentication
+	TLS           *TLSConfig       `yaml:"tls_config,omitempty"`                    // TLS configuration of the connections
+	ProxyURL      string           `yaml:"proxy_url,omitempty" env:"PROXY_URL"`     // SOCKS5 or HTTP CONNECT proxy to connect through
+	SSHTunnel     *SSHTunnelConfig `yaml:"ssh_tunnel,omitempty"`                    // SSH server to forward the connections through
+	CollectorRefs []string         `yaml:"collectors" env:"COLLECTORS"`             // names of collectors to execute on the target
+	EnablePing    *bool            `yaml:"enable_ping,omitempty" env:"ENABLE_PING"` // ping the target before executing the collectors
+
+	ConnectionConfig `yaml:",inline"` // connection settings overriding the global ones
 
 	collectors []*CollectorConfig // resolved collector references
+	refs       SecretRefs         // secret references the DSN and credentials were resolved from, if any
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]any `yaml:",inline" json:"-"`
@@ -26,6 +35,16 @@ func (t *TargetConfig) Collectors() []*CollectorConfig {
 	return t.collectors
 }
 
+// Credentials returns the username and password to merge into the target's data source name.
+func (t *TargetConfig) Credentials() Credentials {
+	return Credentials{Username: t.Username, Password: t.Password}
+}
+
+// SecretRefs returns the secret references the target's data source name and credentials were resolved from.
+func (t *TargetConfig) SecretRefs() SecretRefs {
+	return t.refs
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface for TargetConfig.
 func (t *TargetConfig) UnmarshalYAML(unmarshal func(any) error) error {
 	type plain TargetConfig
@@ -40,6 +59,12 @@ func (t *TargetConfig) UnmarshalYAML(unmarshal func(any) error) error {
 	if err := checkCollectorRefs(t.CollectorRefs, "target"); err != nil {
 		return err
 	}
+	if err := checkDialer(t.ProxyURL, t.SSHTunnel); err != nil {
+		return err
+	}
+	if err := t.ConnectionConfig.check("target"); err != nil {
+		return err
+	}
 
 	return checkOverflow(t.XXX, "target")
 }
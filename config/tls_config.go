@@ -0,0 +1,48 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// TLSVersions are the TLS versions min_version may be set to.
+var TLSVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// TLSConfig defines a driver-agnostic TLS configuration for the connections to a target. Certificate files are read
+// again when they change.
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`              // CA certificates to verify the server with
+	CertFile           string `yaml:"cert_file,omitempty"`            // client certificate, for client authentication
+	KeyFile            string `yaml:"key_file,omitempty"`             // client certificate key
+	ServerName         string `yaml:"server_name,omitempty"`          // server name to verify; defaults to the host
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"` // disables server certificate verification
+	MinVersion         string `yaml:"min_version,omitempty"`          // one of TLSVersions; defaults to TLS12
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]any `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for TLSConfig.
+func (c *TLSConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	type plain TLSConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("both cert_file and key_file must be set for client authentication")
+	}
+	if _, ok := TLSVersions[c.MinVersion]; c.MinVersion != "" && !ok {
+		return fmt.Errorf("unsupported TLS min_version %q, must be one of %v", c.MinVersion,
+			slices.Sorted(maps.Keys(TLSVersions)))
+	}
+
+	return checkOverflow(c.XXX, "tls_config")
+}
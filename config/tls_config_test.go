@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+
+	"go.yaml.in/yaml/v3"
+)
+
+func TestTLSConfig(t *testing.T) {
+	content := `
+job_name: db
+collectors: [c]
+tls_config:
+  ca_file: /etc/ssl/db-ca.pem
+static_configs:
+  - targets:
+      db1: postgres://db1:5432/postgres
+      db2:
+        data_source_name: postgres://db2:5432/postgres
+        tls_config:
+          cert_file: /etc/ssl/client.pem
+          key_file: /etc/ssl/client-key.pem
+          min_version: TLS13
+`
+	jc := &JobConfig{}
+	if err := yaml.Unmarshal([]byte(content), jc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if jc.TLS == nil || jc.TLS.CAFile != "/etc/ssl/db-ca.pem" {
+		t.Errorf("unexpected job tls_config: %+v", jc.TLS)
+	}
+	sc := jc.StaticConfigs[0]
+	if sc.TLS("db1") != nil {
+		t.Errorf("expected no tls_config for db1, got %+v", sc.TLS("db1"))
+	}
+	if c := sc.TLS("db2"); c == nil || c.CertFile != "/etc/ssl/client.pem" || c.MinVersion != "TLS13" {
+		t.Errorf("unexpected tls_config for db2: %+v", c)
+	}
+
+	for _, invalid := range []string{
+		"cert_file: /etc/ssl/client.pem",
+		"min_version: SSL3",
+		"ca: /etc/ssl/db-ca.pem",
+	} {
+		if err := yaml.Unmarshal([]byte(invalid), &TLSConfig{}); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package sql_exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+)
+
+// connectorFunc returns a connector opening connections with the provided driver specific data source name.
+type connectorFunc func(dsn string) (driver.Connector, error)
+
+// dialFunc opens a network connection, e.g. through a proxy or SSH tunnel.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// driverOptions are the options translated into each driver's own configuration by driverConnectors.
+type driverOptions struct {
+	tls        *tls.Config                   // TLS config replacing the driver's, if set
+	tlsForHost func(host string) *tls.Config // same as tls, for each of several hosts
+	// Identifies the target the connections are opened for, keying the TLS config in drivers' global registries.
+	tlsKey string
+	dial   dialFunc // dial function replacing the driver's, if set
+}
+
+// driverConnectors is the registry of functions returning connectors that apply driverOptions, by driver name.
+// Registered by the files of the drivers built in, so that excluding a driver doesn't link it anyway. Other drivers
+// don't support tls_config, proxy_url nor ssh_tunnel.
+var driverConnectors = make(map[string]func(dsn string, opts driverOptions) (driver.Connector, error))
+
+// newConnectorFunc returns the connectorFunc of the named driver, applying the TLS configuration and dialer of the
+// connection options if set. The server certificate is verified against host, unless the TLS config sets a server
+// name. id identifies the target the connections are opened for.
+func newConnectorFunc(driverName string, opts connectionOptions, host, id string) (connectorFunc, error) {
+	if opts.tls == nil && opts.dialer == nil {
+		// Doesn't connect, only looks up the registered driver.
+		db, err := sql.Open(driverName, "")
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		return driverConnectorFunc(db.Driver()), nil
+	}
+
+	newConnector, ok := driverConnectors[driverName]
+	if !ok {
+		return nil, fmt.Errorf("tls_config, proxy_url and ssh_tunnel are not supported by driver %q", driverName)
+	}
+	var do driverOptions
+	if opts.tls != nil {
+		var err error
+		if do.tlsForHost, err = newTLSConfigFunc(opts.tls); err != nil {
+			return nil, err
+		}
+		do.tls = do.tlsForHost(host)
+		do.tlsKey = "sql_exporter-" + id + "@" + host
+	}
+	if opts.dialer != nil {
+		do.dial = opts.dialer.DialContext
+	}
+	return func(dsn string) (driver.Connector, error) {
+		return newConnector(dsn, do)
+	}, nil
+}
+
+// driverConnectorFunc returns the connectorFunc of a driver, as used by sql.Open.
+func driverConnectorFunc(d driver.Driver) connectorFunc {
+	return func(dsn string) (driver.Connector, error) {
+		if dc, ok := d.(driver.DriverContext); ok {
+			return dc.OpenConnector(dsn)
+		}
+		return dsnConnector{driver: d, dsn: dsn}, nil
+	}
+}
+
+// dsnConnector is a driver.Connector for drivers not implementing driver.DriverContext.
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+// Connect implements driver.Connector.
+func (c dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+// Driver implements driver.Connector.
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,73 @@
+package sql_exporter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/burningalchemist/sql_exporter/config"
+)
+
+func TestNewConnectorFunc(t *testing.T) {
+	opts := connectionOptions{tls: &config.TLSConfig{}}
+	if _, err := newConnectorFunc("vertica", opts, "db1", "job=test"); err == nil {
+		t.Error("expected tls_config to be unsupported by vertica")
+	}
+	for driverName, dsn := range map[string]string{
+		"pgx":        "postgres://monitor@db1:5432/postgres?sslmode=disable",
+		"postgres":   "postgres://monitor@db1:5432/postgres?sslmode=disable",
+		"sqlserver":  "sqlserver://monitor@db1:1433?encrypt=disable",
+		"clickhouse": "clickhouse://monitor@db1:9000/default",
+		"mysql":      "monitor@tcp(db1:3306)/",
+	} {
+		open, err := newConnectorFunc(driverName, opts, "db1", "job=test")
+		if err != nil {
+			t.Fatalf("%s: %v", driverName, err)
+		}
+		if _, err := open(dsn); err != nil {
+			t.Errorf("%s: %v", driverName, err)
+		}
+	}
+}
+
+// recordingDialer records the addresses it's asked to dial, and fails.
+type recordingDialer struct {
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (d *recordingDialer) DialContext(_ context.Context, _, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.addrs = append(d.addrs, addr)
+	return nil, errors.New("no route to bastion")
+}
+
+func TestNewConnectorFunc_Dialer(t *testing.T) {
+	for driverName, dsn := range map[string]string{
+		"pgx":        "postgres://monitor@db1.internal:5432/postgres?sslmode=disable",
+		"postgres":   "postgres://monitor@db1.internal:5432/postgres?sslmode=disable",
+		"sqlserver":  "sqlserver://monitor@db1.internal:5432?encrypt=disable",
+		"clickhouse": "clickhouse://monitor@db1.internal:5432/default",
+		"mysql":      "monitor@tcp(db1.internal:5432)/",
+	} {
+		d := &recordingDialer{}
+		open, err := newConnectorFunc(driverName, connectionOptions{dialer: d}, "db1.internal", "job=test")
+		if err != nil {
+			t.Fatalf("%s: %v", driverName, err)
+		}
+		connector, err := open(dsn)
+		if err != nil {
+			t.Fatalf("%s: %v", driverName, err)
+		}
+		if _, err := connector.Connect(context.Background()); err == nil {
+			t.Errorf("%s: expected the connection to fail", driverName)
+		}
+		// Host names are left for the other end to resolve.
+		if len(d.addrs) == 0 || d.addrs[0] != "db1.internal:5432" {
+			t.Errorf("%s: expected to dial db1.internal:5432, dialed %v", driverName, d.addrs)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package sql_exporter
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+// dialer opens network connections to a target's database through a proxy or SSH tunnel.
+type dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// newDialer returns the dialer connecting through the proxy or the SSH tunnel, or nil if neither is set. It doesn't
+// connect to either.
+func newDialer(proxyURL string, tunnel *config.SSHTunnelConfig) (dialer, error) {
+	if tunnel != nil {
+		return &sshTunnel{config: tunnel}, nil
+	}
+	if proxyURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		return &httpProxyDialer{proxy: u}, nil
+	case "socks5", "socks5h":
+		d, err := proxy.FromURL(u, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		return d.(proxy.ContextDialer), nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy_url scheme %q", u.Scheme)
+	}
+}
+
+// httpProxyDialer opens connections through an HTTP proxy, with CONNECT requests.
+type httpProxyDialer struct {
+	proxy *url.URL
+}
+
+// DialContext implements dialer.
+func (d *httpProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, network, d.proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+	// Don't wait for the proxy longer than the context allows.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := d.proxy.User; u != nil {
+		password, _ := u.Password()
+		req.Header.Set("Proxy-Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s: %w", addr, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s: %w", addr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s: %s", addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		// The database spoke first and the proxy passed it on along with the response.
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn reading what was already buffered first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read implements net.Conn.
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// sshTunnel opens connections forwarded through an SSH server. The SSH connection is opened on first use and opened
+// again if it breaks; key and known hosts files are read each time.
+type sshTunnel struct {
+	config *config.SSHTunnelConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// DialContext implements dialer.
+func (t *sshTunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := t.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.DialContext(ctx, network, addr)
+	var openErr *ssh.OpenChannelError
+	if err != nil && !errors.As(err, &openErr) && ctx.Err() == nil {
+		// Not refused by the SSH server, so the SSH connection is likely broken.
+		t.reset(client)
+	}
+	return conn, err
+}
+
+// check checks that the SSH connection is up, opening it if needed.
+func (t *sshTunnel) check(ctx context.Context) error {
+	client, err := t.connect(ctx)
+	if err != nil {
+		return err
+	}
+	ch := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		ch <- err
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-ch:
+		if err != nil {
+			t.reset(client)
+			return fmt.Errorf("ssh tunnel to %s is down: %w", t.config.Address, err)
+		}
+		return nil
+	}
+}
+
+// Close closes the SSH connection, if open.
+func (t *sshTunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == nil {
+		return nil
+	}
+	err := t.client.Close()
+	t.client = nil
+	return err
+}
+
+// connect returns the SSH client, opening the SSH connection if needed.
+func (t *sshTunnel) connect(ctx context.Context) (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	cfg, err := t.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	addr := t.config.Address
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to ssh tunnel %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to connect to ssh tunnel %s: %w", addr, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	t.client = ssh.NewClient(c, chans, reqs)
+	return t.client, nil
+}
+
+// reset closes the SSH client if still the current one, so that the next use opens a new SSH connection.
+func (t *sshTunnel) reset(client *ssh.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == client {
+		client.Close()
+		t.client = nil
+	}
+}
+
+// clientConfig returns the SSH client config, reading the key and known hosts files.
+func (t *sshTunnel) clientConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if t.config.PrivateKeyFile != "" {
+		pem, err := os.ReadFile(t.config.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ssh private key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse ssh private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if t.config.Password != "" {
+		auth = append(auth, ssh.Password(string(t.config.Password)))
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !t.config.InsecureIgnoreHostKey {
+		knownHostsFile := t.config.KnownHostsFile
+		if knownHostsFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		var err error
+		if hostKeyCallback, err = knownhosts.New(knownHostsFile); err != nil {
+			return nil, fmt.Errorf("unable to read ssh known hosts: %w", err)
+		}
+	}
+
+	return &ssh.ClientConfig{User: t.config.User, Auth: auth, HostKeyCallback: hostKeyCallback}, nil
+}
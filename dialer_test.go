@@ -0,0 +1,302 @@
+package sql_exporter
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startEchoServer starts a TCP server echoing back what it reads, standing in for a database.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// assertEcho checks that the connection reaches the echo server.
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	if _, err := conn.Write([]byte("SELECT 1")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "SELECT 1" {
+		t.Fatalf("expected echo, got %q", buf)
+	}
+}
+
+// pipe copies between the connections until either is closed.
+func pipe(a, b net.Conn) {
+	go func() {
+		_, _ = io.Copy(a, b)
+		a.Close()
+	}()
+	_, _ = io.Copy(b, a)
+	b.Close()
+}
+
+func TestHTTPProxyDialer(t *testing.T) {
+	target := startEchoServer(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil {
+					c.Close()
+					return
+				}
+				if _, password, _ := parseProxyAuth(req); req.Method != http.MethodConnect || password != "s3cret" {
+					_, _ = io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					c.Close()
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					c.Close()
+					return
+				}
+				_, _ = io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+				pipe(c, upstream)
+			}()
+		}
+	}()
+
+	d, err := newDialer("http://monitor:s3cret@"+l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+
+	d, err = newDialer("http://monitor:wrong@"+l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DialContext(context.Background(), "tcp", target); err == nil {
+		t.Fatal("expected the proxy to reject the credentials")
+	}
+}
+
+// parseProxyAuth returns the credentials of the Proxy-Authorization header.
+func parseProxyAuth(req *http.Request) (string, string, bool) {
+	r := &http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}
+	return r.BasicAuth()
+}
+
+// testSSHServer is an SSH server forwarding direct-tcpip channels, with a way to drop its connections.
+type testSSHServer struct {
+	addr    string
+	hostKey ssh.Signer
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func startSSHServer(t *testing.T, password string) *testSSHServer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if string(p) != password {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &testSSHServer{addr: l.Addr().String(), hostKey: hostKey}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, c)
+			s.mu.Unlock()
+			go s.serve(c, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) serve(c net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(c, cfg)
+	if err != nil {
+		c.Close()
+		return
+	}
+	// Replies to keepalives.
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "direct-tcpip" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		// Host and port to connect to, as per RFC 4254 section 7.2.
+		payload := nc.ExtraData()
+		n := binary.BigEndian.Uint32(payload)
+		host, port := string(payload[4:4+n]), binary.BigEndian.Uint32(payload[4+n:])
+		upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err != nil {
+			_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, creqs, err := nc.Accept()
+		if err != nil {
+			upstream.Close()
+			continue
+		}
+		go ssh.DiscardRequests(creqs)
+		go pipe(channelConn{ch}, upstream)
+	}
+}
+
+// drop closes the server side of all SSH connections.
+func (s *testSSHServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+// channelConn is the minimal net.Conn wrapper around an SSH channel needed by pipe.
+type channelConn struct {
+	ssh.Channel
+}
+
+func (channelConn) LocalAddr() net.Addr              { return nil }
+func (channelConn) RemoteAddr() net.Addr             { return nil }
+func (channelConn) SetDeadline(time.Time) error      { return nil }
+func (channelConn) SetReadDeadline(time.Time) error  { return nil }
+func (channelConn) SetWriteDeadline(time.Time) error { return nil }
+
+func TestSSHTunnel(t *testing.T) {
+	target := startEchoServer(t)
+	server := startSSHServer(t, "s3cret")
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, server.hostKey.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := newDialer("", &config.SSHTunnelConfig{
+		Address:        server.addr,
+		User:           "monitor",
+		Password:       "s3cret",
+		KnownHostsFile: knownHosts,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel := d.(*sshTunnel)
+	defer tunnel.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := tunnel.DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+	if err := tunnel.check(ctx); err != nil {
+		t.Fatalf("expected the tunnel to be up: %v", err)
+	}
+
+	// A broken SSH connection fails the check, and is opened again by the next one.
+	server.drop()
+	if err := tunnel.check(ctx); err == nil {
+		t.Fatal("expected the check to fail after the SSH connection was dropped")
+	}
+	if err := tunnel.check(ctx); err != nil {
+		t.Fatalf("expected the tunnel to be reopened: %v", err)
+	}
+	conn, err = tunnel.DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+}
+
+func TestSSHTunnel_UnknownHostKey(t *testing.T) {
+	server := startSSHServer(t, "s3cret")
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHosts, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tunnel := &sshTunnel{config: &config.SSHTunnelConfig{
+		Address:        server.addr,
+		User:           "monitor",
+		Password:       "s3cret",
+		KnownHostsFile: knownHosts,
+	}}
+	if err := tunnel.check(context.Background()); err == nil {
+		t.Fatal("expected the unknown host key to be rejected")
+	}
+}
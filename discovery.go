@@ -0,0 +1,618 @@
+package sql_exporter
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	promcfg "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+	"go.yaml.in/yaml/v3"
+)
+
+// Prefix of discovery specific labels, available to data source name templates but not applied to metrics.
+const metaLabelPrefix = "__"
+
+var (
+	sdTargetsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sd_discovered_targets",
+		Help: "Number of targets currently discovered per job and discovery mechanism",
+	}, []string{"job", "mechanism"})
+	sdFailuresMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sd_refresh_failures_total",
+		Help: "Total number of failed service discovery refreshes per job and discovery mechanism",
+	}, []string{"job", "mechanism"})
+)
+
+// TargetGroup is a set of discovered target addresses sharing the same labels, in the Prometheus file_sd and http_sd
+// format.
+type TargetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// discoverer implements a service discovery mechanism.
+type discoverer interface {
+	// discover returns the current target groups.
+	discover(ctx context.Context) ([]*TargetGroup, error)
+	// changes returns a channel signaling that targets may have changed before the next refresh, or nil.
+	changes() <-chan struct{}
+	// close releases any resources held by the discoverer.
+	close()
+}
+
+// discoveredTarget is a target created from a discovered address, along with what it was created from.
+type discoveredTarget struct {
+	target Target
+	dsn    string
+	labels prometheus.Labels
+}
+
+// discoveryManager runs the service discovery configs of all jobs and keeps the exporter's targets in sync with the
+// discovered ones. Targets that are still discovered with the same data source name and labels are kept as is, along
+// with their connection pools.
+type discoveryManager struct {
+	exporter *exporter
+	globals  *config.GlobalConfig
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu sync.Mutex
+	// Discovered targets, per provider (i.e. job and SD config) and target name.
+	targets map[string]map[string]*discoveredTarget
+	// Providers whose targets were handed over by the previous manager, on reload. Their targets are only kept by the
+	// first refresh if they still match the new configuration.
+	adopted map[string]struct{}
+}
+
+// startDiscovery starts the service discovery configs of the provided jobs, if any, and returns their manager. The
+// targets discovered by the previous manager (see halt), if any, are handed over to the new one: those of providers
+// that no longer exist are removed from the exporter and closed, the others are kept until the first refresh.
+func startDiscovery(
+	e *exporter, jobs []*config.JobConfig, globals *config.GlobalConfig, previous map[string]map[string]*discoveredTarget,
+) (*discoveryManager, error) {
+	type provider struct {
+		job *config.JobConfig
+		sd  config.SDConfig
+		d   discoverer
+	}
+	var providers []provider
+	for _, jc := range jobs {
+		if jc.EnablePing == nil {
+			jc.EnablePing = &config.EnablePing
+		}
+		for _, sd := range jc.SDConfigs() {
+			d, err := newDiscoverer(sd)
+			if err != nil {
+				for _, p := range providers {
+					p.d.close()
+				}
+				return nil, fmt.Errorf("job %q: failed to start %s_sd_config: %w", jc.Name, sd.Mechanism(), err)
+			}
+			providers = append(providers, provider{jc, sd, d})
+		}
+	}
+	if len(providers) == 0 {
+		dropDiscoveredTargets(e, previous)
+		return nil, nil
+	}
+	for _, c := range []prometheus.Collector{sdTargetsMetric, sdFailuresMetric} {
+		if err := e.registry.Register(c); err != nil && !errors.As(err, new(prometheus.AlreadyRegisteredError)) {
+			for _, p := range providers {
+				p.d.close()
+			}
+			return nil, err
+		}
+	}
+
+	// Identifies a provider across reloads, as long as the job's discovery configs of the same mechanism are unchanged.
+	keys := make([]string, len(providers))
+	for i, p := range providers {
+		n := 0
+		for _, other := range providers[:i] {
+			if other.job == p.job && other.sd.Mechanism() == p.sd.Mechanism() {
+				n++
+			}
+		}
+		keys[i] = fmt.Sprintf("%s/%s/%d", p.job.Name, p.sd.Mechanism(), n)
+	}
+	// Adopt the targets of providers that still exist, drop the others.
+	adopted := make(map[string]map[string]*discoveredTarget)
+	for _, key := range keys {
+		if targets, found := previous[key]; found {
+			adopted[key] = targets
+			delete(previous, key)
+		}
+	}
+	dropDiscoveredTargets(e, previous)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &discoveryManager{
+		exporter: e,
+		globals:  globals,
+		cancel:   cancel,
+		targets:  adopted,
+		adopted:  make(map[string]struct{}, len(adopted)),
+	}
+	for key := range adopted {
+		m.adopted[key] = struct{}{}
+	}
+	for i, p := range providers {
+		m.wg.Go(func() {
+			defer p.d.close()
+			m.run(ctx, keys[i], p.job, p.sd, p.d)
+		})
+	}
+	slog.Warn("Started service discovery", "providers", len(providers), "adopted", len(adopted))
+	return m, nil
+}
+
+// halt stops all discovery providers and returns the discovered targets, which are left as is in the exporter, to be
+// handed over to the next manager.
+func (m *discoveryManager) halt() map[string]map[string]*discoveredTarget {
+	m.cancel()
+	m.wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	targets := m.targets
+	m.targets = make(map[string]map[string]*discoveredTarget)
+	return targets
+}
+
+// stop stops all discovery providers, then removes all discovered targets from the exporter and closes them.
+func (m *discoveryManager) stop() {
+	dropDiscoveredTargets(m.exporter, m.halt())
+}
+
+// dropDiscoveredTargets removes the provided discovered targets from the exporter and closes them.
+func dropDiscoveredTargets(e *exporter, targets map[string]map[string]*discoveredTarget) {
+	owned := ownedTargets(targets)
+	if len(owned) == 0 {
+		return
+	}
+	e.replaceTargets(func(t Target) bool {
+		_, found := owned[t]
+		return found
+	}, nil)
+	closeTargets(slices.Collect(maps.Keys(owned)))
+}
+
+// run refreshes a single provider on every refresh interval and whenever it signals changes, until the context is
+// closed.
+func (m *discoveryManager) run(
+	ctx context.Context, key string, jc *config.JobConfig, sd config.SDConfig, d discoverer,
+) {
+	ticker := time.NewTicker(sd.RefreshInterval())
+	defer ticker.Stop()
+	for {
+		groups, err := d.discover(ctx)
+		if err != nil {
+			// Keep the previously discovered targets, as Prometheus does.
+			if ctx.Err() == nil {
+				sdFailuresMetric.WithLabelValues(jc.Name, sd.Mechanism()).Inc()
+				slog.Error("Service discovery failed", "job", jc.Name, "mechanism", sd.Mechanism(), "error", err)
+			}
+		} else {
+			m.update(key, jc, sd, groups)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.changes():
+		}
+	}
+}
+
+// update replaces the targets of a provider with the ones built from the provided target groups and updates the
+// exporter's targets accordingly.
+func (m *discoveryManager) update(key string, jc *config.JobConfig, sd config.SDConfig, groups []*TargetGroup) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	logContext := fmt.Sprintf(`job=%s`, jc.Name)
+	owned := ownedTargets(m.targets)
+	previous := m.targets[key]
+	// Targets handed over on reload are only kept if the new configuration would create them the same.
+	_, adopting := m.adopted[key]
+	delete(m.adopted, key)
+	current := make(map[string]*discoveredTarget)
+	var removed []Target
+
+	// Addresses already targets of the job, static or discovered by its other discovery configs, would export the same
+	// job and target labels. They're skipped, until they're no longer targets otherwise.
+	taken := make(map[string]struct{})
+	for _, sc := range jc.StaticConfigs {
+		for tname := range sc.Targets {
+			taken[tname] = struct{}{}
+		}
+	}
+	for other, targets := range m.targets {
+		for address, dt := range targets {
+			if other != key && dt.target.JobGroup() == jc.Name {
+				taken[address] = struct{}{}
+			}
+		}
+	}
+
+	for _, group := range groups {
+		for _, address := range group.Targets {
+			if _, found := current[address]; found {
+				continue
+			}
+			if _, found := taken[address]; found {
+				slog.Warn("Skipping discovered target, already a target of the job", "logContext", logContext, "target",
+					address)
+				continue
+			}
+			spec, err := discoveredTargetSpec(jc, sd, address, group.Labels)
+			if err != nil {
+				slog.Error("Skipping discovered target", "logContext", logContext, "target", address, "error", err)
+				continue
+			}
+			if dt, found := previous[address]; found && dt.dsn == spec.dsn && maps.Equal(dt.labels, spec.constLabels) &&
+				(!adopting || dt.target.(*target).matches(spec, m.globals)) {
+				current[address] = dt
+				continue
+			}
+			t, werr := spec.newTarget(m.globals)
+			if werr != nil {
+				slog.Error("Skipping discovered target", "logContext", logContext, "target", address, "error", werr)
+				continue
+			}
+			slog.Info("Discovered target", "logContext", logContext, "target", address)
+			current[address] = &discoveredTarget{target: t, dsn: spec.dsn, labels: spec.constLabels}
+		}
+	}
+	for address, dt := range previous {
+		if cur, found := current[address]; !found || cur != dt {
+			slog.Info("Removed discovered target", "logContext", logContext, "target", address)
+			removed = append(removed, dt.target)
+		}
+	}
+	m.targets[key] = current
+	sdTargetsMetric.WithLabelValues(jc.Name, sd.Mechanism()).Set(float64(len(current)))
+
+	// Replace all discovered targets, keeping the static ones.
+	m.exporter.replaceTargets(func(t Target) bool {
+		_, found := owned[t]
+		return found
+	}, slices.Collect(maps.Keys(ownedTargets(m.targets))))
+	closeTargets(removed)
+}
+
+// ownedTargets returns the set of all the provided discovered targets.
+func ownedTargets(discovered map[string]map[string]*discoveredTarget) map[Target]struct{} {
+	owned := make(map[Target]struct{})
+	for _, targets := range discovered {
+		for _, dt := range targets {
+			owned[dt.target] = struct{}{}
+		}
+	}
+	return owned
+}
+
+// discoveredTargetSpec returns the spec of a discovered target, with the settings of its job and discovery config.
+// Meta labels (starting with "__") are only available to the data source name template.
+func discoveredTargetSpec(
+	jc *config.JobConfig, sd config.SDConfig, address string, groupLabels map[string]string,
+) (*targetSpec, error) {
+	labels := prometheus.Labels{
+		"job":              jc.Name,
+		config.TargetLabel: address,
+	}
+	for name, value := range groupLabels {
+		if strings.HasPrefix(name, metaLabelPrefix) {
+			continue
+		}
+		if _, found := labels[name]; found {
+			return nil, fmt.Errorf("discovered label %q conflicts with the job or target label", name)
+		}
+		if !model.LabelName(name).IsValid() {
+			return nil, fmt.Errorf("invalid discovered label name %q", name)
+		}
+		labels[name] = value
+	}
+	dsn, err := sd.DataSourceName(address, groupLabels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render data_source_name: %w", err)
+	}
+	st := sd.Target()
+	return &targetSpec{
+		logContext:           fmt.Sprintf(`job=%s`, jc.Name),
+		name:                 address,
+		jobGroup:             jc.Name,
+		dsn:                  dsn,
+		credentials:          st.Credentials,
+		auth:                 st.Auth,
+		tls:                  cmp.Or(st.TLS, jc.TLS),
+		proxyURL:             st.ProxyURL,
+		sshTunnel:            st.SSHTunnel,
+		connection:           jc.ConnectionConfig,
+		maxConcurrentQueries: jc.MaxConcurrentQueries,
+		refs:                 st.SecretRefs(),
+		collectors:           jc.Collectors(),
+		constLabels:          labels,
+		enablePing:           jc.EnablePing,
+	}, nil
+}
+
+// newDiscoverer returns the discoverer for the provided config.
+func newDiscoverer(sd config.SDConfig) (discoverer, error) {
+	switch sd := sd.(type) {
+	case *config.FileSDConfig:
+		return newFileDiscoverer(sd)
+	case *config.DNSSDConfig:
+		return &dnsDiscoverer{config: sd, resolver: net.DefaultResolver}, nil
+	case *config.HTTPSDConfig:
+		return newHTTPDiscoverer(sd)
+	default:
+		return nil, fmt.Errorf("unsupported service discovery config %T", sd)
+	}
+}
+
+//
+// File SD
+//
+
+// fileDiscoverer reads target groups from JSON or YAML files, refreshed whenever their directory changes.
+type fileDiscoverer struct {
+	config *config.FileSDConfig
+	// Absolute, cleaned file patterns, as fsnotify reports cleaned paths.
+	patterns []string
+	watcher  *fsnotify.Watcher
+	events   chan struct{}
+	done     chan struct{}
+}
+
+func newFileDiscoverer(sd *config.FileSDConfig) (*fileDiscoverer, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	patterns := make([]string, 0, len(sd.Files))
+	for _, file := range sd.Files {
+		pattern, err := filepath.Abs(file)
+		if err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	// Watch directories rather than files, so that files created later or replaced through renames are picked up.
+	for _, pattern := range patterns {
+		dir := filepath.Dir(pattern)
+		if err := watcher.Add(dir); err != nil {
+			slog.Warn("Unable to watch file_sd directory, relying on refresh_interval", "dir", dir, "error", err)
+		}
+	}
+
+	d := &fileDiscoverer{
+		config:   sd,
+		patterns: patterns,
+		watcher:  watcher,
+		events:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go d.watch()
+	return d, nil
+}
+
+// watch forwards relevant file system events, coalescing them, until the discoverer is closed.
+func (d *fileDiscoverer) watch() {
+	for {
+		select {
+		case <-d.done:
+			return
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("file_sd watcher error", "error", err)
+		case event, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod || !d.matches(event.Name) {
+				continue
+			}
+			select {
+			case d.events <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// matches returns whether the file matches any of the configured patterns.
+func (d *fileDiscoverer) matches(file string) bool {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range d.patterns {
+		if ok, _ := filepath.Match(pattern, file); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *fileDiscoverer) discover(_ context.Context) ([]*TargetGroup, error) {
+	var groups []*TargetGroup
+	for _, pattern := range d.patterns {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			fileGroups, err := readTargetGroups(file)
+			if err != nil {
+				return nil, err
+			}
+			groups = append(groups, fileGroups...)
+		}
+	}
+	return groups, nil
+}
+
+// readTargetGroups reads target groups from a JSON or YAML file, adding the __meta_filepath label.
+func readTargetGroups(file string) ([]*TargetGroup, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*TargetGroup
+	if filepath.Ext(file) == ".json" {
+		err = json.Unmarshal(buf, &groups)
+	} else {
+		err = yaml.Unmarshal(buf, &groups)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing file_sd file %s: %w", file, err)
+	}
+	for _, group := range groups {
+		if group.Labels == nil {
+			group.Labels = make(map[string]string, 1)
+		}
+		group.Labels["__meta_filepath"] = file
+	}
+	return groups, nil
+}
+
+func (d *fileDiscoverer) changes() <-chan struct{} {
+	return d.events
+}
+
+func (d *fileDiscoverer) close() {
+	close(d.done)
+	if err := d.watcher.Close(); err != nil {
+		slog.Warn("Error closing file_sd watcher", "error", err)
+	}
+}
+
+//
+// DNS SD
+//
+
+// dnsDiscoverer resolves SRV, A or AAAA records into target addresses, one target group per name.
+type dnsDiscoverer struct {
+	config   *config.DNSSDConfig
+	resolver *net.Resolver
+}
+
+func (d *dnsDiscoverer) discover(ctx context.Context) ([]*TargetGroup, error) {
+	groups := make([]*TargetGroup, 0, len(d.config.Names))
+	for _, name := range d.config.Names {
+		group := &TargetGroup{Labels: map[string]string{"__meta_dns_name": name}}
+		switch d.config.Type {
+		case config.DNSTypeSRV:
+			_, records, err := d.resolver.LookupSRV(ctx, "", "", name)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range records {
+				host := strings.TrimSuffix(r.Target, ".")
+				group.Targets = append(group.Targets, net.JoinHostPort(host, fmt.Sprint(r.Port)))
+			}
+		default:
+			network := "ip4"
+			if d.config.Type == config.DNSTypeAAAA {
+				network = "ip6"
+			}
+			ips, err := d.resolver.LookupIP(ctx, network, name)
+			if err != nil {
+				return nil, err
+			}
+			for _, ip := range ips {
+				group.Targets = append(group.Targets, net.JoinHostPort(ip.String(), fmt.Sprint(d.config.Port)))
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func (d *dnsDiscoverer) changes() <-chan struct{} {
+	return nil
+}
+
+func (d *dnsDiscoverer) close() {}
+
+//
+// HTTP SD
+//
+
+// httpDiscoverer fetches target groups from an HTTP endpoint, as JSON.
+type httpDiscoverer struct {
+	config *config.HTTPSDConfig
+	client *http.Client
+}
+
+func newHTTPDiscoverer(sd *config.HTTPSDConfig) (*httpDiscoverer, error) {
+	client, err := promcfg.NewClientFromConfig(sd.HTTPClientConfig(), "sql_exporter_http_sd")
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = sd.RefreshInterval()
+	return &httpDiscoverer{config: sd, client: client}, nil
+}
+
+func (d *httpDiscoverer) discover(ctx context.Context) ([]*TargetGroup, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "sql_exporter/"+version.Version)
+	req.Header.Set("X-Prometheus-Refresh-Interval-Seconds", fmt.Sprint(d.config.RefreshInterval().Seconds()))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+
+	var groups []*TargetGroup
+	if err := json.NewDecoder(resp.Body).Decode(&groups); err != nil {
+		return nil, fmt.Errorf("error parsing http_sd response: %w", err)
+	}
+	for _, group := range groups {
+		if group.Labels == nil {
+			group.Labels = make(map[string]string, 1)
+		}
+		group.Labels["__meta_url"] = d.config.URL
+	}
+	return groups, nil
+}
+
+func (d *httpDiscoverer) changes() <-chan struct{} {
+	return nil
+}
+
+func (d *httpDiscoverer) close() {
+	d.client.CloseIdleConnections()
+}
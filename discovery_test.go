@@ -0,0 +1,281 @@
+package sql_exporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.yaml.in/yaml/v3"
+)
+
+func TestReadTargetGroups(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.json": `[{"targets": ["db1:5432", "db2:5432"], "labels": {"env": "prod"}}]`,
+		"b.yml":  "- targets: [db3:5432]\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups, err := readTargetGroups(filepath.Join(dir, "a.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || !slices.Equal(groups[0].Targets, []string{"db1:5432", "db2:5432"}) ||
+		groups[0].Labels["env"] != "prod" || groups[0].Labels["__meta_filepath"] != filepath.Join(dir, "a.json") {
+		t.Errorf("unexpected groups: %+v", groups[0])
+	}
+
+	groups, err = readTargetGroups(filepath.Join(dir, "b.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || !slices.Equal(groups[0].Targets, []string{"db3:5432"}) {
+		t.Errorf("unexpected groups: %+v", groups[0])
+	}
+}
+
+func TestHTTPDiscoverer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Prometheus-Refresh-Interval-Seconds") != "60" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"targets": ["db1:5432"], "labels": {"env": "prod"}}]`))
+	}))
+	defer srv.Close()
+
+	var sd config.HTTPSDConfig
+	if err := yaml.Unmarshal([]byte("url: "+srv.URL+"\ndata_source_name: postgres://{{ .Address }}/app\n"), &sd); err != nil {
+		t.Fatal(err)
+	}
+	d, err := newHTTPDiscoverer(&sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.close()
+
+	groups, err := d.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || !slices.Equal(groups[0].Targets, []string{"db1:5432"}) ||
+		groups[0].Labels["__meta_url"] != srv.URL {
+		t.Errorf("unexpected groups: %+v", groups)
+	}
+}
+
+func TestDiscoveryManagerUpdate(t *testing.T) {
+	var sd config.FileSDConfig
+	content := "files: [targets.json]\ndata_source_name: postgres://{{ .Address }}/{{ .Labels.db }}\n"
+	if err := yaml.Unmarshal([]byte(content), &sd); err != nil {
+		t.Fatal(err)
+	}
+	jc := &config.JobConfig{Name: "pg"}
+	static, err := NewTarget("", "static", "pg", "postgres://static/app", nil, nil, &config.GlobalConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &exporter{targets: []Target{static}}
+	m := &discoveryManager{exporter: e, globals: &config.GlobalConfig{}, targets: map[string]map[string]*discoveredTarget{}}
+
+	m.update("pg", jc, &sd, []*TargetGroup{
+		{Targets: []string{"db1:5432", "db2:5432"}, Labels: map[string]string{"db": "app", "__meta_filepath": "x"}},
+	})
+	if len(e.Targets()) != 3 || e.Targets()[0] != static {
+		t.Fatalf("expected the static target and 2 discovered targets, got %d", len(e.Targets()))
+	}
+	db1 := m.targets["pg"]["db1:5432"]
+	if db1.dsn != "postgres://db1:5432/app" || db1.labels["db"] != "app" || db1.labels["job"] != "pg" {
+		t.Errorf("unexpected discovered target: %+v", db1)
+	}
+	if _, found := db1.labels["__meta_filepath"]; found {
+		t.Error("meta labels must not be applied to discovered targets")
+	}
+
+	// db1 is unchanged and must be kept, db2 is removed and db3 added.
+	m.update("pg", jc, &sd, []*TargetGroup{
+		{Targets: []string{"db1:5432", "db3:5432"}, Labels: map[string]string{"db": "app"}},
+	})
+	if len(e.Targets()) != 3 || e.Targets()[0] != static {
+		t.Fatalf("expected the static target and 2 discovered targets, got %d", len(e.Targets()))
+	}
+	if m.targets["pg"]["db1:5432"] != db1 {
+		t.Error("unchanged discovered target was recreated")
+	}
+	if _, found := m.targets["pg"]["db2:5432"]; found {
+		t.Error("removed discovered target was kept")
+	}
+
+	// Conflicting labels are rejected.
+	m.update("pg", jc, &sd, []*TargetGroup{{Targets: []string{"db1:5432"}, Labels: map[string]string{"job": "x"}}})
+	if len(e.Targets()) != 1 {
+		t.Errorf("expected only the static target, got %d", len(e.Targets()))
+	}
+}
+
+func TestDiscoveredTargetSettings(t *testing.T) {
+	var sd config.DNSSDConfig
+	content := `names: [db.example.com]
+data_source_name: postgres://{{ .Address }}/app
+username: monitor
+auth: {type: aws_rds_iam, region: eu-west-1}
+proxy_url: socks5://bastion:1080
+target_tls_config: {server_name: db.internal}
+`
+	if err := yaml.Unmarshal([]byte(content), &sd); err != nil {
+		t.Fatal(err)
+	}
+	jc := &config.JobConfig{Name: "pg", MaxConcurrentQueries: 2, TLS: &config.TLSConfig{ServerName: "job.internal"}}
+	e := &exporter{}
+	m := &discoveryManager{exporter: e, globals: &config.GlobalConfig{}, targets: map[string]map[string]*discoveredTarget{}}
+	defer func() { closeTargets(e.Targets()) }()
+
+	m.update("pg", jc, &sd, []*TargetGroup{{Targets: []string{"db1:5432"}}})
+	if len(e.Targets()) != 1 {
+		t.Fatalf("expected a discovered target, got %d", len(e.Targets()))
+	}
+	dt := e.Targets()[0].(*target)
+	if dt.dialer == nil || dt.proxyURL != "socks5://bastion:1080" {
+		t.Errorf("expected the discovered target to connect through the proxy, got %q", dt.proxyURL)
+	}
+	if dt.auth == nil || dt.auth.Type != "aws_rds_iam" || dt.credentials.Username != "monitor" {
+		t.Errorf("expected the discovered target to use the auth and credentials, got %+v, %+v", dt.auth, dt.credentials)
+	}
+	if dt.maxConcurrentQueries != 2 || dt.queries.target == nil {
+		t.Error("expected the job's max_concurrent_queries to apply to the discovered target")
+	}
+	if dt.tls == nil || dt.tls.ServerName != "db.internal" {
+		t.Errorf("expected the discovery config's TLS config to replace the job's, got %+v", dt.tls)
+	}
+}
+
+func TestDiscoveryManagerDuplicates(t *testing.T) {
+	var sd config.FileSDConfig
+	if err := yaml.Unmarshal([]byte("files: [targets.json]\ndata_source_name: postgres://{{ .Address }}/app\n"),
+		&sd); err != nil {
+		t.Fatal(err)
+	}
+	jc := &config.JobConfig{Name: "pg", StaticConfigs: []*config.StaticConfig{
+		{Targets: map[string]config.Secret{"db0:5432": "postgres://db0:5432/app"}},
+	}}
+	e := &exporter{}
+	m := &discoveryManager{exporter: e, globals: &config.GlobalConfig{}, targets: map[string]map[string]*discoveredTarget{}}
+	defer func() { closeTargets(e.Targets()) }()
+
+	// Addresses targeted by the static config or another discovery config of the job are skipped.
+	m.update("pg/file/0", jc, &sd, []*TargetGroup{{Targets: []string{"db0:5432", "db1:5432"}}})
+	m.update("pg/file/1", jc, &sd, []*TargetGroup{{Targets: []string{"db1:5432", "db2:5432"}}})
+	if len(e.Targets()) != 2 || len(m.targets["pg/file/0"]) != 1 || len(m.targets["pg/file/1"]) != 1 {
+		t.Fatalf("expected db1 and db2 to be discovered once each, got %d targets", len(e.Targets()))
+	}
+	if _, found := m.targets["pg/file/1"]["db2:5432"]; !found {
+		t.Error("expected db2 to be discovered by the second config")
+	}
+
+	// Picked up by the other discovery config once no longer discovered by the first.
+	m.update("pg/file/0", jc, &sd, nil)
+	m.update("pg/file/1", jc, &sd, []*TargetGroup{{Targets: []string{"db1:5432", "db2:5432"}}})
+	if len(e.Targets()) != 2 || len(m.targets["pg/file/1"]) != 2 {
+		t.Errorf("expected db1 to be discovered by the second config, got %d targets", len(e.Targets()))
+	}
+}
+
+func TestFileDiscovererMatches(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.Mkdir("sd", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	d, err := newFileDiscoverer(&config.FileSDConfig{Files: []string{"./sd/*.json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.close()
+
+	// fsnotify reports cleaned paths, relative to the watched directory.
+	if !d.matches("sd/targets.json") || !d.matches(filepath.Join(dir, "sd", "targets.json")) {
+		t.Error("expected the cleaned event paths to match the pattern")
+	}
+	if d.matches("sd/targets.yml") {
+		t.Error("expected other files not to match")
+	}
+}
+
+func TestDiscoveryHandover(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "targets.json")
+	if err := os.WriteFile(file, []byte(`[{"targets": ["db1:5432"]}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var sd config.FileSDConfig
+	if err := yaml.Unmarshal([]byte("files: ["+file+"]\ndata_source_name: postgres://{{ .Address }}/app\n"), &sd); err != nil {
+		t.Fatal(err)
+	}
+	jobs := []*config.JobConfig{{Name: "pg", FileSDConfigs: []*config.FileSDConfig{&sd}}}
+	gc := &config.GlobalConfig{}
+	e := &exporter{registry: prometheus.NewRegistry()}
+
+	m, err := startDiscovery(e, jobs, gc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor := func(cond func() bool) {
+		t.Helper()
+		for range 100 {
+			if cond() {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("timed out waiting for service discovery")
+	}
+	waitFor(func() bool { return len(e.Targets()) == 1 })
+	db1 := e.Targets()[0]
+
+	// The unchanged discovered target is handed over to the new manager and kept as is.
+	m, err = startDiscovery(e, jobs, gc, m.halt())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.adopted) == 0
+	})
+	if targets := e.Targets(); len(targets) != 1 || targets[0] != db1 {
+		t.Fatalf("expected the discovered target to be kept, got %v", targets)
+	}
+
+	// Changed global settings recreate it.
+	m, err = startDiscovery(e, jobs, &config.GlobalConfig{MaxConns: 7}, m.halt())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.adopted) == 0
+	})
+	if targets := e.Targets(); len(targets) != 1 || targets[0] == db1 {
+		t.Fatalf("expected the discovered target to be recreated, got %v", targets)
+	}
+
+	// Targets of removed discovery configs are dropped.
+	if m, err = startDiscovery(e, nil, gc, m.halt()); err != nil || m != nil {
+		t.Fatalf("expected no discovery, got %v, %v", m, err)
+	}
+	if len(e.Targets()) != 0 {
+		t.Fatalf("expected the discovered targets to be dropped, got %d", len(e.Targets()))
+	}
+}
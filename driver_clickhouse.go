@@ -0,0 +1,44 @@
+//go:build !noclickhouse
+
+package sql_exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql/driver"
+	"net"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+func init() {
+	driverConnectors["clickhouse"] = newClickHouseConnector
+}
+
+// newClickHouseConnector returns a clickhouse-go connector applying the options.
+func newClickHouseConnector(dsn string, opts driverOptions) (driver.Connector, error) {
+	chOpts, err := clickhouse.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if opts.tls != nil {
+		chOpts.TLS = opts.tls
+	}
+	if opts.dial != nil {
+		native := chOpts.Protocol == clickhouse.Native
+		chOpts.DialContext = func(ctx context.Context, addr string) (net.Conn, error) {
+			conn, err := opts.dial(ctx, "tcp", addr)
+			// The native protocol only does TLS itself without a custom dial function, HTTP does it either way.
+			if err != nil || !native || chOpts.TLS == nil {
+				return conn, err
+			}
+			tlsConn := tls.Client(conn, chOpts.TLS)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+	}
+	return clickhouse.Connector(chOpts), nil
+}
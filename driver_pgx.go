@@ -0,0 +1,46 @@
+//go:build !nopgx
+
+package sql_exporter
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+func init() {
+	driverConnectors["pgx"] = newPgxConnector
+	authErrorMatchers = append(authErrorMatchers, isPgxAuthError)
+}
+
+// isPgxAuthError returns whether err is a pgx error rejecting the credentials.
+func isPgxAuthError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && isPostgresAuthCode(pgErr.Code)
+}
+
+// newPgxConnector returns a pgx connector applying the options to all hosts of the data source name.
+func newPgxConnector(dsn string, opts driverOptions) (driver.Connector, error) {
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if opts.tls != nil {
+		cfg.TLSConfig = opts.tlsForHost(cfg.Host)
+		for _, fallback := range cfg.Fallbacks {
+			fallback.TLSConfig = opts.tlsForHost(fallback.Host)
+		}
+	}
+	if opts.dial != nil {
+		cfg.DialFunc = pgconn.DialFunc(opts.dial)
+		// Host names are resolved on the other end of the proxy or tunnel.
+		cfg.LookupFunc = func(_ context.Context, host string) ([]string, error) {
+			return []string{host}, nil
+		}
+	}
+	return stdlib.GetConnector(*cfg), nil
+}
@@ -0,0 +1,80 @@
+//go:build !nopostgres
+
+package sql_exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func init() {
+	driverConnectors["postgres"] = newPostgresConnector
+	authErrorMatchers = append(authErrorMatchers, isPostgresAuthError)
+}
+
+// isPostgresAuthError returns whether err is a lib/pq error rejecting the credentials.
+func isPostgresAuthError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && isPostgresAuthCode(string(pqErr.Code))
+}
+
+// pqTLSVersions maps TLS versions to lib/pq's, which override the config's min version.
+var pqTLSVersions = map[uint16]pq.SSLProtocolVersion{
+	tls.VersionTLS10: pq.SSLProtocolVersionTLS10,
+	tls.VersionTLS11: pq.SSLProtocolVersionTLS11,
+	tls.VersionTLS12: pq.SSLProtocolVersionTLS12,
+	tls.VersionTLS13: pq.SSLProtocolVersionTLS13,
+}
+
+// newPostgresConnector returns a lib/pq connector applying the options. lib/pq only takes custom TLS configs from its
+// global registry, so the config is registered under the target's key, replacing the one of its previous connection
+// pool, if any.
+func newPostgresConnector(dsn string, opts driverOptions) (driver.Connector, error) {
+	cfg, err := pq.NewConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if opts.tls != nil {
+		if err := pq.RegisterTLSConfig(opts.tlsKey, opts.tls); err != nil {
+			return nil, err
+		}
+		cfg.SSLMode = pq.SSLMode("pqgo-" + opts.tlsKey)
+		if v, ok := pqTLSVersions[opts.tls.MinVersion]; ok {
+			cfg.SSLMinProtocolVersion = v
+		}
+	}
+	connector, err := pq.NewConnectorConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if opts.dial != nil {
+		connector.Dialer(pqDialer(opts.dial))
+	}
+	return connector, nil
+}
+
+// pqDialer adapts a dialFunc to lib/pq's dialer interfaces.
+type pqDialer dialFunc
+
+// Dial implements pq.Dialer.
+func (d pqDialer) Dial(network, address string) (net.Conn, error) {
+	return d(context.Background(), network, address)
+}
+
+// DialTimeout implements pq.Dialer.
+func (d pqDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d(ctx, network, address)
+}
+
+// DialContext implements pq.DialerContext.
+func (d pqDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d(ctx, network, address)
+}
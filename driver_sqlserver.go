@@ -0,0 +1,58 @@
+//go:build !nosqlserver
+
+package sql_exporter
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/msdsn"
+)
+
+func init() {
+	driverConnectors["sqlserver"] = newSQLServerConnector
+	authErrorMatchers = append(authErrorMatchers, isSQLServerAuthError)
+}
+
+// isSQLServerAuthError returns whether err is a go-mssqldb error rejecting the credentials.
+func isSQLServerAuthError(err error) bool {
+	var mssqlErr mssql.Error
+	return errors.As(err, &mssqlErr) && mssqlErr.Number == 18456 // Login failed for user
+}
+
+// newSQLServerConnector returns a go-mssqldb connector applying the options. A TLS config encrypts the whole
+// connection.
+func newSQLServerConnector(dsn string, opts driverOptions) (driver.Connector, error) {
+	cfg, err := msdsn.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if opts.tls != nil {
+		cfg.TLSConfig = opts.tls
+		if cfg.Encryption == msdsn.EncryptionOff || cfg.Encryption == msdsn.EncryptionDisabled {
+			cfg.Encryption = msdsn.EncryptionRequired
+		}
+	}
+	connector := mssql.NewConnectorConfig(cfg)
+	if opts.dial != nil {
+		connector.Dialer = mssqlDialer(opts.dial)
+	}
+	return connector, nil
+}
+
+// mssqlDialer adapts a dialFunc to go-mssqldb's dialer interface. It implements mssql.HostDialer, so that host names
+// are resolved on the other end of the proxy or tunnel.
+type mssqlDialer dialFunc
+
+// DialContext implements mssql.Dialer.
+func (d mssqlDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
+
+// HostName implements mssql.HostDialer.
+func (d mssqlDialer) HostName() string {
+	return ""
+}
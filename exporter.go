@@ -12,6 +12,7 @@ import (
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/burningalchemist/sql_exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,8 +20,10 @@ import (
 )
 
 var (
-	SvcRegistry        = prometheus.NewRegistry()
-	svcMetricLabels    = []string{"job", "target", "collector", "query"}
+	SvcRegistry     = prometheus.NewRegistry()
+	svcMetricLabels = []string{"job", "target", "collector", "query"}
+	// Additional scrape_errors_total label, distinguishing timeouts from other errors.
+	reasonLabel        = "reason"
 	scrapeErrorsMetric *prometheus.CounterVec
 )
 
@@ -38,11 +41,16 @@ type Exporter interface {
 	Targets() []Target
 	// SetJobFilters sets the jobFilters field
 	SetJobFilters([]string) error
+	// Probe returns a (single use) Exporter for the named target, with the collectors of the named probe module and
+	// the data source name rendered from the named auth module (the module's own if empty).
+	Probe(target, module, authModule string) (Exporter, error)
 	// DropErrorMetrics resets the scrape_errors_total metric
 	DropErrorMetrics()
 	// FilterScrapeErrorsTotal filters the scrape_errors_total metric family to only include metrics for the jobs in
 	// the jobFilters list.
 	FilterScrapeErrorsTotal([]*dto.MetricFamily) []*dto.MetricFamily
+	// Close stops service discovery and secret refreshing, and closes all targets along with their connection pools.
+	Close() error
 }
 
 type exporter struct {
@@ -50,8 +58,11 @@ type exporter struct {
 	targets    []Target
 	jobFilters []string
 
-	ctx      context.Context
-	registry prometheus.Registerer
+	ctx       context.Context
+	registry  prometheus.Registerer
+	discovery *discoveryManager
+	probes    *probeCache
+	secrets   *secretRefresher
 
 	mu sync.RWMutex
 }
@@ -65,55 +76,71 @@ func NewExporter(configFile string, registry prometheus.Registerer) (Exporter, e
 
 	// Override the DSN if requested (and in single target mode).
 	if config.DsnOverride != "" {
-		if len(c.Jobs) > 0 {
+		if c.Target == nil {
 			return nil, errors.New("the config.data-source-name flag only applies in single target mode")
 		}
 		c.Target.DSN = config.Secret(config.DsnOverride)
 	}
 
-	var targets []Target
-	if c.Target != nil {
-		target, err := NewTarget("", c.Target.Name, "", string(c.Target.DSN),
-			c.Target.Collectors(), nil, c.Globals, c.Target.EnablePing)
+	if len(c.Jobs) > (config.MaxInt32 / 3) {
+		return nil, errors.New("'jobs' list is too large")
+	}
+	specs, err := configTargetSpecs(c)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]Target, 0, len(specs))
+	for _, spec := range specs {
+		target, err := spec.newTarget(c.Globals)
 		if err != nil {
+			closeTargets(targets)
 			return nil, err
 		}
-		targets = []Target{target}
-	} else {
-		if len(c.Jobs) > (config.MaxInt32 / 3) {
-			return nil, errors.New("'jobs' list is too large")
-		}
-		targets = make([]Target, 0, len(c.Jobs)*3)
-		for _, jc := range c.Jobs {
-			job, err := NewJob(jc, c.Globals)
-			if err != nil {
-				return nil, err
-			}
-			targets = append(targets, job.Targets()...)
-		}
+		targets = append(targets, target)
 	}
 
 	scrapeErrorsMetric, err = registerScrapeErrorMetric(registry)
 	if err != nil {
 		return nil, err
 	}
+	queryQueueWaitMetric, err = registerQueryQueueWaitMetric(registry)
+	if err != nil {
+		return nil, err
+	}
+	if err := registry.Register(secretLeaseCollector{}); err != nil &&
+		!errors.As(err, new(prometheus.AlreadyRegisteredError)) {
+		return nil, err
+	}
 
-	return &exporter{
+	e := &exporter{
 		config:     c,
 		targets:    targets,
 		jobFilters: nil,
 		ctx:        context.Background(),
 		registry:   registry,
-	}, nil
+		probes:     newProbeCache(),
+	}
+	if err := registry.Register(poolStatsCollector{exporter: e}); err != nil &&
+		!errors.As(err, new(prometheus.AlreadyRegisteredError)) {
+		closeTargets(targets)
+		return nil, err
+	}
+	if e.discovery, err = startDiscovery(e, c.Jobs, c.Globals, nil); err != nil {
+		closeTargets(targets)
+		return nil, err
+	}
+	e.secrets = startSecretRefresher(e, time.Duration(c.Globals.SecretRefreshInterval))
+	return e, nil
 }
 
 func (e *exporter) WithContext(ctx context.Context) Exporter {
 	return &exporter{
-		config:     e.config,
-		targets:    e.targets,
+		config:     e.Config(),
+		targets:    e.Targets(),
 		jobFilters: e.jobFilters,
 		ctx:        ctx,
 		registry:   e.registry,
+		probes:     e.probes,
 	}
 }
 
@@ -164,10 +191,11 @@ func (e *exporter) Gather() ([]*dto.MetricFamily, error) {
 			errs = append(errs, err)
 			if err.Context() != "" {
 				ctxLabels := parseContextLog(err.Context())
-				values := make([]string, len(svcMetricLabels))
+				values := make([]string, len(svcMetricLabels), len(svcMetricLabels)+1)
 				for i, label := range svcMetricLabels {
 					values[i] = ctxLabels[label]
 				}
+				values = append(values, errorReason(err))
 				scrapeErrorsMetric.WithLabelValues(values...).Inc()
 			}
 			continue
@@ -183,6 +211,12 @@ func (e *exporter) Gather() ([]*dto.MetricFamily, error) {
 				dtoMetricFamily.Type = dto.MetricType_GAUGE.Enum()
 			case dtoMetric.Counter != nil:
 				dtoMetricFamily.Type = dto.MetricType_COUNTER.Enum()
+			case dtoMetric.Histogram != nil:
+				dtoMetricFamily.Type = dto.MetricType_HISTOGRAM.Enum()
+			case dtoMetric.Summary != nil:
+				dtoMetricFamily.Type = dto.MetricType_SUMMARY.Enum()
+			case dtoMetric.Untyped != nil:
+				dtoMetricFamily.Type = dto.MetricType_UNTYPED.Enum()
 			default:
 				errs = append(errs, fmt.Errorf("don't know how to handle metric %v", dtoMetric))
 				continue
@@ -256,9 +290,18 @@ func (e *exporter) FilterScrapeErrorsTotal(mfs []*dto.MetricFamily) []*dto.Metri
 
 // Config implements Exporter.
 func (e *exporter) Config() *config.Config {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	return e.config
 }
 
+// setConfig replaces the exporter's config, e.g. on reload. The config itself is never modified once in use.
+func (e *exporter) setConfig(c *config.Config) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.config = c
+}
+
 func (e *exporter) Targets() []Target {
 	// We need to lock targets for the duration of the snapshot to ensure we don't return partially updated targets.
 	e.mu.RLock()
@@ -266,6 +309,34 @@ func (e *exporter) Targets() []Target {
 	return e.targets
 }
 
+// Close implements Exporter. Must not be called concurrently with Reload.
+func (e *exporter) Close() error {
+	if e.discovery != nil {
+		e.discovery.stop()
+		e.discovery = nil
+	}
+	if e.secrets != nil {
+		e.secrets.stop()
+		e.secrets = nil
+	}
+	if e.probes != nil {
+		e.probes.purge()
+	}
+
+	e.mu.Lock()
+	targets := e.targets
+	e.targets = nil
+	e.mu.Unlock()
+
+	var errs []error
+	for _, t := range targets {
+		if err := t.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // UpdateTarget implements Exporter.
 func (e *exporter) UpdateTarget(target []Target) {
 	// We need to lock targets for the duration of the update to ensure we don't return partially updated targets.
@@ -274,6 +345,14 @@ func (e *exporter) UpdateTarget(target []Target) {
 	e.targets = target
 }
 
+// replaceTargets removes the targets for which drop returns true and appends the provided ones, under the same lock, so
+// that concurrent updates of different sets of targets (e.g. discovered and static ones) don't overwrite each other.
+func (e *exporter) replaceTargets(drop func(Target) bool, add []Target) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.targets = append(slices.DeleteFunc(slices.Clone(e.targets), drop), add...)
+}
+
 // SetJobFilters implements Exporter.
 func (e *exporter) SetJobFilters(filters []string) error {
 	// If the filters list contains a single empty string, treat it as no filters.
@@ -284,14 +363,15 @@ func (e *exporter) SetJobFilters(filters []string) error {
 	}
 
 	// Single target mode has no jobs - filters are not applicable
-	if len(e.config.Jobs) == 0 {
+	jobs := e.Config().Jobs
+	if len(jobs) == 0 {
 		slog.Warn("Job filters are not applicable in single target mode, ignoring", "filters", filters)
 		e.jobFilters = nil
 		return nil
 	}
 
 	for _, name := range filters {
-		if !slices.ContainsFunc(e.config.Jobs, func(j *config.JobConfig) bool {
+		if !slices.ContainsFunc(jobs, func(j *config.JobConfig) bool {
 			return j.Name == name
 		}) {
 			return fmt.Errorf("invalid job name: %s", name)
@@ -312,8 +392,8 @@ func (e *exporter) DropErrorMetrics() {
 func registerScrapeErrorMetric(registry prometheus.Registerer) (*prometheus.CounterVec, error) {
 	scrapeErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "scrape_errors_total",
-		Help: "Total number of scrape errors per job, target, collector and query",
-	}, svcMetricLabels)
+		Help: "Total number of scrape errors per job, target, collector, query and reason (timeout or error)",
+	}, append(slices.Clone(svcMetricLabels), reasonLabel))
 
 	if err := registry.Register(scrapeErrors); err != nil {
 		var alreadyRegisteredErr prometheus.AlreadyRegisteredError
@@ -327,6 +407,15 @@ func registerScrapeErrorMetric(registry prometheus.Registerer) (*prometheus.Coun
 	return scrapeErrors, nil
 }
 
+// errorReason returns the reason label value for a scrape error: "timeout" if it was caused by a scrape, collector or
+// query timeout, "error" otherwise.
+func errorReason(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "error"
+}
+
 // split comma separated list of key=value pairs and return a map of key value pairs
 func parseContextLog(list string) map[string]string {
 	m := make(map[string]string)
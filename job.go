@@ -1,6 +1,7 @@
 package sql_exporter
 
 import (
+	"cmp"
 	"fmt"
 
 	"github.com/burningalchemist/sql_exporter/config"
@@ -22,16 +23,79 @@ type job struct {
 
 // NewJob returns a new Job with the given configuration.
 func NewJob(jc *config.JobConfig, gc *config.GlobalConfig) (Job, errors.WithContext) {
+	specs, err := jobTargetSpecs(jc)
+	if err != nil {
+		return nil, err
+	}
+
 	j := job{
 		config:     jc,
-		targets:    make([]Target, 0, 10),
+		targets:    make([]Target, 0, len(specs)),
 		logContext: fmt.Sprintf(`job=%s`, jc.Name),
 	}
+	for _, spec := range specs {
+		t, err := spec.newTarget(gc)
+		if err != nil {
+			return nil, err
+		}
+		j.targets = append(j.targets, t)
+	}
+
+	return &j, nil
+}
+
+// targetSpec holds everything a target is created from, except for the global config.
+type targetSpec struct {
+	logContext           string
+	name                 string
+	jobGroup             string
+	dsn                  string
+	credentials          config.Credentials
+	auth                 *config.AuthConfig
+	tls                  *config.TLSConfig
+	proxyURL             string
+	sshTunnel            *config.SSHTunnelConfig
+	connection           config.ConnectionConfig // connection settings overriding the global ones
+	maxConcurrentQueries int                     // queries running at once on the target, 0 if unlimited
+	refs                 config.SecretRefs       // secret references the DSN and credentials were resolved from, if any
+	collectors           []*config.CollectorConfig
+	constLabels          prometheus.Labels
+	enablePing           *bool
+}
+
+// newTarget returns a new Target created from the spec.
+func (s *targetSpec) newTarget(gc *config.GlobalConfig) (Target, errors.WithContext) {
+	d, derr := newDialer(s.proxyURL, s.sshTunnel)
+	if derr != nil {
+		return nil, errors.Wrap(s.logContext, derr)
+	}
+	t, err := NewTarget(s.logContext, s.name, s.jobGroup, s.dsn, s.collectors, s.constLabels, s.connection.Apply(gc),
+		s.enablePing)
+	if err != nil {
+		return nil, err
+	}
+	t.(*target).credentials = s.credentials
+	t.(*target).auth = s.auth
+	t.(*target).tls = s.tls
+	t.(*target).proxyURL = s.proxyURL
+	t.(*target).sshTunnel = s.sshTunnel
+	t.(*target).maxConcurrentQueries = s.maxConcurrentQueries
+	t.(*target).queries = newQueryLimiter(s.maxConcurrentQueries, gc.MaxConcurrentQueries)
+	t.(*target).dialer = d
+	t.(*target).refs = s.refs
+	// Scheduled collectors start once the target is fully set up. Probe modules can't have any.
+	t.(*target).startScheduler()
+	return t, nil
+}
 
+// jobTargetSpecs returns the specs of the statically defined targets of a job.
+func jobTargetSpecs(jc *config.JobConfig) ([]targetSpec, errors.WithContext) {
+	logContext := fmt.Sprintf(`job=%s`, jc.Name)
 	if jc.EnablePing == nil {
 		jc.EnablePing = &config.EnablePing
 	}
 
+	specs := make([]targetSpec, 0, 10)
 	for _, sc := range jc.StaticConfigs {
 		for tname, dsn := range sc.Targets {
 			constLabels := prometheus.Labels{
@@ -41,20 +105,30 @@ func NewJob(jc *config.JobConfig, gc *config.GlobalConfig) (Job, errors.WithCont
 			for name, value := range sc.Labels {
 				// Shouldn't happen as there are sanity checks in config, but check nonetheless.
 				if _, found := constLabels[name]; found {
-					return nil, errors.Errorf(j.logContext, "duplicate label %q", name)
+					return nil, errors.Errorf(logContext, "duplicate label %q", name)
 				}
 				constLabels[name] = value
 			}
-			t, err := NewTarget(j.logContext, tname, jc.Name, string(dsn), jc.Collectors(),
-				constLabels, gc, jc.EnablePing)
-			if err != nil {
-				return nil, err
-			}
-			j.targets = append(j.targets, t)
+			specs = append(specs, targetSpec{
+				logContext:           logContext,
+				name:                 tname,
+				jobGroup:             jc.Name,
+				dsn:                  string(dsn),
+				credentials:          sc.Credentials(tname),
+				auth:                 sc.Auth(tname),
+				tls:                  cmp.Or(sc.TLS(tname), jc.TLS),
+				proxyURL:             sc.ProxyURL(tname),
+				sshTunnel:            sc.SSHTunnel(tname),
+				connection:           sc.ConnectionConfig,
+				maxConcurrentQueries: jc.MaxConcurrentQueries,
+				refs:                 sc.SecretRefs(tname),
+				collectors:           jc.Collectors(),
+				constLabels:          constLabels,
+				enablePing:           jc.EnablePing,
+			})
 		}
 	}
-
-	return &j, nil
+	return specs, nil
 }
 
 func (j *job) Targets() []Target {
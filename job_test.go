@@ -0,0 +1,43 @@
+package sql_exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+func TestTargetSpec_Connection(t *testing.T) {
+	gc := &config.GlobalConfig{MaxConns: 3, MaxIdleConns: 3, ScrapeTimeout: model.Duration(10 * time.Second)}
+	maxConns, scrapeTimeout := 20, model.Duration(time.Minute)
+	spec := targetSpec{
+		name:        "dwh",
+		dsn:         "postgres://dwh/app",
+		constLabels: prometheus.Labels{config.TargetLabel: "dwh"},
+		connection:  config.ConnectionConfig{MaxConns: &maxConns, ScrapeTimeout: &scrapeTimeout},
+	}
+
+	tt, err := spec.newTarget(gc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := tt.(*target)
+	defer target.Close()
+	if target.globalConfig.MaxConns != 20 || target.globalConfig.MaxIdleConns != 3 ||
+		target.globalConfig.ScrapeTimeout != scrapeTimeout {
+		t.Errorf("unexpected target connection settings: %+v", target.globalConfig)
+	}
+	if gc.MaxConns != 3 {
+		t.Errorf("expected the globals to be left alone, got %+v", gc)
+	}
+
+	if !target.matches(&spec, gc) {
+		t.Error("expected the target to match its spec")
+	}
+	maxConns = 10
+	if target.matches(&spec, gc) {
+		t.Error("expected the target not to match a spec with other connection settings")
+	}
+}
@@ -0,0 +1,217 @@
+package sql_exporter
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/semaphore"
+)
+
+var (
+	queryQueueWaitMetric *prometheus.HistogramVec
+
+	// The semaphore limiting queries across all targets to global.max_concurrent_queries, nil if unlimited.
+	globalQuerySemMu sync.Mutex
+	globalQuerySem   *querySemaphore
+)
+
+// querySemaphore is a weighted semaphore limiting the number of queries running at once, each query weighing 1. Queries
+// waiting for a slot are admitted by priority, then in arrival order.
+type querySemaphore struct {
+	limit int
+	sem   *semaphore.Weighted
+
+	mu      sync.Mutex
+	waiters waiterQueue
+	seq     uint64
+}
+
+// newQuerySemaphore returns a semaphore admitting up to limit queries at once, or nil if limit is 0 (unlimited).
+func newQuerySemaphore(limit int) *querySemaphore {
+	if limit <= 0 {
+		return nil
+	}
+	return &querySemaphore{limit: limit, sem: semaphore.NewWeighted(int64(limit))}
+}
+
+// sharedQuerySemaphore returns the semaphore limiting queries across all targets, replacing it if the limit changed.
+// Targets keep the semaphore they were created with, they are all replaced when the global config changes.
+func sharedQuerySemaphore(limit int) *querySemaphore {
+	globalQuerySemMu.Lock()
+	defer globalQuerySemMu.Unlock()
+	if globalQuerySem == nil && limit <= 0 || globalQuerySem != nil && globalQuerySem.limit == limit {
+		return globalQuerySem
+	}
+	globalQuerySem = newQuerySemaphore(limit)
+	return globalQuerySem
+}
+
+// queryWaiter is a query waiting for a slot. ready is closed once it got one.
+type queryWaiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	index    int
+}
+
+// waiterQueue is a heap of waiting queries, highest priority and earliest arrival first.
+type waiterQueue []*queryWaiter
+
+func (q waiterQueue) Len() int { return len(q) }
+func (q waiterQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q waiterQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+func (q *waiterQueue) Push(x any) {
+	w := x.(*queryWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+func (q *waiterQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
+
+// acquire waits for a slot, or until the context is closed.
+func (s *querySemaphore) acquire(ctx context.Context, priority int) error {
+	s.mu.Lock()
+	if s.waiters.Len() == 0 && s.sem.TryAcquire(1) {
+		s.mu.Unlock()
+		return nil
+	}
+	w := &queryWaiter{priority: priority, seq: s.seq, ready: make(chan struct{})}
+	s.seq++
+	heap.Push(&s.waiters, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		granted := w.index < 0
+		if !granted {
+			heap.Remove(&s.waiters, w.index)
+		}
+		s.mu.Unlock()
+		if granted {
+			// Got the slot while giving up, hand it over to the next query.
+			s.release()
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees a slot, admitting the next waiting query if any.
+func (s *querySemaphore) release() {
+	s.sem.Release(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.waiters.Len() > 0 && s.sem.TryAcquire(1) {
+		close(heap.Pop(&s.waiters).(*queryWaiter).ready)
+	}
+}
+
+// queryLimiter limits the queries of a target to the target's and the global max_concurrent_queries, if set.
+type queryLimiter struct {
+	target *querySemaphore
+	global *querySemaphore
+}
+
+// newQueryLimiter returns the limiter of a target with the provided limit, sharing the global semaphore.
+func newQueryLimiter(limit, globalLimit int) queryLimiter {
+	return queryLimiter{target: newQuerySemaphore(limit), global: sharedQuerySemaphore(globalLimit)}
+}
+
+// acquire waits for a slot of the target and then a global one, so that waiting on a busy target doesn't hold a global
+// slot. The returned function releases both.
+func (l queryLimiter) acquire(ctx context.Context, priority int) (func(), error) {
+	var acquired []*querySemaphore
+	release := func() {
+		for _, s := range slices.Backward(acquired) {
+			s.release()
+		}
+	}
+	for _, s := range []*querySemaphore{l.target, l.global} {
+		if s == nil {
+			continue
+		}
+		if err := s.acquire(ctx, priority); err != nil {
+			release()
+			return nil, err
+		}
+		acquired = append(acquired, s)
+	}
+	return release, nil
+}
+
+// limited returns whether any limit applies.
+func (l queryLimiter) limited() bool {
+	return l.target != nil || l.global != nil
+}
+
+// queryLimiterKey is the context key of the query limiter of the target being collected.
+type queryLimiterKey struct{}
+
+// withQueryLimiter returns a context limiting the queries run with it.
+func withQueryLimiter(ctx context.Context, l queryLimiter) context.Context {
+	if !l.limited() {
+		return ctx
+	}
+	return context.WithValue(ctx, queryLimiterKey{}, l)
+}
+
+// acquireQuerySlot waits for the query to be allowed to run by the context's limiter, if any, and records how long it
+// waited. The returned function must be called once the query is done.
+func acquireQuerySlot(ctx context.Context, q *Query, priority int) (func(), error) {
+	l, ok := ctx.Value(queryLimiterKey{}).(queryLimiter)
+	if !ok {
+		return func() {}, nil
+	}
+	start := time.Now()
+	release, err := l.acquire(ctx, priority)
+	if queryQueueWaitMetric != nil {
+		ctxLabels := parseContextLog(q.logContext)
+		values := make([]string, len(svcMetricLabels))
+		for i, label := range svcMetricLabels {
+			values[i] = ctxLabels[label]
+		}
+		queryQueueWaitMetric.WithLabelValues(values...).Observe(time.Since(start).Seconds())
+	}
+	return release, err
+}
+
+// registerQueryQueueWaitMetric registers the histogram of the time queries waited for max_concurrent_queries.
+func registerQueryQueueWaitMetric(registry prometheus.Registerer) (*prometheus.HistogramVec, error) {
+	queueWait := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "query_queue_wait_seconds",
+		Help:    "Time queries waited to run because of max_concurrent_queries, per job, target, collector and query",
+		Buckets: []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, svcMetricLabels)
+
+	if err := registry.Register(queueWait); err != nil {
+		var alreadyRegisteredErr prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegisteredErr) {
+			slog.Debug("query_queue_wait_seconds metric already registered, using existing metric")
+			return alreadyRegisteredErr.ExistingCollector.(*prometheus.HistogramVec), nil
+		}
+		return nil, err
+	}
+	return queueWait, nil
+}
@@ -0,0 +1,112 @@
+package sql_exporter
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForWaiters waits until n queries are waiting on the semaphore.
+func waitForWaiters(t *testing.T, s *querySemaphore, n int) {
+	t.Helper()
+	for range 100 {
+		s.mu.Lock()
+		waiting := s.waiters.Len()
+		s.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d waiting queries", n)
+}
+
+func TestQuerySemaphore_Priority(t *testing.T) {
+	s := newQuerySemaphore(1)
+	if err := s.acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i, priority := range []int{0, 10, 5, 10} {
+		wg.Go(func() {
+			if err := s.acquire(context.Background(), priority); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+			s.release()
+		})
+		waitForWaiters(t, s, i+1)
+	}
+
+	s.release()
+	wg.Wait()
+	if want := []int{10, 10, 5, 0}; !slices.Equal(order, want) {
+		t.Errorf("expected the queries to run in order %v, got %v", want, order)
+	}
+}
+
+func TestQuerySemaphore_Cancel(t *testing.T) {
+	s := newQuerySemaphore(1)
+	if err := s.acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.acquire(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to time out, got %v", err)
+	}
+	waitForWaiters(t, s, 0)
+
+	// The slot is still handed over to the next query.
+	s.release()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.acquire(ctx, 0); err != nil {
+		t.Fatalf("expected a free slot, got %v", err)
+	}
+}
+
+func TestQueryLimiter(t *testing.T) {
+	if l := newQueryLimiter(0, 0); l.limited() {
+		t.Error("expected no limit")
+	}
+
+	l := newQueryLimiter(1, 2)
+	defer sharedQuerySemaphore(0)
+	if other := newQueryLimiter(1, 2); other.global != l.global || other.target == l.target {
+		t.Error("expected targets to share the global semaphore only")
+	}
+
+	release, err := l.acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The target's limit is reached, without holding a second global slot.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, 0); err == nil {
+		t.Fatal("expected the target's limit to apply")
+	}
+	if !l.global.sem.TryAcquire(1) {
+		t.Fatal("expected a free global slot")
+	}
+	l.global.release()
+
+	release()
+	if release, err = l.acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -3,13 +3,19 @@ package sql_exporter
 import (
 	"database/sql"
 	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/burningalchemist/sql_exporter/config"
 	"github.com/burningalchemist/sql_exporter/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // MetricDesc is a descriptor for a family of metrics, sharing the same name, help, labels, type.
@@ -38,7 +44,8 @@ type MetricFamily struct {
 func NewMetricFamily(logContext string, mc *config.MetricConfig, constLabels []*dto.LabelPair) (*MetricFamily, errors.WithContext) {
 	logContext = TrimMissingCtx(fmt.Sprintf(`%s,metric=%s`, logContext, mc.Name))
 
-	if len(mc.Values) == 0 && mc.StaticValue == nil {
+	valueless := mc.MetricType() == config.MetricTypeInfo || mc.MetricType() == config.MetricTypeStateset
+	if len(mc.Values) == 0 && mc.StaticValue == nil && !valueless {
 		return nil, errors.New(logContext, "no value column defined")
 	}
 	if len(mc.Values) > 1 && mc.ValueLabel == "" {
@@ -53,6 +60,10 @@ func NewMetricFamily(logContext string, mc *config.MetricConfig, constLabels []*
 	if mc.ValueLabel != "" {
 		labels = append(labels, mc.ValueLabel)
 	}
+	// Stateset metrics are labeled with the metric name itself, as per OpenMetrics.
+	if mc.MetricType() == config.MetricTypeStateset {
+		labels = append(labels, mc.Name)
+	}
 
 	// Create a copy of original slice to avoid modifying constLabels
 	sortedLabels := append(constLabels[:0:0], constLabels...)
@@ -79,21 +90,36 @@ func (mf MetricFamily) Collect(row map[string]any, ch chan<- Metric) {
 	for i, label := range mf.config.KeyLabels {
 		labelValues[i] = row[label].(sql.NullString).String
 	}
+
+	switch mf.config.MetricType() {
+	case config.MetricTypeInfo:
+		// Info metrics may have labels beyond key_labels, populated from the extra columns returned by the query.
+		for i := len(mf.config.KeyLabels); i < len(mf.labels); i++ {
+			labelValues[i] = row[mf.labels[i]].(sql.NullString).String
+		}
+		mf.send(row, NewMetric(&mf, 1, labelValues...), ch)
+		return
+	case config.MetricTypeStateset:
+		current := row[mf.config.StateValue].(sql.NullString)
+		if current.Valid && !slices.Contains(mf.config.States, current.String) {
+			slog.Debug("Unknown state, exporting all states as 0", "logContext", mf.logContext, "state",
+				current.String)
+		}
+		for _, state := range mf.config.States {
+			labelValues[len(labelValues)-1] = state
+			value := boolToFloat64(current.Valid && current.String == state)
+			mf.send(row, NewMetric(&mf, value, labelValues...), ch)
+		}
+		return
+	}
+
 	for _, v := range mf.config.Values {
 		if mf.config.ValueLabel != "" {
 			labelValues[len(labelValues)-1] = v
 		}
 		value := row[v].(sql.NullFloat64)
 		if value.Valid {
-			metric := NewMetric(&mf, value.Float64, labelValues...)
-			if mf.config.TimestampValue == "" {
-				ch <- metric
-			} else {
-				ts := row[mf.config.TimestampValue].(sql.NullTime)
-				if ts.Valid {
-					ch <- NewMetricWithTimestamp(ts.Time, metric)
-				}
-			}
+			mf.send(row, NewMetric(&mf, value.Float64, labelValues...), ch)
 		}
 	}
 	if mf.config.StaticValue != nil {
@@ -102,6 +128,59 @@ func (mf MetricFamily) Collect(row map[string]any, ch chan<- Metric) {
 	}
 }
 
+// send sends the metric to the channel, with the timestamp, created timestamp and exemplar from the row if the metric
+// family defines the respective columns. Metrics with a NULL timestamp are dropped.
+func (mf MetricFamily) send(row map[string]any, metric Metric, ch chan<- Metric) {
+	if mf.config.CreatedTimestampValue != "" {
+		if ct := row[mf.config.CreatedTimestampValue].(sql.NullTime); ct.Valid {
+			metric = NewMetricWithCreatedTimestamp(ct.Time, metric)
+		}
+	}
+	if len(mf.config.ExemplarLabels) > 0 {
+		exemplar := &dto.Exemplar{Label: make([]*dto.LabelPair, 0, len(mf.config.ExemplarLabels))}
+		for _, label := range mf.config.ExemplarLabels {
+			exemplar.Label = append(exemplar.Label, &dto.LabelPair{
+				Name:  new(label),
+				Value: new(row[label].(sql.NullString).String),
+			})
+		}
+		if mf.config.ExemplarValue != "" {
+			if v := row[mf.config.ExemplarValue].(sql.NullFloat64); v.Valid {
+				exemplar.Value = new(v.Float64)
+			}
+		}
+		metric = NewMetricWithExemplar(exemplar, metric)
+	}
+
+	if mf.config.TimestampValue == "" {
+		ch <- metric
+		return
+	}
+	ts := row[mf.config.TimestampValue].(sql.NullTime)
+	if ts.Valid {
+		ch <- NewMetricWithTimestamp(ts.Time, metric)
+	}
+}
+
+// withLabels returns a copy of the metric family with the given labels appended, populated from the row columns of the
+// same name.
+func (mf *MetricFamily) withLabels(labels []string) *MetricFamily {
+	extended := *mf
+	extended.labels = append(slices.Clip(mf.labels), labels...)
+	return &extended
+}
+
+// newAccumulator returns a metricAccumulator for metric types that are populated from multiple rows (histograms and
+// summaries), or nil for metric types populated from a single row.
+func (mf *MetricFamily) newAccumulator() *metricAccumulator {
+	switch mf.config.MetricType() {
+	case config.MetricTypeHistogram, config.MetricTypeSummary:
+		return &metricAccumulator{mf: mf, series: make(map[string]*accumulatedSeries)}
+	default:
+		return nil
+	}
+}
+
 // Name implements MetricDesc.
 func (mf MetricFamily) Name() string {
 	return mf.config.Name
@@ -132,6 +211,86 @@ func (mf MetricFamily) LogContext() string {
 	return mf.logContext
 }
 
+//
+// metricAccumulator
+//
+
+// metricAccumulator gathers histogram buckets or summary quantiles spread across multiple result rows of a single
+// query run, grouped into series by label values. Not safe for concurrent use, a new one is created for every run.
+type metricAccumulator struct {
+	mf     *MetricFamily
+	series map[string]*accumulatedSeries
+	// Series keys in the order they were first seen, for deterministic output.
+	keys []string
+}
+
+// accumulatedSeries holds the state of a single histogram or summary series.
+type accumulatedSeries struct {
+	labelValues []string
+	count       uint64
+	sum         float64
+	// Upper bound to cumulative count for histograms, quantile to value for summaries.
+	points map[float64]float64
+}
+
+// Add adds a single row to the accumulator. Rows with a NULL bucket bound/quantile or value are skipped.
+func (a *metricAccumulator) Add(row map[string]any) {
+	cfg := a.mf.config
+	labelValues := make([]string, len(a.mf.labels))
+	for i, label := range cfg.KeyLabels {
+		labelValues[i] = row[label].(sql.NullString).String
+	}
+	if cfg.ValueLabel != "" {
+		labelValues[len(labelValues)-1] = cfg.Values[0]
+	}
+
+	pointColumn := cfg.QuantileValue
+	if cfg.MetricType() == config.MetricTypeHistogram {
+		pointColumn = cfg.LeValue
+	}
+	point := row[pointColumn].(sql.NullFloat64)
+	value := row[cfg.Values[0]].(sql.NullFloat64)
+	if !point.Valid || !value.Valid {
+		slog.Debug("Skipping row with NULL bucket or quantile", "logContext", a.mf.logContext)
+		return
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, found := a.series[key]
+	if !found {
+		s = &accumulatedSeries{labelValues: labelValues, points: make(map[float64]float64)}
+		a.series[key] = s
+		a.keys = append(a.keys, key)
+	}
+	s.points[point.Float64] = value.Float64
+	if sum := row[cfg.SumValue].(sql.NullFloat64); sum.Valid {
+		s.sum = sum.Float64
+	}
+	if count := row[cfg.CountValue].(sql.NullFloat64); count.Valid {
+		s.count = uint64(count.Float64)
+	}
+}
+
+// Collect sends one metric per accumulated series to the channel.
+func (a *metricAccumulator) Collect(ch chan<- Metric) {
+	for _, key := range a.keys {
+		s := a.series[key]
+		if a.mf.config.MetricType() == config.MetricTypeHistogram {
+			buckets := make(map[float64]uint64, len(s.points))
+			for le, count := range s.points {
+				// The +Inf bucket is implied by the total count.
+				if math.IsInf(le, +1) {
+					continue
+				}
+				buckets[le] = uint64(count)
+			}
+			ch <- NewHistogram(a.mf, s.count, s.sum, buckets, s.labelValues...)
+		} else {
+			ch <- NewSummary(a.mf, s.count, s.sum, s.points, s.labelValues...)
+		}
+	}
+}
+
 //
 // automaticMetricDesc
 //
@@ -234,12 +393,112 @@ func (m *constMetric) Write(out *dto.Metric) errors.WithContext {
 		out.Counter = &dto.Counter{Value: new(m.val)}
 	case prometheus.GaugeValue:
 		out.Gauge = &dto.Gauge{Value: new(m.val)}
+	case prometheus.UntypedValue:
+		out.Untyped = &dto.Untyped{Value: new(m.val)}
 	default:
 		return errors.Errorf(m.desc.LogContext(), "encountered unknown type %v", t)
 	}
 	return nil
 }
 
+// NewHistogram returns a histogram metric with fixed buckets (upper bound to cumulative count), sum and count.
+//
+// NewHistogram panics if the length of labelValues is not consistent with desc.labels().
+func NewHistogram(desc MetricDesc, count uint64, sum float64, buckets map[float64]uint64, labelValues ...string) Metric {
+	if len(desc.Labels()) != len(labelValues) {
+		panic(fmt.Sprintf("[%s] expected %d labels, got %d", desc.LogContext(), len(desc.Labels()), len(labelValues)))
+	}
+	return &constHistogram{
+		desc:       desc,
+		count:      count,
+		sum:        sum,
+		buckets:    buckets,
+		labelPairs: makeLabelPairs(desc, labelValues),
+	}
+}
+
+// constHistogram is a histogram metric with fixed buckets, sum and count.
+type constHistogram struct {
+	desc       MetricDesc
+	count      uint64
+	sum        float64
+	buckets    map[float64]uint64
+	labelPairs []*dto.LabelPair
+}
+
+// Desc implements Metric.
+func (m *constHistogram) Desc() MetricDesc {
+	return m.desc
+}
+
+// Write implements Metric.
+func (m *constHistogram) Write(out *dto.Metric) errors.WithContext {
+	bounds := slices.Sorted(maps.Keys(m.buckets))
+	buckets := make([]*dto.Bucket, 0, len(bounds))
+	for _, le := range bounds {
+		buckets = append(buckets, &dto.Bucket{
+			UpperBound:      new(le),
+			CumulativeCount: new(m.buckets[le]),
+		})
+	}
+	out.Label = m.labelPairs
+	out.Histogram = &dto.Histogram{
+		SampleCount: new(m.count),
+		SampleSum:   new(m.sum),
+		Bucket:      buckets,
+	}
+	return nil
+}
+
+// NewSummary returns a summary metric with fixed quantiles (quantile to value), sum and count.
+//
+// NewSummary panics if the length of labelValues is not consistent with desc.labels().
+func NewSummary(desc MetricDesc, count uint64, sum float64, quantiles map[float64]float64, labelValues ...string) Metric {
+	if len(desc.Labels()) != len(labelValues) {
+		panic(fmt.Sprintf("[%s] expected %d labels, got %d", desc.LogContext(), len(desc.Labels()), len(labelValues)))
+	}
+	return &constSummary{
+		desc:       desc,
+		count:      count,
+		sum:        sum,
+		quantiles:  quantiles,
+		labelPairs: makeLabelPairs(desc, labelValues),
+	}
+}
+
+// constSummary is a summary metric with fixed quantiles, sum and count.
+type constSummary struct {
+	desc       MetricDesc
+	count      uint64
+	sum        float64
+	quantiles  map[float64]float64
+	labelPairs []*dto.LabelPair
+}
+
+// Desc implements Metric.
+func (m *constSummary) Desc() MetricDesc {
+	return m.desc
+}
+
+// Write implements Metric.
+func (m *constSummary) Write(out *dto.Metric) errors.WithContext {
+	qs := slices.Sorted(maps.Keys(m.quantiles))
+	quantiles := make([]*dto.Quantile, 0, len(qs))
+	for _, q := range qs {
+		quantiles = append(quantiles, &dto.Quantile{
+			Quantile: new(q),
+			Value:    new(m.quantiles[q]),
+		})
+	}
+	out.Label = m.labelPairs
+	out.Summary = &dto.Summary{
+		SampleCount: new(m.count),
+		SampleSum:   new(m.sum),
+		Quantile:    quantiles,
+	}
+	return nil
+}
+
 func makeLabelPairs(desc MetricDesc, labelValues []string) []*dto.LabelPair {
 	labels := desc.Labels()
 	constLabels := desc.ConstLabels()
@@ -309,3 +568,46 @@ func (m timestampedMetric) Write(pb *dto.Metric) errors.WithContext {
 func NewMetricWithTimestamp(t time.Time, m Metric) Metric {
 	return timestampedMetric{Metric: m, t: t}
 }
+
+type createdTimestampMetric struct {
+	Metric
+	t time.Time
+}
+
+func (m createdTimestampMetric) Write(pb *dto.Metric) errors.WithContext {
+	e := m.Metric.Write(pb)
+	if pb.Counter != nil {
+		pb.Counter.CreatedTimestamp = timestamppb.New(m.t)
+	}
+	return e
+}
+
+// NewMetricWithCreatedTimestamp returns a counter metric that also carries its creation time, exposed as the
+// `_created` series in the OpenMetrics format. It has no effect on other metric types.
+func NewMetricWithCreatedTimestamp(t time.Time, m Metric) Metric {
+	return createdTimestampMetric{Metric: m, t: t}
+}
+
+type exemplarMetric struct {
+	Metric
+	exemplar *dto.Exemplar
+}
+
+func (m exemplarMetric) Write(pb *dto.Metric) errors.WithContext {
+	e := m.Metric.Write(pb)
+	if pb.Counter != nil {
+		exemplar := &dto.Exemplar{Label: m.exemplar.Label, Value: m.exemplar.Value}
+		// Default to the sample value if the exemplar has none of its own.
+		if exemplar.Value == nil {
+			exemplar.Value = new(pb.Counter.GetValue())
+		}
+		pb.Counter.Exemplar = exemplar
+	}
+	return e
+}
+
+// NewMetricWithExemplar returns a counter metric with the provided exemplar attached, exposed in the OpenMetrics
+// format only. It has no effect on other metric types.
+func NewMetricWithExemplar(exemplar *dto.Exemplar, m Metric) Metric {
+	return exemplarMetric{Metric: m, exemplar: exemplar}
+}
@@ -0,0 +1,252 @@
+package sql_exporter
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	dto "github.com/prometheus/client_model/go"
+	"go.yaml.in/yaml/v3"
+)
+
+// metricConfigFromYAML parses a metric definition the same way config.Load does, populating unexported fields.
+func metricConfigFromYAML(t *testing.T, data string) *config.MetricConfig {
+	t.Helper()
+	mc := &config.MetricConfig{}
+	if err := yaml.Unmarshal([]byte(data), mc); err != nil {
+		t.Fatalf("metric config: %v", err)
+	}
+	return mc
+}
+
+func histogramRow(endpoint string, le, bucket, sum, count float64) map[string]any {
+	return map[string]any{
+		"endpoint": sql.NullString{String: endpoint, Valid: true},
+		"le":       sql.NullFloat64{Float64: le, Valid: true},
+		"bucket":   sql.NullFloat64{Float64: bucket, Valid: true},
+		"sum":      sql.NullFloat64{Float64: sum, Valid: true},
+		"count":    sql.NullFloat64{Float64: count, Valid: true},
+	}
+}
+
+func collectAccumulated(t *testing.T, acc *metricAccumulator) []*dto.Metric {
+	t.Helper()
+	ch := make(chan Metric, capMetricChan)
+	acc.Collect(ch)
+	close(ch)
+	var out []*dto.Metric
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		out = append(out, pb)
+	}
+	return out
+}
+
+func TestHistogramAccumulator(t *testing.T) {
+	mc := metricConfigFromYAML(t, `
+metric_name: latency_seconds
+type: histogram
+help: Latency
+key_labels: [endpoint]
+values: [bucket]
+le_value: le
+sum_value: sum
+count_value: count
+query: SELECT 1`)
+	mf, err := NewMetricFamily("", mc, nil)
+	if err != nil {
+		t.Fatalf("NewMetricFamily: %v", err)
+	}
+
+	acc := mf.newAccumulator()
+	if acc == nil {
+		t.Fatal("expected an accumulator for a histogram metric")
+	}
+	acc.Add(histogramRow("/a", 0.5, 3, 4.2, 10))
+	acc.Add(histogramRow("/a", 0.1, 1, 4.2, 10))
+	acc.Add(histogramRow("/a", math.Inf(+1), 10, 4.2, 10))
+	acc.Add(histogramRow("/b", 0.1, 7, 1, 7))
+
+	metrics := collectAccumulated(t, acc)
+	if len(metrics) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(metrics))
+	}
+
+	h := metrics[0].GetHistogram()
+	if h == nil {
+		t.Fatal("expected a histogram")
+	}
+	if metrics[0].GetLabel()[0].GetValue() != "/a" {
+		t.Errorf("expected first series to be /a, got %q", metrics[0].GetLabel()[0].GetValue())
+	}
+	if h.GetSampleCount() != 10 || h.GetSampleSum() != 4.2 {
+		t.Errorf("count/sum = %d/%v, want 10/4.2", h.GetSampleCount(), h.GetSampleSum())
+	}
+	if len(h.GetBucket()) != 2 {
+		t.Fatalf("expected 2 buckets (+Inf implied), got %d", len(h.GetBucket()))
+	}
+	if b := h.GetBucket()[0]; b.GetUpperBound() != 0.1 || b.GetCumulativeCount() != 1 {
+		t.Errorf("first bucket = %v/%d, want 0.1/1", b.GetUpperBound(), b.GetCumulativeCount())
+	}
+	if b := h.GetBucket()[1]; b.GetUpperBound() != 0.5 || b.GetCumulativeCount() != 3 {
+		t.Errorf("second bucket = %v/%d, want 0.5/3", b.GetUpperBound(), b.GetCumulativeCount())
+	}
+}
+
+func TestSummaryAccumulator(t *testing.T) {
+	mc := metricConfigFromYAML(t, `
+metric_name: latency_seconds
+type: summary
+help: Latency
+values: [value]
+quantile_value: quantile
+sum_value: sum
+count_value: count
+query: SELECT 1`)
+	mf, err := NewMetricFamily("", mc, nil)
+	if err != nil {
+		t.Fatalf("NewMetricFamily: %v", err)
+	}
+
+	acc := mf.newAccumulator()
+	for _, r := range [][2]float64{{0.99, 1.5}, {0.5, 0.2}} {
+		acc.Add(map[string]any{
+			"quantile": sql.NullFloat64{Float64: r[0], Valid: true},
+			"value":    sql.NullFloat64{Float64: r[1], Valid: true},
+			"sum":      sql.NullFloat64{Float64: 12, Valid: true},
+			"count":    sql.NullFloat64{Float64: 40, Valid: true},
+		})
+	}
+	// NULL quantile values are skipped.
+	acc.Add(map[string]any{
+		"quantile": sql.NullFloat64{Float64: 0.9, Valid: true},
+		"value":    sql.NullFloat64{},
+		"sum":      sql.NullFloat64{Float64: 12, Valid: true},
+		"count":    sql.NullFloat64{Float64: 40, Valid: true},
+	})
+
+	metrics := collectAccumulated(t, acc)
+	if len(metrics) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(metrics))
+	}
+	s := metrics[0].GetSummary()
+	if s.GetSampleCount() != 40 || s.GetSampleSum() != 12 {
+		t.Errorf("count/sum = %d/%v, want 40/12", s.GetSampleCount(), s.GetSampleSum())
+	}
+	if len(s.GetQuantile()) != 2 || s.GetQuantile()[0].GetQuantile() != 0.5 || s.GetQuantile()[1].GetValue() != 1.5 {
+		t.Errorf("unexpected quantiles: %v", s.GetQuantile())
+	}
+}
+
+func TestScalarMetricHasNoAccumulator(t *testing.T) {
+	mc := metricConfigFromYAML(t, "{metric_name: g, type: gauge, help: g, values: [v], query: SELECT 1}")
+	mf, err := NewMetricFamily("", mc, nil)
+	if err != nil {
+		t.Fatalf("NewMetricFamily: %v", err)
+	}
+	if mf.newAccumulator() != nil {
+		t.Error("expected no accumulator for a gauge metric")
+	}
+}
+
+// collectRow runs MetricFamily.Collect on a single row and returns the written metrics.
+func collectRow(t *testing.T, mf *MetricFamily, row map[string]any) []*dto.Metric {
+	t.Helper()
+	ch := make(chan Metric, capMetricChan)
+	mf.Collect(row, ch)
+	close(ch)
+	var out []*dto.Metric
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		out = append(out, pb)
+	}
+	return out
+}
+
+func TestInfoMetric(t *testing.T) {
+	mc := metricConfigFromYAML(t, "{metric_name: db_version_info, type: info, help: v, key_labels: [version], query: q}")
+	mf, err := NewMetricFamily("", mc, nil)
+	if err != nil {
+		t.Fatalf("NewMetricFamily: %v", err)
+	}
+
+	metrics := collectRow(t, mf, map[string]any{"version": sql.NullString{String: "16.2", Valid: true}})
+	if len(metrics) != 1 {
+		t.Fatalf("expected 1 metric, got %d", len(metrics))
+	}
+	if metrics[0].GetGauge().GetValue() != 1 {
+		t.Errorf("info value = %v, want 1", metrics[0].GetGauge().GetValue())
+	}
+	if l := metrics[0].GetLabel()[0]; l.GetName() != "version" || l.GetValue() != "16.2" {
+		t.Errorf("unexpected label %s=%s", l.GetName(), l.GetValue())
+	}
+}
+
+func TestStatesetMetric(t *testing.T) {
+	mc := metricConfigFromYAML(t, `{metric_name: replica_role, type: stateset, help: r, key_labels: [db],
+		state_value: role, states: [primary, secondary], query: q}`)
+	mf, err := NewMetricFamily("", mc, nil)
+	if err != nil {
+		t.Fatalf("NewMetricFamily: %v", err)
+	}
+
+	metrics := collectRow(t, mf, map[string]any{
+		"db":   sql.NullString{String: "db1", Valid: true},
+		"role": sql.NullString{String: "secondary", Valid: true},
+	})
+	if len(metrics) != 2 {
+		t.Fatalf("expected one metric per state, got %d", len(metrics))
+	}
+	got := make(map[string]float64, len(metrics))
+	for _, m := range metrics {
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "replica_role" {
+				got[l.GetValue()] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	if got["primary"] != 0 || got["secondary"] != 1 {
+		t.Errorf("unexpected stateset values: %v", got)
+	}
+}
+
+func TestCounterCreatedTimestampAndExemplar(t *testing.T) {
+	mc := metricConfigFromYAML(t, `{metric_name: queries_total, type: counter, help: q, values: [total],
+		created_timestamp_value: started_at, exemplar_labels: [query_id], query: q}`)
+	mf, err := NewMetricFamily("", mc, nil)
+	if err != nil {
+		t.Fatalf("NewMetricFamily: %v", err)
+	}
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	metrics := collectRow(t, mf, map[string]any{
+		"total":      sql.NullFloat64{Float64: 42, Valid: true},
+		"started_at": sql.NullTime{Time: created, Valid: true},
+		"query_id":   sql.NullString{String: "abc", Valid: true},
+	})
+	if len(metrics) != 1 {
+		t.Fatalf("expected 1 metric, got %d", len(metrics))
+	}
+	c := metrics[0].GetCounter()
+	if !c.GetCreatedTimestamp().AsTime().Equal(created) {
+		t.Errorf("created timestamp = %v, want %v", c.GetCreatedTimestamp().AsTime(), created)
+	}
+	ex := c.GetExemplar()
+	if ex == nil {
+		t.Fatal("expected an exemplar")
+	}
+	if ex.GetValue() != 42 {
+		t.Errorf("exemplar value = %v, want the sample value 42", ex.GetValue())
+	}
+	if len(ex.GetLabel()) != 1 || ex.GetLabel()[0].GetName() != "query_id" || ex.GetLabel()[0].GetValue() != "abc" {
+		t.Errorf("unexpected exemplar labels: %v", ex.GetLabel())
+	}
+}
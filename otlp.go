@@ -0,0 +1,441 @@
+package sql_exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	promcfg "github.com/prometheus/common/config"
+	"github.com/prometheus/common/version"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Resource attributes the job and target labels are mapped to, as per the OpenTelemetry Prometheus compatibility
+	// specification.
+	serviceNameAttr       = "service.name"
+	serviceInstanceIDAttr = "service.instance.id"
+	// Default service name for metrics without a job label, i.e. in single target mode.
+	defaultServiceName = "sql_exporter"
+)
+
+// OTLPExporter periodically gathers metrics from an Exporter and sends them to an OpenTelemetry collector over
+// OTLP/HTTP or OTLP/gRPC. Failed exports are not retried, the next export carries the current values anyway.
+type OTLPExporter struct {
+	config    *config.OTLPConfig
+	exporter  Exporter
+	gatherer  prometheus.Gatherer
+	client    otlpClient
+	startTime time.Time
+
+	failedExports prometheus.Counter
+}
+
+// otlpClient sends a single export request to the collector.
+type otlpClient interface {
+	export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (
+		*colmetricspb.ExportMetricsServiceResponse, error)
+}
+
+// NewOTLPExporter returns a new OTLPExporter for the provided config. The exporter's metrics are merged with the ones
+// from gatherer (e.g. scrape_errors_total), and the OTLPExporter's own metrics are registered with registry.
+func NewOTLPExporter(
+	oc *config.OTLPConfig, e Exporter, gatherer prometheus.Gatherer, registry prometheus.Registerer,
+) (*OTLPExporter, error) {
+	var (
+		client otlpClient
+		err    error
+	)
+	if oc.Protocol == config.OTLPProtocolGRPC {
+		client, err = newOTLPGRPCClient(oc)
+	} else {
+		client, err = newOTLPHTTPClient(oc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP client: %w", err)
+	}
+
+	o := &OTLPExporter{
+		config:    oc,
+		exporter:  e,
+		gatherer:  gatherer,
+		client:    client,
+		startTime: time.Now(),
+		failedExports: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "otlp_failed_exports_total",
+			Help: "Total number of failed OTLP export requests",
+		}),
+	}
+	if err := registry.Register(o.failedExports); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Run gathers and exports metrics every export interval until the context is closed.
+func (o *OTLPExporter) Run(ctx context.Context) {
+	slog.Warn("Started exporting metrics over OTLP", "endpoint", o.config.Endpoint, "protocol", o.config.Protocol,
+		"interval", o.config.Interval)
+
+	ticker := time.NewTicker(time.Duration(o.config.Interval))
+	defer ticker.Stop()
+	for {
+		if err := o.export(ctx); err != nil {
+			o.failedExports.Inc()
+			slog.Warn("OTLP export failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// export gathers metrics and sends them in a single request.
+func (o *OTLPExporter) export(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(o.config.Timeout))
+	defer cancel()
+
+	mfs, err := prometheus.Gatherers{o.exporter.WithContext(ctx), o.gatherer}.Gather()
+	if err != nil {
+		slog.Warn("Error gathering metrics for OTLP export", "error", err)
+		if len(mfs) == 0 {
+			return nil
+		}
+	}
+	mfs = o.exporter.FilterScrapeErrorsTotal(mfs)
+
+	resp, err := o.client.export(ctx, &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: toOTLPMetrics(mfs, o.config.ResourceAttributes, o.startTime, time.Now()),
+	})
+	if err != nil {
+		return err
+	}
+	if ps := resp.GetPartialSuccess(); ps.GetRejectedDataPoints() > 0 {
+		slog.Warn("OTLP export partially rejected", "rejected", ps.GetRejectedDataPoints(), "message",
+			ps.GetErrorMessage())
+	}
+	return nil
+}
+
+// toOTLPMetrics converts metric families into OTLP resource metrics. Series are grouped into one resource per job and
+// target, with the job and target labels mapped to the service.name and service.instance.id resource attributes. All
+// other labels become data point attributes. Data points without a timestamp (i.e. without timestamp_value) are
+// assigned the provided one. Cumulative data points start at their created timestamp if any, at start otherwise.
+func toOTLPMetrics(
+	mfs []*dto.MetricFamily, resourceAttrs map[string]string, start, now time.Time,
+) []*metricspb.ResourceMetrics {
+	type resource struct {
+		rm      *metricspb.ResourceMetrics
+		metrics map[string]*metricspb.Metric
+	}
+	var resources []*resource
+	byKey := make(map[[2]string]*resource)
+
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			var job, instance string
+			attrs := make([]*commonpb.KeyValue, 0, len(m.Label))
+			for _, l := range m.Label {
+				switch l.GetName() {
+				case "job":
+					job = l.GetValue()
+				case config.TargetLabel:
+					instance = l.GetValue()
+				default:
+					attrs = append(attrs, otlpAttr(l.GetName(), l.GetValue()))
+				}
+			}
+
+			key := [2]string{job, instance}
+			r, found := byKey[key]
+			if !found {
+				r = &resource{
+					rm: &metricspb.ResourceMetrics{
+						Resource: &resourcepb.Resource{Attributes: otlpResourceAttrs(resourceAttrs, job, instance)},
+						ScopeMetrics: []*metricspb.ScopeMetrics{{
+							Scope: &commonpb.InstrumentationScope{Name: "sql_exporter", Version: version.Version},
+						}},
+					},
+					metrics: make(map[string]*metricspb.Metric),
+				}
+				byKey[key] = r
+				resources = append(resources, r)
+			}
+			metric, found := r.metrics[mf.GetName()]
+			if !found {
+				metric = newOTLPMetric(mf)
+				if metric == nil {
+					continue
+				}
+				r.metrics[mf.GetName()] = metric
+				r.rm.ScopeMetrics[0].Metrics = append(r.rm.ScopeMetrics[0].Metrics, metric)
+			}
+
+			ts := uint64(now.UnixNano())
+			if m.TimestampMs != nil {
+				ts = uint64(m.GetTimestampMs()) * uint64(time.Millisecond)
+			}
+			startTs := uint64(start.UnixNano())
+			appendOTLPDataPoint(metric, m, attrs, startTs, ts)
+		}
+	}
+
+	out := make([]*metricspb.ResourceMetrics, 0, len(resources))
+	for _, r := range resources {
+		out = append(out, r.rm)
+	}
+	return out
+}
+
+// otlpResourceAttrs returns the resource attributes for a job and target. The job defaults to the service.name from
+// the static attributes if any, then to "sql_exporter".
+func otlpResourceAttrs(static map[string]string, job, instance string) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(static)+2)
+	for name, value := range static {
+		if name == serviceNameAttr || name == serviceInstanceIDAttr {
+			continue
+		}
+		attrs = append(attrs, otlpAttr(name, value))
+	}
+	switch {
+	case job != "":
+	case static[serviceNameAttr] != "":
+		job = static[serviceNameAttr]
+	default:
+		job = defaultServiceName
+	}
+	attrs = append(attrs, otlpAttr(serviceNameAttr, job))
+	if instance != "" {
+		attrs = append(attrs, otlpAttr(serviceInstanceIDAttr, instance))
+	}
+	return attrs
+}
+
+// newOTLPMetric returns an empty OTLP metric matching the metric family type, or nil for unsupported types.
+func newOTLPMetric(mf *dto.MetricFamily) *metricspb.Metric {
+	metric := &metricspb.Metric{Name: mf.GetName(), Description: mf.GetHelp()}
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		metric.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: cumulative,
+			IsMonotonic:            true,
+		}}
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+	case dto.MetricType_HISTOGRAM:
+		metric.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{AggregationTemporality: cumulative}}
+	case dto.MetricType_SUMMARY:
+		metric.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{}}
+	default:
+		return nil
+	}
+	return metric
+}
+
+// appendOTLPDataPoint converts a metric into a data point and appends it to the OTLP metric.
+func appendOTLPDataPoint(metric *metricspb.Metric, m *dto.Metric, attrs []*commonpb.KeyValue, start, ts uint64) {
+	switch data := metric.Data.(type) {
+	case *metricspb.Metric_Sum:
+		if ct := m.GetCounter().GetCreatedTimestamp(); ct != nil {
+			start = uint64(ct.AsTime().UnixNano())
+		}
+		data.Sum.DataPoints = append(data.Sum.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:        attrs,
+			StartTimeUnixNano: start,
+			TimeUnixNano:      ts,
+			Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: m.GetCounter().GetValue()},
+		})
+	case *metricspb.Metric_Gauge:
+		value := m.GetGauge().GetValue()
+		if m.Untyped != nil {
+			value = m.GetUntyped().GetValue()
+		}
+		data.Gauge.DataPoints = append(data.Gauge.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:   attrs,
+			TimeUnixNano: ts,
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+		})
+	case *metricspb.Metric_Histogram:
+		h := m.GetHistogram()
+		dp := &metricspb.HistogramDataPoint{
+			Attributes:        attrs,
+			StartTimeUnixNano: start,
+			TimeUnixNano:      ts,
+			Count:             h.GetSampleCount(),
+			Sum:               new(h.GetSampleSum()),
+		}
+		// OTLP bucket counts are not cumulative and end with an implicit +Inf bucket.
+		var prev uint64
+		for _, b := range h.Bucket {
+			if math.IsInf(b.GetUpperBound(), +1) {
+				continue
+			}
+			dp.ExplicitBounds = append(dp.ExplicitBounds, b.GetUpperBound())
+			dp.BucketCounts = append(dp.BucketCounts, b.GetCumulativeCount()-prev)
+			prev = b.GetCumulativeCount()
+		}
+		dp.BucketCounts = append(dp.BucketCounts, h.GetSampleCount()-prev)
+		data.Histogram.DataPoints = append(data.Histogram.DataPoints, dp)
+	case *metricspb.Metric_Summary:
+		s := m.GetSummary()
+		dp := &metricspb.SummaryDataPoint{
+			Attributes:        attrs,
+			StartTimeUnixNano: start,
+			TimeUnixNano:      ts,
+			Count:             s.GetSampleCount(),
+			Sum:               s.GetSampleSum(),
+		}
+		for _, q := range s.Quantile {
+			dp.QuantileValues = append(dp.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+				Quantile: q.GetQuantile(),
+				Value:    q.GetValue(),
+			})
+		}
+		data.Summary.DataPoints = append(data.Summary.DataPoints, dp)
+	}
+}
+
+// otlpAttr returns a string attribute.
+func otlpAttr(name, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   name,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+//
+// OTLP/HTTP
+//
+
+// otlpHTTPClient sends export requests as binary protobuf over HTTP.
+type otlpHTTPClient struct {
+	config *config.OTLPConfig
+	client *http.Client
+}
+
+func newOTLPHTTPClient(oc *config.OTLPConfig) (*otlpHTTPClient, error) {
+	client, err := promcfg.NewClientFromConfig(promcfg.HTTPClientConfig{
+		TLSConfig:       oc.TLSConfig,
+		FollowRedirects: true,
+		EnableHTTP2:     true,
+	}, "sql_exporter_otlp")
+	if err != nil {
+		return nil, err
+	}
+	return &otlpHTTPClient{config: oc, client: client}, nil
+}
+
+func (c *otlpHTTPClient) export(
+	ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest,
+) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.Compression == "gzip" {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(body); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+		body = buf.Bytes()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range c.config.Headers {
+		httpReq.Header.Set(name, value)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "sql_exporter/"+version.Version)
+	if c.config.Compression == "gzip" {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+
+	exportResp := &colmetricspb.ExportMetricsServiceResponse{}
+	if resp.Header.Get("Content-Type") == "application/x-protobuf" {
+		if err := proto.Unmarshal(respBody, exportResp); err != nil {
+			return nil, fmt.Errorf("invalid export response: %w", err)
+		}
+	}
+	return exportResp, nil
+}
+
+//
+// OTLP/gRPC
+//
+
+// otlpGRPCClient sends export requests to the collector's gRPC metrics service.
+type otlpGRPCClient struct {
+	config *config.OTLPConfig
+	client colmetricspb.MetricsServiceClient
+}
+
+func newOTLPGRPCClient(oc *config.OTLPConfig) (*otlpGRPCClient, error) {
+	creds := insecure.NewCredentials()
+	if !oc.Insecure {
+		tlsConfig, err := promcfg.NewTLSConfig(&oc.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(oc.Endpoint, grpc.WithTransportCredentials(creds),
+		grpc.WithUserAgent("sql_exporter/"+version.Version))
+	if err != nil {
+		return nil, err
+	}
+	return &otlpGRPCClient{config: oc, client: colmetricspb.NewMetricsServiceClient(conn)}, nil
+}
+
+func (c *otlpGRPCClient) export(
+	ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest,
+) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	if len(c.config.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(c.config.Headers))
+	}
+	var opts []grpc.CallOption
+	if c.config.Compression == "gzip" {
+		opts = append(opts, grpc.UseCompressor(grpcgzip.Name))
+	}
+	return c.client.Export(ctx, req, opts...)
+}
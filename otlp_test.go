@@ -0,0 +1,148 @@
+package sql_exporter
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	dto "github.com/prometheus/client_model/go"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// attrMap flattens OTLP string attributes into a map.
+func attrMap(attrs []*commonpb.KeyValue) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for _, kv := range attrs {
+		m[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+	return m
+}
+
+func TestToOTLPMetrics(t *testing.T) {
+	labels := func(target string) []*dto.LabelPair {
+		return []*dto.LabelPair{
+			{Name: new("job"), Value: new("pg")},
+			{Name: new("schema"), Value: new("public")},
+			{Name: new(config.TargetLabel), Value: new(target)},
+		}
+	}
+	mfs := []*dto.MetricFamily{
+		{
+			Name: new("rows_total"),
+			Help: new("Rows"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				{Label: labels("db1"), Counter: &dto.Counter{Value: new(5.0)}, TimestampMs: new(int64(1000))},
+				{Label: labels("db2"), Counter: &dto.Counter{Value: new(7.0)}},
+			},
+		},
+		{
+			Name: new("query_seconds"),
+			Help: new("Query duration"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Label: labels("db1"),
+				Histogram: &dto.Histogram{
+					SampleCount: new(uint64(5)),
+					SampleSum:   new(2.5),
+					Bucket: []*dto.Bucket{
+						{UpperBound: new(0.1), CumulativeCount: new(uint64(2))},
+						{UpperBound: new(1.0), CumulativeCount: new(uint64(4))},
+					},
+				},
+			}},
+		},
+	}
+
+	start, now := time.Unix(10, 0), time.Unix(20, 0)
+	rms := toOTLPMetrics(mfs, map[string]string{"deployment.environment": "prod"}, start, now)
+	if len(rms) != 2 {
+		t.Fatalf("expected one resource per target, got %d", len(rms))
+	}
+
+	res := attrMap(rms[0].GetResource().GetAttributes())
+	if res[serviceNameAttr] != "pg" || res[serviceInstanceIDAttr] != "db1" || res["deployment.environment"] != "prod" {
+		t.Errorf("unexpected resource attributes: %v", res)
+	}
+	metrics := rms[0].GetScopeMetrics()[0].GetMetrics()
+	if len(metrics) != 2 {
+		t.Fatalf("expected 2 metrics for db1, got %d", len(metrics))
+	}
+
+	sum := metrics[0].GetSum()
+	if !sum.GetIsMonotonic() || len(sum.GetDataPoints()) != 1 {
+		t.Fatalf("unexpected sum: %v", sum)
+	}
+	dp := sum.GetDataPoints()[0]
+	if dp.GetAsDouble() != 5 || dp.GetTimeUnixNano() != uint64(time.Second) ||
+		dp.GetStartTimeUnixNano() != uint64(start.UnixNano()) {
+		t.Errorf("unexpected data point: %v", dp)
+	}
+	if attrs := attrMap(dp.GetAttributes()); len(attrs) != 1 || attrs["schema"] != "public" {
+		t.Errorf("unexpected data point attributes: %v", attrs)
+	}
+
+	hdp := metrics[1].GetHistogram().GetDataPoints()[0]
+	if !slices.Equal(hdp.GetExplicitBounds(), []float64{0.1, 1}) ||
+		!slices.Equal(hdp.GetBucketCounts(), []uint64{2, 2, 1}) || hdp.GetCount() != 5 || hdp.GetSum() != 2.5 {
+		t.Errorf("unexpected histogram data point: %v", hdp)
+	}
+
+	// No timestamp_value, the data point is stamped with the export time.
+	dp = rms[1].GetScopeMetrics()[0].GetMetrics()[0].GetSum().GetDataPoints()[0]
+	if dp.GetTimeUnixNano() != uint64(now.UnixNano()) {
+		t.Errorf("time = %d, want %d", dp.GetTimeUnixNano(), now.UnixNano())
+	}
+}
+
+func TestOTLPHTTPClient(t *testing.T) {
+	var received *colmetricspb.ExportMetricsServiceRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("X-Api-Key") != "secret" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatalf("expected a gzip body: %s", err)
+		}
+		body, _ := io.ReadAll(gz)
+		received = &colmetricspb.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, received); err != nil {
+			t.Errorf("failed to decode body: %s", err)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer srv.Close()
+
+	client, err := newOTLPHTTPClient(&config.OTLPConfig{
+		Endpoint:    srv.URL + "/v1/metrics",
+		Headers:     map[string]string{"X-Api-Key": "secret"},
+		Compression: "gzip",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: toOTLPMetrics([]*dto.MetricFamily{{
+		Name:   new("up"),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: new(1.0)}}},
+	}}, nil, time.Now(), time.Now())}
+	if _, err := client.export(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(received, req) {
+		t.Errorf("received %v, want %v", received, req)
+	}
+	if name := attrMap(received.GetResourceMetrics()[0].GetResource().GetAttributes())[serviceNameAttr]; name !=
+		defaultServiceName {
+		t.Errorf("service.name = %q, want %q", name, defaultServiceName)
+	}
+}
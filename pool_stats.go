@@ -0,0 +1,85 @@
+package sql_exporter
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolStatsLabels = []string{"job", "target"}
+
+	poolMaxOpenDesc = prometheus.NewDesc("db_pool_max_open_connections",
+		"Maximum number of open connections to the target, 0 if unlimited", poolStatsLabels, nil)
+	poolOpenDesc = prometheus.NewDesc("db_pool_open_connections",
+		"Number of established connections to the target, both in use and idle", poolStatsLabels, nil)
+	poolInUseDesc = prometheus.NewDesc("db_pool_in_use_connections",
+		"Number of connections to the target currently in use", poolStatsLabels, nil)
+	poolIdleDesc = prometheus.NewDesc("db_pool_idle_connections",
+		"Number of idle connections to the target", poolStatsLabels, nil)
+	poolWaitCountDesc = prometheus.NewDesc("db_pool_wait_count_total",
+		"Total number of connections to the target waited for, with max_connections in use", poolStatsLabels, nil)
+	poolWaitDurationDesc = prometheus.NewDesc("db_pool_wait_duration_seconds_total",
+		"Total time blocked waiting for a connection to the target", poolStatsLabels, nil)
+	poolMaxIdleClosedDesc = prometheus.NewDesc("db_pool_max_idle_closed_total",
+		"Total number of connections to the target closed due to max_idle_connections", poolStatsLabels, nil)
+	poolMaxIdleTimeClosedDesc = prometheus.NewDesc("db_pool_max_idle_time_closed_total",
+		"Total number of connections to the target closed due to the maximum idle time", poolStatsLabels, nil)
+	poolMaxLifetimeClosedDesc = prometheus.NewDesc("db_pool_max_lifetime_closed_total",
+		"Total number of connections to the target closed due to max_connection_lifetime", poolStatsLabels, nil)
+)
+
+// poolStatsCollector exports the connection pool statistics of the exporter's targets, as reported by sql.DB. Targets
+// whose connection pool isn't open (yet) are left out. The counters start over when a target's pool is replaced.
+type poolStatsCollector struct {
+	exporter *exporter
+}
+
+// Describe implements prometheus.Collector.
+func (c poolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		poolMaxOpenDesc, poolOpenDesc, poolInUseDesc, poolIdleDesc, poolWaitCountDesc, poolWaitDurationDesc,
+		poolMaxIdleClosedDesc, poolMaxIdleTimeClosedDesc, poolMaxLifetimeClosedDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c poolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range c.exporter.Targets() {
+		t, ok := t.(*target)
+		if !ok {
+			continue
+		}
+		stats, ok := t.poolStats()
+		if !ok {
+			continue
+		}
+		labels := []string{t.jobGroup, t.name}
+		gauge := func(desc *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+		}
+		counter := func(desc *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, labels...)
+		}
+		gauge(poolMaxOpenDesc, float64(stats.MaxOpenConnections))
+		gauge(poolOpenDesc, float64(stats.OpenConnections))
+		gauge(poolInUseDesc, float64(stats.InUse))
+		gauge(poolIdleDesc, float64(stats.Idle))
+		counter(poolWaitCountDesc, float64(stats.WaitCount))
+		counter(poolWaitDurationDesc, stats.WaitDuration.Seconds())
+		counter(poolMaxIdleClosedDesc, float64(stats.MaxIdleClosed))
+		counter(poolMaxIdleTimeClosedDesc, float64(stats.MaxIdleTimeClosed))
+		counter(poolMaxLifetimeClosedDesc, float64(stats.MaxLifetimeClosed))
+	}
+}
+
+// poolStats returns the statistics of the target's connection pool, false if it isn't open.
+func (t *target) poolStats() (sql.DBStats, bool) {
+	conn := t.pool.Load()
+	if conn == nil {
+		return sql.DBStats{}, false
+	}
+	return conn.Stats(), true
+}
@@ -0,0 +1,69 @@
+package sql_exporter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	_ "github.com/mithrandie/csvq-driver"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPoolStatsCollector(t *testing.T) {
+	gc := &config.GlobalConfig{MaxConns: 2, MaxIdleConns: 2}
+	opened, err := NewTarget("", "db1", "pg", "csvq:"+t.TempDir(), nil, nil, gc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	// Not scraped yet, so without a connection pool.
+	unopened, err := NewTarget("", "db2", "pg", "csvq:"+t.TempDir(), nil, nil, gc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unopened.Close()
+
+	ch := make(chan Metric, capMetricChan)
+	opened.Collect(context.Background(), ch)
+
+	registry := prometheus.NewRegistry()
+	e := &exporter{targets: []Target{opened, unopened}}
+	if err := registry.Register(poolStatsCollector{exporter: e}); err != nil {
+		t.Fatal(err)
+	}
+	// Doesn't wait for a target that's connecting.
+	opened.(*target).mu.Lock()
+	mfs, gerr := registry.Gather()
+	opened.(*target).mu.Unlock()
+	if gerr != nil {
+		t.Fatal(gerr)
+	}
+
+	values := make(map[string]float64)
+	for _, mf := range mfs {
+		if len(mf.Metric) != 1 {
+			t.Fatalf("expected %s for db1 only, got %v", mf.GetName(), mf.Metric)
+		}
+		m := mf.Metric[0]
+		if labels := labelMap(m); labels["job"] != "pg" || labels["target"] != "db1" {
+			t.Errorf("unexpected labels for %s: %v", mf.GetName(), labels)
+		}
+		values[mf.GetName()] = m.GetGauge().GetValue() + m.GetCounter().GetValue()
+	}
+	if len(values) != 9 {
+		t.Errorf("expected 9 pool metrics, got %v", values)
+	}
+	if values["db_pool_max_open_connections"] != 2 || values["db_pool_open_connections"] != 1 ||
+		values["db_pool_idle_connections"] != 1 || values["db_pool_in_use_connections"] != 0 {
+		t.Errorf("unexpected pool metrics after the ping: %v", values)
+	}
+}
+
+func labelMap(m *dto.Metric) map[string]string {
+	labels := make(map[string]string, len(m.Label))
+	for _, l := range m.Label {
+		labels[l.GetName()] = l.GetValue()
+	}
+	return labels
+}
@@ -0,0 +1,145 @@
+package sql_exporter
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrProbeNotConfigured is returned by Exporter.Probe when the config doesn't define a `probe` section.
+var ErrProbeNotConfigured = errors.New("probing is not configured")
+
+// probeKey identifies a probe target: the same target probed with different modules or auth modules gets distinct
+// collectors or credentials, hence distinct Target objects.
+type probeKey struct {
+	target, module, authModule string
+}
+
+// probeEntry is a cached probe target, along with the global config it was created with.
+type probeEntry struct {
+	key     probeKey
+	target  Target
+	globals *config.GlobalConfig
+}
+
+// probeCache is a bounded LRU cache of live probe targets, so that their connection pools are reused across scrapes.
+// Evicted targets are closed.
+type probeCache struct {
+	mu      sync.Mutex
+	entries map[probeKey]*list.Element
+	lru     *list.List // most recently used first
+}
+
+func newProbeCache() *probeCache {
+	return &probeCache{entries: make(map[probeKey]*list.Element), lru: list.New()}
+}
+
+// get returns the target for the provided key, creating it with newTarget if not cached or if cached with a different
+// global config (i.e. before a reload). If the cache then exceeds maxSize, the least recently used targets are evicted.
+// Replaced and evicted targets are closed once the lock is released, as closing waits for their in-flight scrapes.
+func (c *probeCache) get(
+	key probeKey, maxSize int, globals *config.GlobalConfig, newTarget func() (Target, error),
+) (Target, error) {
+	var evicted []Target
+	defer func() { closeTargets(evicted) }()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, found := c.entries[key]; found {
+		entry := elem.Value.(*probeEntry)
+		if entry.globals == globals {
+			c.lru.MoveToFront(elem)
+			return entry.target, nil
+		}
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		evicted = append(evicted, entry.target)
+	}
+
+	t, err := newTarget()
+	if err != nil {
+		return nil, err
+	}
+	c.entries[key] = c.lru.PushFront(&probeEntry{key: key, target: t, globals: globals})
+
+	for c.lru.Len() > maxSize {
+		entry := c.lru.Remove(c.lru.Back()).(*probeEntry)
+		delete(c.entries, entry.key)
+		evicted = append(evicted, entry.target)
+		slog.Debug("Evicted probe target", "target", entry.key.target, "module", entry.key.module)
+	}
+	return t, nil
+}
+
+// purge closes and removes all cached targets.
+func (c *probeCache) purge() {
+	c.mu.Lock()
+	targets := make([]Target, 0, c.lru.Len())
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		targets = append(targets, elem.Value.(*probeEntry).target)
+	}
+	clear(c.entries)
+	c.lru.Init()
+	c.mu.Unlock()
+
+	closeTargets(targets)
+}
+
+// Probe implements Exporter.
+func (e *exporter) Probe(target, module, authModule string) (Exporter, error) {
+	c := e.Config()
+	pc := c.Probe
+	if pc == nil {
+		return nil, ErrProbeNotConfigured
+	}
+	mc, found := pc.Module(module)
+	if !found {
+		return nil, fmt.Errorf("unknown probe module %q", module)
+	}
+	if authModule == "" {
+		authModule = mc.AuthModule
+	}
+	if err := config.CheckProbeTarget(target); err != nil {
+		return nil, err
+	}
+
+	t, err := e.probes.get(probeKey{target, module, authModule}, pc.MaxTargets, c.Globals, func() (Target, error) {
+		dsn, err := pc.DataSourceName(authModule, target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render data source name of probe target %q: %w", target, err)
+		}
+		labels := prometheus.Labels{config.TargetLabel: target}
+		maps.Copy(labels, mc.Labels)
+		spec := targetSpec{
+			logContext:  fmt.Sprintf(`module=%s`, module),
+			name:        target,
+			dsn:         dsn,
+			collectors:  mc.Collectors(),
+			constLabels: labels,
+			enablePing:  mc.EnablePing,
+		}
+		t, werr := spec.newTarget(c.Globals)
+		if werr != nil {
+			return nil, werr
+		}
+		return t, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &exporter{
+		config:   c,
+		targets:  []Target{t},
+		ctx:      context.Background(),
+		registry: e.registry,
+		probes:   e.probes,
+	}, nil
+}
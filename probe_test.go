@@ -0,0 +1,149 @@
+package sql_exporter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"go.yaml.in/yaml/v3"
+)
+
+func TestProbeCache(t *testing.T) {
+	c := newProbeCache()
+	globals := &config.GlobalConfig{}
+	created := 0
+	get := func(name string) Target {
+		t.Helper()
+		target, err := c.get(probeKey{target: name, module: "m"}, 2, globals, func() (Target, error) {
+			created++
+			return NewTarget("", name, "", "postgres://"+name, nil, nil, &config.GlobalConfig{}, nil)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return target
+	}
+
+	db1 := get("db1")
+	get("db2")
+	if get("db1") != db1 || created != 2 {
+		t.Errorf("expected db1 to be reused, created %d targets", created)
+	}
+
+	// db2 is the least recently used and gets evicted.
+	get("db3")
+	if _, found := c.entries[probeKey{target: "db2", module: "m"}]; found || c.lru.Len() != 2 {
+		t.Errorf("expected db2 to be evicted, have %d entries", c.lru.Len())
+	}
+	if get("db1") != db1 {
+		t.Error("expected db1 to be kept")
+	}
+
+	// Targets created before a reload are replaced.
+	globals = &config.GlobalConfig{}
+	if get("db1") == db1 {
+		t.Error("expected db1 to be recreated with the new global config")
+	}
+
+	c.purge()
+	if c.lru.Len() != 0 || len(c.entries) != 0 {
+		t.Errorf("expected an empty cache after purge, have %d entries", c.lru.Len())
+	}
+}
+
+func TestExporterProbe(t *testing.T) {
+	e := &exporter{config: &config.Config{Globals: &config.GlobalConfig{MaxConcurrentQueries: 3}}, probes: newProbeCache()}
+	defer sharedQuerySemaphore(0)
+	if _, err := e.Probe("db1", "pg", ""); !errors.Is(err, ErrProbeNotConfigured) {
+		t.Errorf("expected ErrProbeNotConfigured, got %v", err)
+	}
+
+	content := `
+auth_modules:
+  monitor: postgres://monitor@{{ .Target }}/postgres
+modules:
+  - module_name: pg
+    collectors: [pg_standard]
+    auth_module: monitor
+    labels:
+      env: prod
+`
+	if err := yaml.Unmarshal([]byte(content), &e.config.Probe); err != nil {
+		t.Fatal(err)
+	}
+
+	pe, err := e.Probe("db1:5432", "pg", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt := pe.Targets()[0].(*target)
+	if pt.dsn != "postgres://monitor@db1:5432/postgres" || pt.constLabels["env"] != "prod" ||
+		pt.constLabels[config.TargetLabel] != "db1:5432" {
+		t.Errorf("unexpected probe target: dsn=%q, labels=%v", pt.dsn, pt.constLabels)
+	}
+
+	if _, err := e.Probe("db1:5432", "mysql", ""); err == nil {
+		t.Error("expected an unknown module error")
+	}
+	if _, err := e.Probe("db1:5432", "pg", "admin"); err == nil {
+		t.Error("expected an unknown auth module error")
+	}
+	for _, target := range []string{"evil:5432/db?sslmode=disable&host=attacker", "user:pass@db1", "db1/postgres"} {
+		if _, err := e.Probe(target, "pg", ""); err == nil || !strings.Contains(err.Error(), "invalid probe target") {
+			t.Errorf("expected %q to be rejected, got %v", target, err)
+		}
+	}
+	if pt.queries.global == nil || pt.queries.global.limit != 3 {
+		t.Error("expected the global max_concurrent_queries to apply to the probe target")
+	}
+}
+
+func TestProbeCacheEvictionDoesntBlock(t *testing.T) {
+	c := newProbeCache()
+	globals := &config.GlobalConfig{}
+	get := func(name string) (Target, error) {
+		return c.get(probeKey{target: name, module: "m"}, 1, globals, func() (Target, error) {
+			return NewTarget("", name, "", "postgres://"+name, nil, nil, globals, nil)
+		})
+	}
+	db1, err := get("db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An in-flight scrape of db1 keeps it from being closed.
+	db1.(*target).scrapeMu.RLock()
+
+	evicted := make(chan struct{})
+	go func() {
+		defer close(evicted)
+		if _, err := get("db2"); err != nil {
+			t.Error(err)
+		}
+	}()
+	for range 100 {
+		c.mu.Lock()
+		_, found := c.entries[probeKey{target: "db2", module: "m"}]
+		c.mu.Unlock()
+		if found {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The cache is usable while db1 is being closed.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = get("db2")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected the cache not to be locked while closing evicted targets")
+	}
+
+	db1.(*target).scrapeMu.RUnlock()
+	<-evicted
+	closeTargets([]Target{c.lru.Front().Value.(*probeEntry).target})
+}
@@ -0,0 +1,347 @@
+package sql_exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	promcfg "github.com/prometheus/common/config"
+	"github.com/prometheus/common/version"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Remote-write protocol v1 metric types, as defined in prompb.MetricMetadata.
+const (
+	rwTypeUnknown   = 0
+	rwTypeCounter   = 1
+	rwTypeGauge     = 2
+	rwTypeHistogram = 3
+	rwTypeSummary   = 5
+)
+
+// Pusher periodically gathers metrics from an Exporter and pushes them to a Prometheus remote-write endpoint. Pending
+// pushes are kept in a bounded in-memory queue and retried with exponential backoff. When the queue is full, the oldest
+// pending push is dropped.
+type Pusher struct {
+	config   *config.PushConfig
+	exporter Exporter
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	queue    chan []byte
+
+	pushedSamples  prometheus.Counter
+	failedRequests prometheus.Counter
+	droppedPushes  prometheus.Counter
+}
+
+// errNonRecoverable is returned for pushes rejected by the endpoint in a way that retrying won't fix.
+var errNonRecoverable = errors.New("non-recoverable remote-write error")
+
+// NewPusher returns a new Pusher for the provided config. The exporter's metrics are merged with the ones from
+// gatherer (e.g. scrape_errors_total), and the Pusher's own metrics are registered with registry.
+func NewPusher(
+	pc *config.PushConfig, e Exporter, gatherer prometheus.Gatherer, registry prometheus.Registerer,
+) (*Pusher, error) {
+	client, err := promcfg.NewClientFromConfig(pc.HTTPClientConfig(), "sql_exporter_push")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create push HTTP client: %w", err)
+	}
+	client.Timeout = time.Duration(pc.Timeout)
+
+	p := &Pusher{
+		config:   pc,
+		exporter: e,
+		gatherer: gatherer,
+		client:   client,
+		queue:    make(chan []byte, pc.QueueCapacity),
+		pushedSamples: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "push_samples_total",
+			Help: "Total number of samples successfully pushed to the remote-write endpoint",
+		}),
+		failedRequests: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "push_failed_requests_total",
+			Help: "Total number of failed remote-write requests, including retries",
+		}),
+		droppedPushes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "push_dropped_total",
+			Help: "Total number of pushes dropped because the queue was full or the endpoint rejected them",
+		}),
+	}
+	for _, c := range []prometheus.Collector{p.pushedSamples, p.failedRequests, p.droppedPushes} {
+		if err := registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Run gathers metrics every push interval and sends them to the endpoint until the context is closed.
+func (p *Pusher) Run(ctx context.Context) {
+	slog.Warn("Started pushing metrics", "url", p.config.URL, "interval", p.config.Interval)
+	go p.send(ctx)
+
+	ticker := time.NewTicker(time.Duration(p.config.Interval))
+	defer ticker.Stop()
+	for {
+		p.enqueue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// enqueue gathers metrics, encodes them into a remote-write request and adds it to the queue, dropping the oldest
+// pending request if the queue is full.
+func (p *Pusher) enqueue(ctx context.Context) {
+	gatherCtx, cancel := context.WithTimeout(ctx, time.Duration(p.config.Timeout))
+	defer cancel()
+
+	mfs, err := prometheus.Gatherers{p.exporter.WithContext(gatherCtx), p.gatherer}.Gather()
+	if err != nil {
+		slog.Warn("Error gathering metrics for push", "error", err)
+		if len(mfs) == 0 {
+			return
+		}
+	}
+	mfs = p.exporter.FilterScrapeErrorsTotal(mfs)
+
+	req := encodeWriteRequest(mfs, time.Now())
+	for {
+		select {
+		case p.queue <- req:
+			return
+		default:
+		}
+		// Queue is full, drop the oldest pending request and try again.
+		select {
+		case <-p.queue:
+			p.droppedPushes.Inc()
+			slog.Warn("Push queue is full, dropped the oldest pending push", "capacity", p.config.QueueCapacity)
+		default:
+		}
+	}
+}
+
+// send pushes queued requests in order, retrying each one with exponential backoff until it succeeds, is rejected as
+// non-recoverable or the context is closed.
+func (p *Pusher) send(ctx context.Context) {
+	for {
+		var req []byte
+		select {
+		case <-ctx.Done():
+			return
+		case req = <-p.queue:
+		}
+
+		backoff := time.Duration(p.config.MinBackoff)
+		for {
+			err := p.push(ctx, req)
+			if err == nil {
+				break
+			}
+			p.failedRequests.Inc()
+			if errors.Is(err, errNonRecoverable) {
+				p.droppedPushes.Inc()
+				slog.Error("Push rejected, dropping it", "error", err)
+				break
+			}
+			slog.Warn("Push failed, retrying", "error", err, "backoff", backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, time.Duration(p.config.MaxBackoff))
+		}
+	}
+}
+
+// push sends a single snappy-compressed remote-write request.
+func (p *Pusher) push(ctx context.Context, req []byte) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.URL,
+		bytes.NewReader(snappy.Encode(nil, req)))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errNonRecoverable, err)
+	}
+	for name, value := range p.config.Headers {
+		httpReq.Header.Set(name, value)
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "sql_exporter/"+version.Version)
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		p.pushedSamples.Add(float64(countSamples(req)))
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	// Only server errors and rate limiting are worth retrying, as per the remote-write specification.
+	if resp.StatusCode/100 != 5 && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %w", errNonRecoverable, err)
+	}
+	return err
+}
+
+// encodeWriteRequest converts metric families into a serialized remote-write v1 WriteRequest. Samples without a
+// timestamp are assigned the provided one. Histograms and summaries are flattened into their classic series.
+func encodeWriteRequest(mfs []*dto.MetricFamily, now time.Time) []byte {
+	nowMs := now.UnixMilli()
+	var buf []byte
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			ts := nowMs
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				buf = appendTimeSeries(buf, name, m.Label, "", "", m.GetCounter().GetValue(), ts)
+			case dto.MetricType_GAUGE:
+				buf = appendTimeSeries(buf, name, m.Label, "", "", m.GetGauge().GetValue(), ts)
+			case dto.MetricType_UNTYPED:
+				buf = appendTimeSeries(buf, name, m.Label, "", "", m.GetUntyped().GetValue(), ts)
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					buf = appendTimeSeries(buf, name, m.Label, "quantile", formatFloat(q.GetQuantile()),
+						q.GetValue(), ts)
+				}
+				buf = appendTimeSeries(buf, name+"_sum", m.Label, "", "", s.GetSampleSum(), ts)
+				buf = appendTimeSeries(buf, name+"_count", m.Label, "", "", float64(s.GetSampleCount()), ts)
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.Bucket {
+					infSeen = infSeen || math.IsInf(b.GetUpperBound(), +1)
+					buf = appendTimeSeries(buf, name+"_bucket", m.Label, "le", formatFloat(b.GetUpperBound()),
+						float64(b.GetCumulativeCount()), ts)
+				}
+				if !infSeen {
+					buf = appendTimeSeries(buf, name+"_bucket", m.Label, "le", "+Inf", float64(h.GetSampleCount()), ts)
+				}
+				buf = appendTimeSeries(buf, name+"_sum", m.Label, "", "", h.GetSampleSum(), ts)
+				buf = appendTimeSeries(buf, name+"_count", m.Label, "", "", float64(h.GetSampleCount()), ts)
+			}
+		}
+	}
+	for _, mf := range mfs {
+		buf = appendMetadata(buf, mf)
+	}
+	return buf
+}
+
+// appendTimeSeries appends a WriteRequest.timeseries field (1) with a single sample. The extra label, if not empty,
+// is added to the metric labels (e.g. `le` for histogram buckets). Labels are sorted by name, as required.
+func appendTimeSeries(
+	buf []byte, name string, labels []*dto.LabelPair, extraName, extraValue string, value float64, ts int64,
+) []byte {
+	pairs := make([][2]string, 0, len(labels)+2)
+	pairs = append(pairs, [2]string{"__name__", name})
+	for _, l := range labels {
+		pairs = append(pairs, [2]string{l.GetName(), l.GetValue()})
+	}
+	if extraName != "" {
+		pairs = append(pairs, [2]string{extraName, extraValue})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+
+	var series []byte
+	for _, p := range pairs {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, p[0])
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, p[1])
+		series = protowire.AppendTag(series, 1, protowire.BytesType)
+		series = protowire.AppendBytes(series, label)
+	}
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(ts))
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, sample)
+
+	buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+	return protowire.AppendBytes(buf, series)
+}
+
+// appendMetadata appends a WriteRequest.metadata field (3) describing the metric family.
+func appendMetadata(buf []byte, mf *dto.MetricFamily) []byte {
+	metricType := rwTypeUnknown
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		metricType = rwTypeCounter
+	case dto.MetricType_GAUGE:
+		metricType = rwTypeGauge
+	case dto.MetricType_HISTOGRAM:
+		metricType = rwTypeHistogram
+	case dto.MetricType_SUMMARY:
+		metricType = rwTypeSummary
+	}
+
+	var md []byte
+	md = protowire.AppendTag(md, 1, protowire.VarintType)
+	md = protowire.AppendVarint(md, uint64(metricType))
+	md = protowire.AppendTag(md, 2, protowire.BytesType)
+	md = protowire.AppendString(md, mf.GetName())
+	md = protowire.AppendTag(md, 4, protowire.BytesType)
+	md = protowire.AppendString(md, mf.GetHelp())
+
+	buf = protowire.AppendTag(buf, 3, protowire.BytesType)
+	return protowire.AppendBytes(buf, md)
+}
+
+// countSamples returns the number of time series in a serialized WriteRequest, each holding a single sample.
+func countSamples(req []byte) int {
+	n := 0
+	for len(req) > 0 {
+		num, typ, l := protowire.ConsumeTag(req)
+		if l < 0 {
+			return n
+		}
+		req = req[l:]
+		l = protowire.ConsumeFieldValue(num, typ, req)
+		if l < 0 {
+			return n
+		}
+		req = req[l:]
+		if num == 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// formatFloat formats a float the way Prometheus does for `le` and `quantile` label values.
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
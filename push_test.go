@@ -0,0 +1,171 @@
+package sql_exporter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeSeries decodes the time series of a serialized WriteRequest into "name{labels} value@ts" strings.
+func decodeSeries(t *testing.T, req []byte) []string {
+	t.Helper()
+	var out []string
+	forEachField(t, req, func(num protowire.Number, v []byte) {
+		if num != 1 {
+			return
+		}
+		var labels []string
+		var sample string
+		forEachField(t, v, func(num protowire.Number, v []byte) {
+			switch num {
+			case 1:
+				var name, value string
+				forEachField(t, v, func(num protowire.Number, v []byte) {
+					if num == 1 {
+						name = string(v)
+					} else {
+						value = string(v)
+					}
+				})
+				labels = append(labels, name+"="+value)
+			case 2:
+				value, n := protowire.ConsumeFixed64(v[1:])
+				ts, _ := protowire.ConsumeVarint(v[1+n+1:])
+				sample = formatFloat(math.Float64frombits(value)) + "@" + strconv.FormatUint(ts, 10)
+			}
+		})
+		out = append(out, strings.Join(labels, ",")+" "+sample)
+	})
+	return out
+}
+
+// forEachField calls fn with the number and raw value of each length-delimited field in b.
+func forEachField(t *testing.T, b []byte, fn func(protowire.Number, []byte)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || typ != protowire.BytesType {
+			t.Fatalf("unexpected field type %v", typ)
+		}
+		b = b[n:]
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			t.Fatal("malformed field")
+		}
+		b = b[n:]
+		fn(num, v)
+	}
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	mfs := []*dto.MetricFamily{
+		{
+			Name: new("db_up"),
+			Help: new("Database up"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{
+				Label:       []*dto.LabelPair{{Name: new("job"), Value: new("db")}},
+				Gauge:       &dto.Gauge{Value: new(1.0)},
+				TimestampMs: new(int64(1000)),
+			}},
+		},
+		{
+			Name: new("query_seconds"),
+			Help: new("Query duration"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Histogram: &dto.Histogram{
+					SampleCount: new(uint64(3)),
+					SampleSum:   new(2.5),
+					Bucket:      []*dto.Bucket{{UpperBound: new(1.0), CumulativeCount: new(uint64(2))}},
+				},
+			}},
+		},
+	}
+
+	got := decodeSeries(t, encodeWriteRequest(mfs, time.UnixMilli(2000)))
+	want := []string{
+		"__name__=db_up,job=db 1@1000",
+		"__name__=query_seconds_bucket,le=1 2@2000",
+		"__name__=query_seconds_bucket,le=+Inf 3@2000",
+		"__name__=query_seconds_sum 2.5@2000",
+		"__name__=query_seconds_count 3@2000",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("series = %q, want %q", got, want)
+	}
+}
+
+func TestPusherPush(t *testing.T) {
+	var status int
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("X-Tenant") != "team-a" ||
+			r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		req, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Errorf("failed to decode body: %s", err)
+		}
+		received = decodeSeries(t, req)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	pc := &config.PushConfig{
+		URL:           srv.URL,
+		Timeout:       model.Duration(time.Second),
+		Headers:       map[string]string{"X-Tenant": "team-a"},
+		QueueCapacity: 1,
+	}
+	p, err := NewPusher(pc, nil, prometheus.NewRegistry(), prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := encodeWriteRequest([]*dto.MetricFamily{{
+		Name:   new("db_up"),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: new(1.0)}, TimestampMs: new(int64(1000))}},
+	}}, time.Now())
+
+	for _, tc := range []struct {
+		status      int
+		recoverable bool
+	}{
+		{http.StatusNoContent, false},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusBadRequest, false},
+	} {
+		status = tc.status
+		err := p.push(context.Background(), req)
+		if !slices.Equal(received, []string{"__name__=db_up 1@1000"}) {
+			t.Errorf("status %d: received %q", tc.status, received)
+		}
+		switch {
+		case tc.status == http.StatusNoContent && err != nil:
+			t.Errorf("status %d: unexpected error %s", tc.status, err)
+		case tc.status != http.StatusNoContent && err == nil:
+			t.Errorf("status %d: expected an error", tc.status)
+		case err != nil && errors.Is(err, errNonRecoverable) == tc.recoverable:
+			t.Errorf("status %d: recoverable = %t, want %t", tc.status, !tc.recoverable, tc.recoverable)
+		}
+	}
+}
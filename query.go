@@ -3,6 +3,7 @@ package sql_exporter
 import (
 	"context"
 	"database/sql"
+	stderrors "errors"
 	"fmt"
 	"log/slog"
 	"sort"
@@ -12,6 +13,7 @@ import (
 	"github.com/burningalchemist/sql_exporter/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
 )
 
 // Query wraps a sql.Stmt and all the metrics populated from it. It helps extract keys and values from result rows.
@@ -20,11 +22,17 @@ type Query struct {
 	metricFamilies []*MetricFamily
 	// columnTypes maps column names to the column type expected by metrics: key (string) or value (float64).
 	columnTypes columnTypeMap
+	// extraLabels scans the columns not used by any metric as strings, for info metrics to expose them as labels.
+	extraLabels bool
 	logContext  string
 
 	durationDesc MetricDesc
 	rowsDesc     MetricDesc
 
+	// Query and placeholder arguments, rendered from the query templates for the target.
+	query string
+	args  []any
+
 	conn *sql.DB
 	stmt *sql.Stmt
 }
@@ -44,9 +52,16 @@ const (
 func NewQuery(logContext string, qc *config.QueryConfig, constLabels []*dto.LabelPair, enableQueryMetrics bool, metricFamilies ...*MetricFamily) (*Query, errors.WithContext) {
 	logContext = TrimMissingCtx(fmt.Sprintf(`%s,query=%s`, logContext, qc.Name))
 
+	query, args, err := qc.Render(queryTemplateData(constLabels))
+	if err != nil {
+		return nil, errors.Wrap(logContext, err)
+	}
+
 	columnTypes := make(columnTypeMap)
+	extraLabels := false
 
 	for _, mf := range metricFamilies {
+		extraLabels = extraLabels || mf.config.MetricType() == config.MetricTypeInfo
 		for _, kcol := range mf.config.KeyLabels {
 			if err := setColumnType(logContext, kcol, columnTypeKey, columnTypes); err != nil {
 				return nil, err
@@ -62,6 +77,31 @@ func NewQuery(logContext string, qc *config.QueryConfig, constLabels []*dto.Labe
 				return nil, err
 			}
 		}
+		if mf.config.CreatedTimestampValue != "" {
+			if err := setColumnType(logContext, mf.config.CreatedTimestampValue, columnTypeTime, columnTypes); err != nil {
+				return nil, err
+			}
+		}
+		for _, kcol := range mf.config.ExemplarLabels {
+			if err := setColumnType(logContext, kcol, columnTypeKey, columnTypes); err != nil {
+				return nil, err
+			}
+		}
+		if mf.config.StateValue != "" {
+			if err := setColumnType(logContext, mf.config.StateValue, columnTypeKey, columnTypes); err != nil {
+				return nil, err
+			}
+		}
+		for _, vcol := range []string{
+			mf.config.LeValue, mf.config.QuantileValue, mf.config.SumValue, mf.config.CountValue, mf.config.ExemplarValue,
+		} {
+			if vcol == "" {
+				continue
+			}
+			if err := setColumnType(logContext, vcol, columnTypeValue, columnTypes); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	var durationDesc, rowsDesc MetricDesc
@@ -85,13 +125,29 @@ func NewQuery(logContext string, qc *config.QueryConfig, constLabels []*dto.Labe
 		config:         qc,
 		metricFamilies: metricFamilies,
 		columnTypes:    columnTypes,
+		extraLabels:    extraLabels,
 		logContext:     logContext,
 		durationDesc:   durationDesc,
 		rowsDesc:       rowsDesc,
+		query:          query,
+		args:           args,
 	}
 	return &q, nil
 }
 
+// queryTemplateData returns the data available to query templates for a target, from the target's const labels.
+func queryTemplateData(constLabels []*dto.LabelPair) config.QueryTemplateData {
+	labels := make(map[string]string, len(constLabels))
+	for _, lp := range constLabels {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+	return config.QueryTemplateData{
+		Target: labels[config.TargetLabel],
+		Job:    labels["job"],
+		Labels: labels,
+	}
+}
+
 // setColumnType stores the provided type for a given column, checking for conflicts in the process.
 func setColumnType(logContext, columnName string, ctype columnType, columnTypes columnTypeMap) errors.WithContext {
 	previousType, found := columnTypes[columnName]
@@ -116,6 +172,12 @@ func (q *Query) Collect(ctx context.Context, conn *sql.DB, ch chan<- Metric) {
 		}
 	}()
 
+	if q.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(q.config.Timeout))
+		defer cancel()
+	}
+
 	if ctx.Err() != nil {
 		ch <- NewInvalidMetric(errors.Wrap(q.logContext, ctx.Err()))
 
@@ -123,17 +185,17 @@ func (q *Query) Collect(ctx context.Context, conn *sql.DB, ch chan<- Metric) {
 	}
 	rows, err := q.run(ctx, conn)
 	if err != nil {
-		ch <- NewInvalidMetric(err)
+		ch <- NewInvalidMetric(timeoutError(ctx, q.logContext, err))
 		return
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
 			slog.Error("Failed to close rows", "logContext", q.logContext, "error", err)
-			ch <- NewInvalidMetric(errors.Wrap(q.logContext, err))
+			ch <- NewInvalidMetric(timeoutError(ctx, q.logContext, errors.Wrap(q.logContext, err)))
 		}
 	}()
 
-	dest, err := q.scanDest(rows)
+	dest, extraLabels, err := q.scanDest(rows)
 	if err != nil {
 		if config.IgnoreMissingVals {
 			slog.Warn("Ignoring missing values", "logContext", q.logContext)
@@ -142,20 +204,46 @@ func (q *Query) Collect(ctx context.Context, conn *sql.DB, ch chan<- Metric) {
 		ch <- NewInvalidMetric(err)
 		return
 	}
+	metricFamilies := q.withExtraLabels(extraLabels)
+	// Histograms and summaries span multiple rows, so they are accumulated and only sent once all rows are read.
+	accumulators := make([]*metricAccumulator, len(metricFamilies))
+	for i, mf := range metricFamilies {
+		accumulators[i] = mf.newAccumulator()
+	}
 	for rows.Next() {
 		row, err := q.scanRow(rows, dest)
 		if err != nil {
-			ch <- NewInvalidMetric(err)
+			ch <- NewInvalidMetric(timeoutError(ctx, q.logContext, err))
 			continue
 		}
 		rowCount++
-		for _, mf := range q.metricFamilies {
+		for i, mf := range metricFamilies {
+			if accumulators[i] != nil {
+				accumulators[i].Add(row)
+				continue
+			}
 			mf.Collect(row, ch)
 		}
 	}
 	if err1 := rows.Err(); err1 != nil {
-		ch <- NewInvalidMetric(errors.Wrap(q.logContext, err1))
+		ch <- NewInvalidMetric(timeoutError(ctx, q.logContext, errors.Wrap(q.logContext, err1)))
+		return
+	}
+	for _, acc := range accumulators {
+		if acc != nil {
+			acc.Collect(ch)
+		}
+	}
+}
+
+// timeoutError returns an error wrapping context.DeadlineExceeded if the context has timed out, regardless of the error
+// returned by the driver (e.g. "canceling statement due to user request"), so it's reported as a timeout. Otherwise err
+// is returned unchanged.
+func timeoutError(ctx context.Context, logContext string, err errors.WithContext) errors.WithContext {
+	if ctx.Err() != context.DeadlineExceeded || stderrors.Is(err, context.DeadlineExceeded) {
+		return err
 	}
+	return errors.Errorf(logContext, "%w: %w", context.DeadlineExceeded, err.RawError())
 }
 
 // run executes the query on the provided database, in the provided context.
@@ -176,33 +264,35 @@ func (q *Query) run(ctx context.Context, conn *sql.DB) (*sql.Rows, errors.WithCo
 	}
 
 	if q.config.NoPreparedStatement {
-		rows, err := conn.QueryContext(ctx, q.config.Query)
+		rows, err := conn.QueryContext(ctx, q.query, q.args...)
 		return rows, errors.Wrap(q.logContext, err)
 	}
 
 	if q.stmt == nil {
-		stmt, err := conn.PrepareContext(ctx, q.config.Query)
+		stmt, err := conn.PrepareContext(ctx, q.query)
 		if err != nil {
 			return nil, errors.Wrapf(q.logContext, err, "prepare query failed")
 		}
 		q.conn = conn
 		q.stmt = stmt
 	}
-	rows, err := q.stmt.QueryContext(ctx)
+	rows, err := q.stmt.QueryContext(ctx, q.args...)
 	return rows, errors.Wrap(q.logContext, err)
 }
 
 // scanDest creates a slice to scan the provided rows into, with strings for keys, float64s for values and interface{}
-// for any extra columns.
-func (q *Query) scanDest(rows *sql.Rows) ([]any, errors.WithContext) {
+// for any extra columns. If the query populates an info metric, extra columns are scanned as strings instead and their
+// names returned, to be exposed as labels.
+func (q *Query) scanDest(rows *sql.Rows) ([]any, []string, errors.WithContext) {
 	columns, err := rows.Columns()
 	if err != nil {
-		return nil, errors.Wrap(q.logContext, err)
+		return nil, nil, errors.Wrap(q.logContext, err)
 	}
 	slog.Debug("Returned columns", "logContext", q.logContext, "columns", columns)
 	// Create the slice to scan the row into, with strings for keys and float64s for values.
 	dest := make([]any, 0, len(columns))
 	have := make(map[string]bool, len(q.columnTypes))
+	var extraLabels []string
 	for i, column := range columns {
 		switch q.columnTypes[column] {
 		case columnTypeKey:
@@ -215,6 +305,15 @@ func (q *Query) scanDest(rows *sql.Rows) ([]any, errors.WithContext) {
 			dest = append(dest, new(sql.NullTime))
 			have[column] = true
 		default:
+			if column != "" && q.extraLabels {
+				if !model.LabelName(column).IsValid() {
+					return nil, nil, errors.Errorf(q.logContext, "Invalid label name for info metric column: %q",
+						column)
+				}
+				extraLabels = append(extraLabels, column)
+				dest = append(dest, new(sql.NullString))
+				continue
+			}
 			if column == "" {
 				slog.Debug("Unnamed column", "logContext", q.logContext, "column", i)
 			} else {
@@ -232,10 +331,26 @@ func (q *Query) scanDest(rows *sql.Rows) ([]any, errors.WithContext) {
 				missing = append(missing, c)
 			}
 		}
-		return nil, errors.Errorf(q.logContext, "Missing values for the requested columns: %q", missing)
+		return nil, nil, errors.Errorf(q.logContext, "Missing values for the requested columns: %q", missing)
 	}
 
-	return dest, nil
+	return dest, extraLabels, nil
+}
+
+// withExtraLabels returns the metric families populated by the query, with the given extra columns added to the labels
+// of info metrics.
+func (q *Query) withExtraLabels(extraLabels []string) []*MetricFamily {
+	if len(extraLabels) == 0 {
+		return q.metricFamilies
+	}
+	metricFamilies := make([]*MetricFamily, len(q.metricFamilies))
+	for i, mf := range q.metricFamilies {
+		if mf.config.MetricType() == config.MetricTypeInfo {
+			mf = mf.withLabels(extraLabels)
+		}
+		metricFamilies[i] = mf
+	}
+	return metricFamilies
 }
 
 // scanRow scans the current row into a map of column name to value, with string values for key columns and float64
@@ -270,6 +385,11 @@ func (q *Query) scanRow(rows *sql.Rows, dest []any) (map[string]any, errors.With
 				slog.Debug("Value column is NULL", "logContext", q.logContext, "column", column)
 			}
 			result[column] = *dest[i].(*sql.NullFloat64)
+		default:
+			// Extra columns of info metrics, exposed as labels.
+			if label, ok := dest[i].(*sql.NullString); ok {
+				result[column] = *label
+			}
 		}
 	}
 	return result, nil
@@ -1,9 +1,16 @@
 package sql_exporter
 
 import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/burningalchemist/sql_exporter/errors"
 	dto "github.com/prometheus/client_model/go"
 )
 
@@ -64,3 +71,85 @@ func TestNewQueryAutoMetricsEnabledNoConstLabels(t *testing.T) {
 		t.Errorf("expected query=singleton, got %s=%s", gotLabels[0].GetName(), gotLabels[0].GetValue())
 	}
 }
+
+func TestTimeoutError(t *testing.T) {
+	driverErr := errors.New("collector=c1,query=q1", "canceling statement due to user request")
+
+	if got := timeoutError(context.Background(), "", driverErr); got != driverErr {
+		t.Errorf("expected the error unchanged without a deadline, got %v", got)
+	}
+	if reason := errorReason(driverErr); reason != "error" {
+		t.Errorf("reason = %q, want error", reason)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	got := timeoutError(ctx, "collector=c1,query=q1", driverErr)
+	if reason := errorReason(got); reason != "timeout" {
+		t.Errorf("reason = %q, want timeout", reason)
+	}
+	if got.Context() != "collector=c1,query=q1" {
+		t.Errorf("context = %q, want the query context", got.Context())
+	}
+
+	// A canceled (rather than timed out) scrape is not a timeout.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if reason := errorReason(timeoutError(ctx, "", driverErr)); reason != "error" {
+		t.Errorf("reason = %q, want error", reason)
+	}
+}
+
+func TestQueryInfoMetricExtraColumns(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "versions.csv"), []byte("version,build\n16.2,abc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("csvq", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mc := metricConfigFromYAML(t,
+		"{metric_name: db_version_info, type: info, help: v, key_labels: [version], query: q}")
+	mf, merr := NewMetricFamily("", mc, nil)
+	if merr != nil {
+		t.Fatal(merr)
+	}
+	q, qerr := NewQuery("", &config.QueryConfig{Name: "q", Query: "SELECT version, build FROM versions",
+		NoPreparedStatement: true}, nil, false, mf)
+	if qerr != nil {
+		t.Fatal(qerr)
+	}
+	ch := make(chan Metric, capMetricChan)
+	q.Collect(context.Background(), db, ch)
+	close(ch)
+
+	var metrics []Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	if len(metrics) != 1 || metrics[0].Desc() == nil {
+		t.Fatalf("expected a single info metric, got %d metrics", len(metrics))
+	}
+	if labels := metrics[0].Desc().Labels(); !slices.Equal(labels, []string{"version", "build"}) {
+		t.Errorf("expected the unlisted build column as label, got labels %q", labels)
+	}
+	// The metric family of the query itself is left unchanged.
+	if !slices.Equal(mf.Labels(), []string{"version"}) {
+		t.Errorf("expected the metric family labels to be unchanged, got %q", mf.Labels())
+	}
+	var m dto.Metric
+	if err := metrics[0].Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string, len(m.Label))
+	for _, lp := range m.Label {
+		got[lp.GetName()] = lp.GetValue()
+	}
+	if got["version"] != "16.2" || got["build"] != "abc" || m.GetGauge().GetValue() != 1 {
+		t.Errorf("unexpected info metric: labels %v, value %v", got, m.GetGauge().GetValue())
+	}
+}
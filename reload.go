@@ -2,108 +2,251 @@ package sql_exporter
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
+	"time"
 
 	cfg "github.com/burningalchemist/sql_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// Reload function is used to reload the exporter configuration without restarting the exporter
-func Reload(e Exporter, configFile *string) error {
-	slog.Warn("Reloading collectors has started...")
-	slog.Warn("Connections will not be changed upon the restart of the exporter")
+// ReloadResult describes the changes applied by a reload.
+type ReloadResult struct {
+	Collectors ReloadChanges `json:"collectors"`
+	Targets    ReloadChanges `json:"targets"`
+}
+
+// ReloadChanges lists the names of added, removed and changed items. Targets defined by jobs are named
+// `<job>/<target>`.
+type ReloadChanges struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// Reload function is used to reload the exporter configuration without restarting the exporter. Targets are diffed
+// against the new configuration: unchanged ones are kept along with their connection pools, prepared statements and
+// cached metrics, new or changed ones are created, and removed or changed ones are closed once their in-flight scrapes
+// have finished.
+func Reload(e Exporter, configFile *string) (*ReloadResult, error) {
+	slog.Warn("Reloading configuration has started...")
 
 	configNext, err := cfg.Load(*configFile)
 	if err != nil {
 		slog.Error("Error reading config file", "error", err)
-		return err
+		return nil, err
+	}
+	// Same as on startup, the DSN override only applies in single target mode.
+	if cfg.DsnOverride != "" {
+		if configNext.Target == nil {
+			return nil, errors.New("the config.data-source-name flag only applies in single target mode")
+		}
+		configNext.Target.DSN = cfg.Secret(cfg.DsnOverride)
 	}
 
-	configCurrent := e.Config()
+	specs, err := configTargetSpecs(configNext)
+	if err != nil {
+		slog.Error("Error reading target configuration", "error", err)
+		return nil, err
+	}
+	result := &ReloadResult{Collectors: diffCollectors(e.Config().Collectors, configNext.Collectors)}
 
-	// Clear current collectors and replace with new ones
-	if len(configCurrent.Collectors) > 0 {
-		configCurrent.Collectors = configCurrent.Collectors[:0]
+	// Halt service discovery first, so that it doesn't race with the update. It's restarted below with the new job
+	// configs and takes over the discovered targets, which are left untouched by the static target diff.
+	ex, isExporter := e.(*exporter)
+	var discovered map[string]map[string]*discoveredTarget
+	if isExporter && ex.discovery != nil {
+		discovered = ex.discovery.halt()
+		ex.discovery = nil
+	}
+	owned := ownedTargets(discovered)
+	// Same for the secret refresher, which may otherwise update targets that are being replaced.
+	if isExporter && ex.secrets != nil {
+		ex.secrets.stop()
+		ex.secrets = nil
 	}
-	configCurrent.Collectors = configNext.Collectors
-	slog.Debug("Total collector size change", "from", len(configCurrent.Collectors), "to",
-		len(configNext.Collectors))
 
-	// Reload targets
-	switch {
-	case configCurrent.Target != nil && configNext.Target != nil:
-		if err = reloadTarget(e, configNext, configCurrent); err != nil {
-			return err
+	current := make(map[string]*target, len(e.Targets()))
+	var unknown []Target
+	for _, t := range e.Targets() {
+		if _, found := owned[t]; found {
+			continue
 		}
-	case len(configCurrent.Jobs) > 0 && len(configNext.Jobs) > 0:
-		if err = reloadJobs(e, configNext, configCurrent); err != nil {
-			return err
+		if t, ok := t.(*target); ok {
+			current[targetKey(t.jobGroup, t.name)] = t
+		} else {
+			unknown = append(unknown, t)
 		}
-	case configCurrent.Target != nil && len(configNext.Jobs) > 0:
-	case len(configCurrent.Jobs) > 0 && configNext.Target != nil:
-		return errors.New("changing scrape mode is not allowed. Please restart the exporter")
-	default:
-		slog.Warn("No target or jobs have been found - nothing to reload")
 	}
-	return nil
+
+	var (
+		targets = make([]Target, 0, len(specs))
+		kept    = make(map[*target]struct{}, len(current))
+		created []Target
+	)
+	for _, spec := range specs {
+		key := targetKey(spec.jobGroup, spec.name)
+		t, found := current[key]
+		if found && t.matches(&spec, configNext.Globals) {
+			kept[t] = struct{}{}
+			targets = append(targets, t)
+			continue
+		}
+		nt, err := spec.newTarget(configNext.Globals)
+		if err != nil {
+			slog.Error("Error creating target", "target", spec.name, "job", spec.jobGroup, "error", err)
+			// Leave the current targets untouched.
+			closeTargets(created)
+			if isExporter {
+				_ = restartDiscovery(ex, discovered)
+				ex.secrets = startSecretRefresher(ex, time.Duration(ex.Config().Globals.SecretRefreshInterval))
+			}
+			return nil, err
+		}
+		if found {
+			result.Targets.Changed = append(result.Targets.Changed, key)
+		} else {
+			result.Targets.Added = append(result.Targets.Added, key)
+		}
+		created = append(created, nt)
+		targets = append(targets, nt)
+	}
+
+	removed := unknown
+	for key, t := range current {
+		if _, found := kept[t]; !found {
+			removed = append(removed, t)
+			if !slices.Contains(result.Targets.Changed, key) {
+				result.Targets.Removed = append(result.Targets.Removed, key)
+			}
+		}
+	}
+	result.Targets.normalize()
+	slog.Warn("Targets have been diffed", "kept", len(kept), "created", len(created), "closed", len(removed))
+
+	// Apply the new configuration. The push config is only read on startup and isn't reloaded.
+	configNext.Push = e.Config().Push
+	if isExporter {
+		ex.setConfig(configNext)
+		// Replace the static targets only, the discovered ones are handed over to the new discovery manager.
+		ex.replaceTargets(func(t Target) bool {
+			_, found := owned[t]
+			return !found
+		}, targets)
+	} else {
+		e.UpdateTarget(targets)
+	}
+	// Waits for in-flight scrapes of the closed targets to finish.
+	closeTargets(removed)
+
+	if isExporter {
+		// Probe targets are recreated on demand, with the new collectors and probe config.
+		ex.probes.purge()
+		ex.secrets = startSecretRefresher(ex, time.Duration(configNext.Globals.SecretRefreshInterval))
+		if err := restartDiscovery(ex, discovered); err != nil {
+			return nil, err
+		}
+	}
+	slog.Warn("Configuration has been successfully reloaded")
+	return result, nil
 }
 
-func reloadTarget(e Exporter, nc, cc *cfg.Config) error {
-	slog.Warn("Recreating target...")
+// diffCollectors returns the changes between two revisions of the collector configs.
+func diffCollectors(current, next []*cfg.CollectorConfig) ReloadChanges {
+	var changes ReloadChanges
+	byName := make(map[string]*cfg.CollectorConfig, len(current))
+	for _, cc := range current {
+		byName[cc.Name] = cc
+	}
+	for _, nc := range next {
+		cc, found := byName[nc.Name]
+		switch {
+		case !found:
+			changes.Added = append(changes.Added, nc.Name)
+		case !sameYAML(cc, nc):
+			changes.Changed = append(changes.Changed, nc.Name)
+		}
+		delete(byName, nc.Name)
+	}
+	for name := range byName {
+		changes.Removed = append(changes.Removed, name)
+	}
+	changes.normalize()
+	return changes
+}
 
-	// Intended: we want to preserve connection details from the previous config. Only collectors will be updated.
-	nc.Target.DSN = cc.Target.DSN
-	// Apply the new target configuration
-	cc.Target = nc.Target
-	// Recreate the target object
-	target, err := NewTarget("", cc.Target.Name, "", string(cc.Target.DSN),
-		cc.Target.Collectors(), nil, cc.Globals, cc.Target.EnablePing)
+// normalize sorts the names, using empty lists rather than nil so that they are encoded as such.
+func (c *ReloadChanges) normalize() {
+	for _, names := range []*[]string{&c.Added, &c.Removed, &c.Changed} {
+		if *names == nil {
+			*names = []string{}
+		}
+		slices.Sort(*names)
+	}
+}
+
+// restartDiscovery starts service discovery for the exporter's current jobs, if any, handing over the targets
+// discovered before the reload.
+func restartDiscovery(e *exporter, discovered map[string]map[string]*discoveredTarget) error {
+	c := e.Config()
+	discovery, err := startDiscovery(e, c.Jobs, c.Globals, discovered)
 	if err != nil {
-		slog.Error("Error recreating a target", "error", err)
+		// Nothing takes over the discovered targets.
+		dropDiscoveredTargets(e, discovered)
+		slog.Error("Error restarting service discovery", "error", err)
 		return err
 	}
-
-	// Close old targets before replacing — releases sql.DB pools and sql.Stmts.
-	closeTargets(e.Targets())
-	e.UpdateTarget([]Target{target})
-	slog.Warn("Collectors have been successfully updated for the target")
+	e.discovery = discovery
 	return nil
 }
 
-func reloadJobs(e Exporter, nc, cc *cfg.Config) error {
-	slog.Warn("Recreating jobs...")
-
-	// We want to preserve `static_configs`` from the previous config revision to avoid any connection changes
-	for _, currentJob := range cc.Jobs {
-		for _, newJob := range nc.Jobs {
-			if newJob.Name == currentJob.Name {
-				newJob.StaticConfigs = currentJob.StaticConfigs
-			}
+// configTargetSpecs returns the specs of all targets statically defined by the provided config.
+func configTargetSpecs(c *cfg.Config) ([]targetSpec, error) {
+	if c.Target != nil {
+		var constLabels prometheus.Labels
+		if c.Target.Name != "" {
+			constLabels = prometheus.Labels{cfg.TargetLabel: c.Target.Name}
+		}
+		refs := c.Target.SecretRefs()
+		// The DSN override replaces the DSN resolved from the secret reference, if any.
+		if cfg.DsnOverride != "" {
+			refs.DSN = ""
 		}
+		return []targetSpec{{
+			name:        c.Target.Name,
+			dsn:         string(c.Target.DSN),
+			credentials: c.Target.Credentials(),
+			auth:        c.Target.Auth,
+			tls:         c.Target.TLS,
+			proxyURL:    c.Target.ProxyURL,
+			sshTunnel:   c.Target.SSHTunnel,
+			connection:  c.Target.ConnectionConfig,
+			refs:        refs,
+			collectors:  c.Target.Collectors(),
+			constLabels: constLabels,
+			enablePing:  c.Target.EnablePing,
+		}}, nil
 	}
-	cc.Jobs = nc.Jobs
-	var updateErr error
-	targets := make([]Target, 0, len(cc.Jobs))
 
-	for _, jobConfigItem := range cc.Jobs {
-		job, err := NewJob(jobConfigItem, cc.Globals)
+	var specs []targetSpec
+	for _, jc := range c.Jobs {
+		jobSpecs, err := jobTargetSpecs(jc)
 		if err != nil {
-			updateErr = err
-			break
+			return nil, err
 		}
-		targets = append(targets, job.Targets()...)
-		slog.Debug("Recreated Job", "name", jobConfigItem.Name)
+		specs = append(specs, jobSpecs...)
 	}
+	return specs, nil
+}
 
-	if updateErr != nil {
-		slog.Error("Error recreating jobs", "error", updateErr)
-		return updateErr
+// targetKey identifies a target across reloads: `<job>/<target>` for targets defined by jobs, the target name
+// otherwise.
+func targetKey(jobGroup, name string) string {
+	if jobGroup == "" {
+		return name
 	}
-
-	// Close old targets before replacing — releases sql.DB pools and sql.Stmts.
-	closeTargets(e.Targets())
-	e.UpdateTarget(targets)
-	slog.Warn("Collectors have been successfully updated for the jobs")
-	return nil
+	return fmt.Sprintf("%s/%s", jobGroup, name)
 }
 
 // closeTargets closes each target's database connection and prepared statements, logging but not propagating errors so
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"testing"
 
 	_ "github.com/mithrandie/csvq-driver"
@@ -141,7 +142,7 @@ func runReloadCycles(t *testing.T, e Exporter, cfgFile string, numCycles int) in
 			}
 		}
 
-		if err := Reload(e, &cfgFile); err != nil {
+		if _, err := Reload(e, &cfgFile); err != nil {
 			t.Fatalf("cycle %02d Reload: %v", cycle, err)
 		}
 
@@ -180,6 +181,80 @@ func TestReloadMemoryLeak(t *testing.T) {
 	}
 }
 
+func TestReloadDiff(t *testing.T) {
+	dirs := setupCSVDirs(t, 3)
+	writeDiffConfig := func(targets ...string) string {
+		content := "jobs:\n  - job_name: test_job\n    collectors: [col]\n    static_configs:\n      - targets:\n"
+		for i, dir := range targets {
+			content += fmt.Sprintf("          target%d: csvq:%s\n", i, dir)
+		}
+		content += `collectors:
+  - collector_name: col
+    metrics:
+      - metric_name: csvq_value
+        type: gauge
+        help: test metric
+        values: [value]
+        query: SELECT value FROM metrics
+`
+		cfgFile := filepath.Join(t.TempDir(), "sql_exporter.yml")
+		if err := os.WriteFile(cfgFile, []byte(content), 0o644); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+		return cfgFile
+	}
+	targetsByName := func(e Exporter) map[string]*target {
+		m := make(map[string]*target)
+		for _, tt := range e.Targets() {
+			m[tt.(*target).name] = tt.(*target)
+		}
+		return m
+	}
+
+	e, err := NewExporter(writeDiffConfig(dirs...), prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("NewExporter: %v", err)
+	}
+	before := targetsByName(e)
+
+	// target0 is unchanged, target1 points to a new directory and target2 is removed.
+	cfgFile := writeDiffConfig(dirs[0], dirs[2])
+	result, err := Reload(e, &cfgFile)
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if !slices.Equal(result.Targets.Changed, []string{"test_job/target1"}) ||
+		!slices.Equal(result.Targets.Removed, []string{"test_job/target2"}) || len(result.Targets.Added) != 0 {
+		t.Errorf("unexpected reload result: %+v", result.Targets)
+	}
+
+	after := targetsByName(e)
+	if len(after) != 2 {
+		t.Fatalf("expected 2 targets after reload, got %d", len(after))
+	}
+	if after["target0"] != before["target0"] {
+		t.Error("unchanged target0 was recreated")
+	}
+	if after["target1"] == before["target1"] || !before["target1"].closed {
+		t.Error("changed target1 was not recreated and closed")
+	}
+	if !before["target2"].closed {
+		t.Error("removed target2 was not closed")
+	}
+
+	if mfs, _ := e.Gather(); len(mfs) == 0 {
+		t.Error("no metrics gathered after reload")
+	}
+
+	// Closing the exporter closes and removes all of its targets.
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !after["target0"].closed || !after["target1"].closed || len(e.Targets()) != 0 {
+		t.Error("expected all targets to be closed and removed")
+	}
+}
+
 func TestMain(m *testing.M) {
 	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
 	os.Exit(m.Run())
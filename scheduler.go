@@ -0,0 +1,186 @@
+package sql_exporter
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	snapshotAgeName = "collector_snapshot_age_seconds"
+	snapshotAgeHelp = "Age of the metrics served for a scheduled collector in seconds, since its last completed run"
+	collectorLabel  = "collector"
+)
+
+// scheduledCollector is a Collector that runs in the background at a fixed interval, independent of scrapes. Collect()
+// only serves the metrics of the latest completed run, along with their age. Only used when interval is non-zero.
+type scheduledCollector struct {
+	// Underlying collector, which is run on schedule.
+	rawColl *collector
+	// Convenience copy of rawColl.config.Interval.
+	interval time.Duration
+	ageDesc  MetricDesc
+
+	mu sync.RWMutex
+	// Metrics saved from the last completed run, and the time that run completed at.
+	snapshot     []Metric
+	snapshotTime time.Time
+
+	// Tracks the in-flight run, which Close cancels and waits for, so that replacing the connection pool doesn't have
+	// to wait for the run to complete.
+	runMu     sync.Mutex
+	cancelRun context.CancelFunc
+	runs      sync.WaitGroup
+}
+
+// newScheduledCollector returns a new Collector wrapping the provided raw Collector.
+func newScheduledCollector(rawColl *collector, constLabels []*dto.LabelPair) Collector {
+	return &scheduledCollector{
+		rawColl:  rawColl,
+		interval: time.Duration(rawColl.config.Interval),
+		ageDesc: NewAutomaticMetricDesc(rawColl.logContext, snapshotAgeName, snapshotAgeHelp,
+			prometheus.GaugeValue, collectorLabelPairs(constLabels, rawColl.config.Name)),
+	}
+}
+
+// Collect implements Collector. It serves the latest snapshot and never touches the database.
+func (sc *scheduledCollector) Collect(_ context.Context, _ *sql.DB, ch chan<- Metric) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	// Nothing to serve until the first run completes.
+	if sc.snapshotTime.IsZero() {
+		slog.Debug("No snapshot yet for scheduled collector", "logContext", sc.rawColl.logContext)
+		return
+	}
+	for _, metric := range sc.snapshot {
+		ch <- metric
+	}
+	ch <- NewMetric(sc.ageDesc, time.Since(sc.snapshotTime).Seconds())
+}
+
+// Close implements Collector. It cancels the in-flight run, if any, and waits for it to return.
+func (sc *scheduledCollector) Close() error {
+	sc.runMu.Lock()
+	if sc.cancelRun != nil {
+		sc.cancelRun()
+	}
+	sc.runMu.Unlock()
+	sc.runs.Wait()
+	return sc.rawColl.Close()
+}
+
+// beginRun registers a run, to be canceled by Close, and returns its context and a function to call once it returns.
+// Must be called with the target's scrapeMu held, so that Close can't release the connection pool in between.
+func (sc *scheduledCollector) beginRun(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	sc.runMu.Lock()
+	sc.cancelRun = cancel
+	sc.runs.Add(1)
+	sc.runMu.Unlock()
+	return ctx, func() {
+		cancel()
+		sc.runs.Done()
+	}
+}
+
+// refresh runs the underlying collector and replaces the snapshot with its results.
+func (sc *scheduledCollector) refresh(ctx context.Context, conn *sql.DB) {
+	start := time.Now()
+	ch := make(chan Metric, capMetricChan)
+	go func() {
+		sc.rawColl.Collect(ctx, conn, ch)
+		close(ch)
+	}()
+
+	snapshot := make([]Metric, 0, len(sc.snapshot))
+	for metric := range ch {
+		snapshot = append(snapshot, metric)
+	}
+	// Keep serving the previous snapshot if the run timed out or was canceled, its age reflects the staleness.
+	if ctx.Err() != nil {
+		slog.Warn("Scheduled collector run did not complete, keeping previous snapshot", "logContext",
+			sc.rawColl.logContext, "error", ctx.Err())
+		return
+	}
+
+	sc.mu.Lock()
+	sc.snapshot = snapshot
+	sc.snapshotTime = time.Now()
+	sc.mu.Unlock()
+	slog.Debug("Scheduled collector run completed", "logContext", sc.rawColl.logContext, "duration",
+		time.Since(start).Seconds(), "metrics", len(snapshot))
+}
+
+// startScheduler starts a background runner for each scheduled collector of the target. Runners are staggered by
+// global.warmup_delay to avoid hitting the database with all of them at once. Stopped by stopScheduler().
+func (t *target) startScheduler() {
+	var scheduled []*scheduledCollector
+	for _, c := range t.collectors {
+		if sc, ok := c.(*scheduledCollector); ok {
+			scheduled = append(scheduled, sc)
+		}
+	}
+	if len(scheduled) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.stopSchedule = cancel
+	delay := time.Duration(t.globalConfig.WarmupDelay)
+	for i, sc := range scheduled {
+		t.scheduleWG.Go(func() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(i) * delay):
+			}
+			t.runScheduled(ctx, sc)
+		})
+	}
+	slog.Debug("Started collector scheduler", "logContext", t.logContext, "collectors", len(scheduled))
+}
+
+// stopScheduler stops all background runners of the target and waits for in-flight runs to return.
+func (t *target) stopScheduler() {
+	if t.stopSchedule == nil {
+		return
+	}
+	t.stopSchedule()
+	t.scheduleWG.Wait()
+}
+
+// runScheduled runs the scheduled collector immediately and then on every tick, until the context is closed. Each run
+// is bounded by the collector interval, so runs never overlap. The target's scrapeMu is only held to ping the target
+// and pick up its connection pool: replacing or closing the pool cancels the run instead of waiting for it.
+func (t *target) runScheduled(ctx context.Context, sc *scheduledCollector) {
+	ticker := time.NewTicker(sc.interval)
+	defer ticker.Stop()
+
+	for {
+		runCtx, cancel := context.WithTimeout(ctx, sc.interval)
+		t.scrapeMu.RLock()
+		err := t.ping(runCtx)
+		conn := t.conn
+		runCtx, done := sc.beginRun(runCtx)
+		t.scrapeMu.RUnlock()
+		if err != nil {
+			slog.Warn("Scheduled collector skipped, ping failed", "logContext", sc.rawColl.logContext, "error", err)
+		} else {
+			sc.refresh(withQueryLimiter(runCtx, t.queries), conn)
+		}
+		done()
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package sql_exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+func TestScheduledCollectorServesSnapshot(t *testing.T) {
+	cc := &config.CollectorConfig{Name: "slow", Interval: 60e9}
+	targetName, targetVal := "target", "db1"
+	constLabels := []*dto.LabelPair{{Name: &targetName, Value: &targetVal}}
+	sc := newScheduledCollector(&collector{config: cc, logContext: "collector=slow"}, constLabels).(*scheduledCollector)
+
+	collect := func() []Metric {
+		ch := make(chan Metric, capMetricChan)
+		sc.Collect(context.Background(), nil, ch)
+		close(ch)
+		var out []Metric
+		for m := range ch {
+			out = append(out, m)
+		}
+		return out
+	}
+
+	if got := collect(); len(got) != 0 {
+		t.Fatalf("expected no metrics before the first run, got %d", len(got))
+	}
+
+	sc.refresh(context.Background(), nil)
+	got := collect()
+	if len(got) != 1 {
+		t.Fatalf("expected only the snapshot age metric, got %d metrics", len(got))
+	}
+	if name := got[0].Desc().Name(); name != snapshotAgeName {
+		t.Fatalf("metric name = %q, want %q", name, snapshotAgeName)
+	}
+	labels := make(map[string]string)
+	for _, lp := range got[0].Desc().ConstLabels() {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+	if labels[collectorLabel] != "slow" || labels["target"] != "db1" {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+
+	// A canceled run keeps the previous snapshot.
+	before := sc.snapshotTime
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sc.refresh(ctx, nil)
+	if !sc.snapshotTime.Equal(before) {
+		t.Error("expected a canceled run not to replace the snapshot")
+	}
+}
+
+func TestScheduledCollectorCloseCancelsRun(t *testing.T) {
+	cc := &config.CollectorConfig{Name: "slow", Interval: 60e9}
+	sc := newScheduledCollector(&collector{config: cc, logContext: "collector=slow"}, nil).(*scheduledCollector)
+
+	runCtx, done := sc.beginRun(context.Background())
+	closed := make(chan struct{})
+	go func() {
+		sc.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-runCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to cancel the in-flight run")
+	}
+	select {
+	case <-closed:
+		t.Fatal("expected Close to wait for the in-flight run to return")
+	case <-time.After(10 * time.Millisecond):
+	}
+	done()
+	<-closed
+}
+
+func TestSchedulerStartedBySpec(t *testing.T) {
+	ccs := []*config.CollectorConfig{{Name: "slow", Interval: model.Duration(time.Hour)}}
+	gc := &config.GlobalConfig{}
+
+	// Not started by NewTarget, before the target is fully set up.
+	bare, err := NewTarget("", "db1", "", "csvq:"+t.TempDir(), ccs, nil, gc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bare.Close()
+	if bare.(*target).stopSchedule != nil {
+		t.Error("expected no scheduler for a target created with NewTarget")
+	}
+
+	spec := targetSpec{name: "db2", jobGroup: "job", dsn: "csvq:" + t.TempDir(), collectors: ccs}
+	static, err := spec.newTarget(gc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer static.Close()
+	if static.(*target).stopSchedule == nil {
+		t.Error("expected a scheduler for a job target")
+	}
+}
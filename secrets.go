@@ -0,0 +1,120 @@
+package sql_exporter
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// secretLeaseTTLDesc describes the remaining time to live of the leases of dynamic credentials.
+var secretLeaseTTLDesc = prometheus.NewDesc("secret_lease_ttl_seconds",
+	"Remaining time to live of the lease of dynamic credentials, until renewed", []string{"secret"}, nil)
+
+// secretRefresher re-resolves the secret references of the exporter's targets and applies changed data source names,
+// so that rotated credentials are picked up without a restart: periodically if an interval is set, and whenever the
+// lease of dynamic credentials is about to expire.
+type secretRefresher struct {
+	exporter *exporter
+	interval time.Duration
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// startSecretRefresher starts re-resolving secret references in the background. Periodic re-resolution is disabled if
+// the interval is zero.
+func startSecretRefresher(e *exporter, interval time.Duration) *secretRefresher {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &secretRefresher{exporter: e, interval: interval, cancel: cancel}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.run(ctx)
+	}()
+	return r
+}
+
+// stop stops the refresher and waits for an in-flight refresh to return.
+func (r *secretRefresher) stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// run refreshes on every tick and lease expiration, until the context is closed.
+func (r *secretRefresher) run(ctx context.Context) {
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		expired := config.SecretLeaseExpired()
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			r.refresh(ctx, false)
+		case <-expired:
+			r.refresh(ctx, true)
+		}
+	}
+}
+
+// refresh re-resolves the secret references of all targets (or only of those using dynamic credentials), fetching
+// each secret once, and applies the resulting data source names. Targets are kept as is if resolution fails.
+func (r *secretRefresher) refresh(ctx context.Context, dynamicOnly bool) {
+	var (
+		targets     []*target
+		generations []uint64
+		refs        []string
+	)
+	for _, t := range r.exporter.Targets() {
+		t, ok := t.(*target)
+		if !ok || t.refs.IsZero() || (dynamicOnly && !slices.ContainsFunc(t.secretRefs(), config.IsDynamicSecret)) {
+			continue
+		}
+		targets = append(targets, t)
+		generations = append(generations, t.secretGeneration.Load())
+		refs = append(refs, t.secretRefs()...)
+	}
+	if len(refs) == 0 {
+		return
+	}
+
+	start := time.Now()
+	resolved, err := config.ResolveSecrets(ctx, refs)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Error re-resolving secrets, keeping current data source names", "error", err)
+		}
+		return
+	}
+	for i, t := range targets {
+		// Skipped if the target re-resolved its secrets in the meantime, e.g. after an authentication error.
+		t.applySecretsSince(resolved, generations[i])
+	}
+	// The targets have switched to the new credentials, the leases superseded before they were resolved are unused.
+	config.RevokeSupersededSecretLeases(ctx, start)
+	slog.Debug("Re-resolved secrets", "targets", len(targets))
+}
+
+// secretLeaseCollector exports the remaining time to live of the leases of dynamic credentials.
+type secretLeaseCollector struct{}
+
+// Describe implements prometheus.Collector.
+func (secretLeaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- secretLeaseTTLDesc
+}
+
+// Collect implements prometheus.Collector.
+func (secretLeaseCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, lease := range config.SecretLeases() {
+		ch <- prometheus.MustNewConstMetric(secretLeaseTTLDesc, prometheus.GaugeValue,
+			max(time.Until(lease.ExpiresAt).Seconds(), 0), lease.Path)
+	}
+}
@@ -9,14 +9,31 @@ import (
 	"log/slog"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/burningalchemist/sql_exporter/config"
 	"github.com/xo/dburl"
 )
 
 // OpenConnection parses a provided DSN, and opens a DB handle ensuring early termination if the context is closed
 // (this is actually prevented by `database/sql` implementation), sets connection limits and returns the handle.
 func OpenConnection(ctx context.Context, logContext, dsn string, maxConns, maxIdleConns int, maxConnLifetime time.Duration) (*sql.DB, error) {
+	return openConnection(ctx, logContext, dsn, connectionOptions{}, maxConns, maxIdleConns, maxConnLifetime)
+}
+
+// connectionOptions are the target specific options a connection pool is opened with, in addition to the DSN.
+type connectionOptions struct {
+	credentials config.Credentials // merged into the DSN, if set
+	auth        *config.AuthConfig // token based authentication, if any
+	tls         *config.TLSConfig  // TLS configuration, if any
+	dialer      dialer             // proxy or SSH tunnel to connect through, if any
+}
+
+// openConnection is OpenConnection, applying the provided options.
+func openConnection(ctx context.Context, logContext, dsn string, opts connectionOptions, maxConns, maxIdleConns int,
+	maxConnLifetime time.Duration,
+) (*sql.DB, error) {
 	var (
 		url  *dburl.URL
 		conn *sql.DB
@@ -28,6 +45,9 @@ func OpenConnection(ctx context.Context, logContext, dsn string, maxConns, maxId
 	if err != nil {
 		return nil, err
 	}
+	if url, err = withCredentials(url, opts.credentials); err != nil {
+		return nil, err
+	}
 
 	driver := url.Driver
 	if url.GoDriver != "" {
@@ -37,10 +57,11 @@ func OpenConnection(ctx context.Context, logContext, dsn string, maxConns, maxId
 	// Register custom TLS config for MySQL if needed
 	if driver == "mysql" && url.Query().Get("tls") == "custom" {
 
-		// Encode the hostname and port to create a unique name for the TLS configuration. This ensures that different
-		// DSNs with the same TLS parameters will reuse the same TLS configuration.
+		// Encode the target and its hostname and port to create a unique name for the TLS configuration. This ensures
+		// that the target's new connection pools (e.g. after a reload) replace its TLS configuration rather than adding
+		// one.
 		configName := "custom-" + base64.URLEncoding.WithPadding(base64.NoPadding).
-			EncodeToString([]byte(url.Hostname()+url.Port()))
+			EncodeToString([]byte(logContext+"@"+url.Hostname()+url.Port()))
 
 		if err := handleMySQLTLSConfig(configName, url.Query()); err != nil {
 			return nil,
@@ -68,8 +89,8 @@ func OpenConnection(ctx context.Context, logContext, dsn string, maxConns, maxId
 
 	// Open the DB handle in a separate goroutine so we can terminate early if the context closes.
 	go func() {
-		conn, err = sql.Open(driver, url.DSN)
-		close(ch)
+		defer close(ch)
+		conn, err = openDB(ctx, logContext, driver, url, opts)
 	}()
 
 	select {
@@ -89,6 +110,36 @@ func OpenConnection(ctx context.Context, logContext, dsn string, maxConns, maxId
 	return conn, nil
 }
 
+// openDB opens a DB handle for the URL, using a connector if the options require one.
+func openDB(ctx context.Context, logContext, driverName string, u *dburl.URL, opts connectionOptions) (*sql.DB, error) {
+	if opts.auth == nil && opts.tls == nil && opts.dialer == nil {
+		return sql.Open(driverName, u.DSN)
+	}
+
+	open, err := newConnectorFunc(driverName, opts, u.Hostname(), logContext)
+	if err != nil {
+		return nil, err
+	}
+	if opts.auth == nil {
+		connector, err := open(u.DSN)
+		if err != nil {
+			return nil, err
+		}
+		return sql.OpenDB(connector), nil
+	}
+
+	// Each new connection gets a fresh token.
+	source, err := newTokenSource(ctx, opts.auth)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := newTokenConnector(u, source, open)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
 // PingDB is a wrapper around sql.DB.PingContext() that terminates as soon as the context is closed.
 //
 // sql.DB does not actually pass along the context to the driver when opening a connection (which always happens if the
@@ -110,6 +161,41 @@ func PingDB(ctx context.Context, conn *sql.DB) error {
 	}
 }
 
+// authErrorMessages are lowercase substrings of the authentication errors returned by the drivers that don't expose
+// typed errors.
+var authErrorMessages = []string{
+	"ora-01017",                      // Oracle invalid username/password
+	"incorrect username or password", // Snowflake
+}
+
+// authErrorMatchers return whether an error is the database rejecting the credentials, going by the driver's typed
+// errors. Registered by the files of the drivers built in, so that excluding a driver doesn't link it anyway.
+var authErrorMatchers []func(err error) bool
+
+// isAuthError returns whether err is the database rejecting the credentials, going by the driver's error code.
+func isAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, match := range authErrorMatchers {
+		if match(err) {
+			return true
+		}
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range authErrorMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPostgresAuthCode returns whether the SQLSTATE is invalid_password or invalid_authorization_specification.
+func isPostgresAuthCode(code string) bool {
+	return code == "28P01" || code == "28000"
+}
+
 // safeParse wraps dburl.Parse method in order to prevent leaking credentials
 // if underlying url parse failed. By default it returns a raw url string in error message,
 // which most likely contains a password. It's undesired here.
@@ -124,6 +210,33 @@ func safeParse(rawURL string) (*dburl.URL, error) {
 	return parsed, nil
 }
 
+// withCredentials returns the parsed DSN with its username and password replaced by the provided ones, if set. The
+// credentials aren't subject to environment variable expansion.
+func withCredentials(u *dburl.URL, creds config.Credentials) (*dburl.URL, error) {
+	if creds == (config.Credentials{}) {
+		return u, nil
+	}
+	username, password := string(creds.Username), string(creds.Password)
+	if username == "" {
+		username = u.User.Username()
+	}
+	if password == "" {
+		password, _ = u.User.Password()
+	}
+	merged := *u
+	if password == "" {
+		merged.User = url.User(username)
+	} else {
+		merged.User = url.UserPassword(username, password)
+	}
+	// Parse again to regenerate the driver specific DSN.
+	parsed, err := dburl.Parse(merged.String())
+	if err != nil {
+		return nil, errors.New("invalid URL with credentials")
+	}
+	return parsed, nil
+}
+
 // expandEnv falls back to the original env variable if not found for better readability
 func expandEnv(env string) string {
 	lookupFunc := func(env string) string {
@@ -1,25 +1,35 @@
 package sql_exporter
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
 	"log/slog"
+	"maps"
+	"reflect"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/burningalchemist/sql_exporter/config"
 	"github.com/burningalchemist/sql_exporter/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
+	"go.yaml.in/yaml/v3"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
 	// Capacity for the channel to collect metrics.
 	capMetricChan = 1000
 
+	// Minimum time between secret re-resolutions triggered by authentication errors.
+	authRefreshBackoff = 30 * time.Second
+
 	upMetricName       = "up"
 	upMetricHelp       = "1 if the target is reachable, or 0 if the scrape failed"
 	scrapeDurationName = "scrape_duration_seconds"
@@ -40,12 +50,14 @@ type Target interface {
 	JobGroup() string
 }
 
-// target implements Target. It wraps a sql.DB, which is initially nil but never changes once instantianted.
+// target implements Target. It wraps a sql.DB, which is initially nil and only replaced when the data source name
+// changes, e.g. after a credential rotation.
 type target struct {
 	name               string
 	jobGroup           string
 	dsn                string
 	collectors         []Collector
+	collectorConfigs   []*config.CollectorConfig
 	constLabels        prometheus.Labels
 	globalConfig       *config.GlobalConfig
 	upDesc             MetricDesc
@@ -53,12 +65,51 @@ type target struct {
 	logContext         string
 	enablePing         *bool
 
+	// Held for reading by scrapes and for writing by Close, so that the target is only closed once in-flight scrapes
+	// have finished.
+	scrapeMu sync.RWMutex
+	closed   bool
+
+	// Credentials merged into the DSN, token based authentication and TLS configuration, if any.
+	credentials config.Credentials
+	auth        *config.AuthConfig
+	tls         *config.TLSConfig
+	// Proxy or SSH tunnel to connect through, if any, and the corresponding dialer.
+	proxyURL  string
+	sshTunnel *config.SSHTunnelConfig
+	dialer    dialer
+	// Secret references the DSN and credentials were resolved from, if any, and when they were last re-resolved after
+	// an authentication error (as Unix nanoseconds).
+	refs              config.SecretRefs
+	lastAuthRefreshNs atomic.Int64
+	// Deduplicates concurrent re-resolutions of the secret references. secretMu serializes applying them (after
+	// authentication errors and by the secret refresher), which are counted so that secrets resolved before a more
+	// recent re-resolution aren't applied.
+	secretFlight     singleflight.Group
+	secretMu         sync.Mutex
+	secretGeneration atomic.Uint64
+
 	mu   sync.RWMutex
 	conn *sql.DB
+	// Same as conn, for reading the pool statistics without waiting for the lock, held while connecting.
+	pool atomic.Pointer[sql.DB]
 	// Last successful ping time
 	lastPingTime time.Time
 	// Ping interval - only ping if last ping was more than this duration ago
 	pingInterval time.Duration
+
+	// Limits the queries running at once on the target (to job.max_concurrent_queries) and across all targets.
+	maxConcurrentQueries int
+	queries              queryLimiter
+
+	// Keeps the target from connecting again right after failing to, nil if disabled.
+	breaker          *circuitBreaker
+	breakerStateDesc MetricDesc
+	breakerRetryDesc MetricDesc
+
+	// Stops the background runners of scheduled collectors, nil if there are none.
+	stopSchedule context.CancelFunc
+	scheduleWG   sync.WaitGroup
 }
 
 // NewTarget returns a new Target with the given target name, data source name, collectors and constant labels.
@@ -102,7 +153,11 @@ func NewTarget(
 		prometheus.GaugeValue, constLabelPairs)
 	scrapeDurationDesc := NewAutomaticMetricDesc(logContext, scrapeDurationName, scrapeDurationHelp,
 		prometheus.GaugeValue, constLabelPairs)
-	// Use ping interval from global config, default is 0
+	breakerStateDesc := NewAutomaticMetricDesc(logContext, breakerStateName, breakerStateHelp,
+		prometheus.GaugeValue, constLabelPairs)
+	breakerRetryDesc := NewAutomaticMetricDesc(logContext, breakerRetryName, breakerRetryHelp,
+		prometheus.GaugeValue, constLabelPairs)
+	// Use ping interval from global config (as overridden for the target), default is 0
 	pingInterval := time.Duration(gc.PingInterval)
 
 	t := target{
@@ -110,6 +165,7 @@ func NewTarget(
 		jobGroup:           jg,
 		dsn:                dsn,
 		collectors:         collectors,
+		collectorConfigs:   ccs,
 		constLabels:        constLabels,
 		globalConfig:       gc,
 		upDesc:             upDesc,
@@ -117,6 +173,10 @@ func NewTarget(
 		logContext:         logContext,
 		enablePing:         ep,
 		pingInterval:       pingInterval,
+		queries:            newQueryLimiter(0, gc.MaxConcurrentQueries),
+		breaker:            newCircuitBreaker(gc.CircuitBreaker),
+		breakerStateDesc:   breakerStateDesc,
+		breakerRetryDesc:   breakerRetryDesc,
 	}
 	return &t, nil
 }
@@ -128,6 +188,20 @@ func (t *target) Collect(ctx context.Context, ch chan<- Metric) {
 		targetUp    = true
 	)
 
+	t.scrapeMu.RLock()
+	defer t.scrapeMu.RUnlock()
+	// The target was replaced or removed by a reload after the scrape started.
+	if t.closed {
+		return
+	}
+	// The scrape timeout may be overridden for the target, the request's context only limits it to the longest one.
+	if timeout := time.Duration(t.globalConfig.ScrapeTimeout); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx = withQueryLimiter(ctx, t.queries)
+
 	err := t.ping(ctx)
 	if err != nil {
 		ch <- NewInvalidMetric(errors.Wrap(t.logContext, err))
@@ -136,6 +210,15 @@ func (t *target) Collect(ctx context.Context, ch chan<- Metric) {
 	if t.name != "" {
 		// Export the target's `up` metric as early as we know what it should be.
 		ch <- NewMetric(t.upDesc, boolToFloat64(targetUp))
+		if t.breaker != nil {
+			state, nextRetry := t.breaker.status()
+			retry := 0.0
+			if state == breakerOpen {
+				retry = float64(nextRetry.UnixMilli()) / 1e3
+			}
+			ch <- NewMetric(t.breakerStateDesc, float64(state))
+			ch <- NewMetric(t.breakerRetryDesc, retry)
+		}
 	}
 
 	var wg sync.WaitGroup
@@ -165,6 +248,14 @@ func (t *target) Collect(ctx context.Context, ch chan<- Metric) {
 func (t *target) Close() error {
 	var errs []error
 
+	// Stop scheduled collectors first, they may be waiting for the lock below.
+	t.stopScheduler()
+
+	// Wait for in-flight scrapes to finish and prevent new ones from reopening the connection.
+	t.scrapeMu.Lock()
+	defer t.scrapeMu.Unlock()
+	t.closed = true
+
 	// We need to lock here because if the target is being closed while a scrape is starting, they might both try to close the connection at the same time. Once we have a handle, sql.DB takes care of concurrency for us.
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -182,6 +273,12 @@ func (t *target) Close() error {
 			errs = append(errs, err)
 		}
 		t.conn = nil
+		t.pool.Store(nil)
+	}
+	if tunnel, ok := t.dialer.(*sshTunnel); ok {
+		if err := tunnel.Close(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 	if len(errs) > 0 {
 		return fmt.Errorf("target %s close errors: %v", t.logContext, errs)
@@ -189,7 +286,7 @@ func (t *target) Close() error {
 	return nil
 }
 
-func (t *target) ping(ctx context.Context) errors.WithContext {
+func (t *target) ping(ctx context.Context) (err errors.WithContext) {
 	// Create the DB handle, if necessary. It won't usually open an actual connection, so we'll need to ping
 	// afterwards. We cannot do this only once at creation time because the sql.Open() documentation says it "may" open
 	// an actual connection, so it "may" actually fail to open a handle to a DB that's initially down.
@@ -198,9 +295,23 @@ func (t *target) ping(ctx context.Context) errors.WithContext {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	// Don't try again until the circuit breaker's backoff has passed.
+	if ok, retry := t.breaker.allow(time.Now()); !ok {
+		return errors.Errorf(t.logContext, "circuit breaker open, next retry at %s", retry.Format(time.RFC3339))
+	}
+	defer func() { t.breaker.record(err, time.Now()) }()
+
+	// The target is down if its SSH tunnel is.
+	if tunnel, ok := t.dialer.(*sshTunnel); ok {
+		if err := tunnel.check(ctx); err != nil {
+			return errors.Wrap(t.logContext, err)
+		}
+	}
+
 	if t.conn == nil {
-		conn, err := OpenConnection(ctx, t.logContext, t.dsn, t.globalConfig.MaxConns,
-			t.globalConfig.MaxIdleConns, t.globalConfig.MaxConnLifetime)
+		conn, err := openConnection(ctx, t.logContext, t.dsn,
+			connectionOptions{credentials: t.credentials, auth: t.auth, tls: t.tls, dialer: t.dialer},
+			t.globalConfig.MaxConns, t.globalConfig.MaxIdleConns, t.globalConfig.MaxConnLifetime)
 		if err != nil {
 			if err != ctx.Err() {
 				return errors.Wrap(t.logContext, err)
@@ -208,6 +319,7 @@ func (t *target) ping(ctx context.Context) errors.WithContext {
 			// if err == ctx.Err() fall through
 		} else {
 			t.conn = conn
+			t.pool.Store(conn)
 			// Force ping on first connection
 			var err error
 			for i := 0; i <= t.globalConfig.MaxConns; i++ {
@@ -216,6 +328,7 @@ func (t *target) ping(ctx context.Context) errors.WithContext {
 				}
 			}
 			if err != nil {
+				t.onPingError(err)
 				return errors.Wrap(t.logContext, err)
 			}
 			t.lastPingTime = time.Now()
@@ -238,6 +351,7 @@ func (t *target) ping(ctx context.Context) errors.WithContext {
 				}
 			}
 			if err != nil {
+				t.onPingError(err)
 				return errors.Wrap(t.logContext, err)
 			}
 			t.lastPingTime = now
@@ -268,3 +382,142 @@ func OfBool(i bool) *bool {
 func (t *target) JobGroup() string {
 	return t.jobGroup
 }
+
+// setDSN replaces the target's data source name and credentials, e.g. after a credential rotation. The current
+// connection pool and prepared statements are closed once in-flight scrapes have finished, the next scrape opens a new
+// pool with the new data source name. Cached metrics are kept.
+func (t *target) setDSN(dsn string, creds config.Credentials) {
+	t.scrapeMu.Lock()
+	defer t.scrapeMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed || (t.dsn == dsn && t.credentials == creds) {
+		return
+	}
+	for _, c := range t.collectors {
+		if err := c.Close(); err != nil {
+			slog.Warn("Error closing collector", "logContext", t.logContext, "error", err)
+		}
+	}
+	if t.conn != nil {
+		if err := t.conn.Close(); err != nil {
+			slog.Warn("Error closing connection pool", "logContext", t.logContext, "error", err)
+		}
+		t.conn = nil
+		t.pool.Store(nil)
+	}
+	t.dsn, t.credentials = dsn, creds
+	slog.Warn("Data source name changed, connection pool replaced", "logContext", t.logContext)
+}
+
+// secretRefs returns the secret references the target's data source name and credentials were resolved from.
+func (t *target) secretRefs() []string {
+	var refs []string
+	for _, ref := range []string{t.refs.DSN, t.refs.Username, t.refs.Password} {
+		if ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// applySecretsSince sets the target's data source name and credentials from the provided resolved secret references,
+// unless they were re-resolved since the provided generation, in which case the resolved ones may be stale.
+func (t *target) applySecretsSince(resolved map[string]string, generation uint64) {
+	t.secretMu.Lock()
+	defer t.secretMu.Unlock()
+	if t.secretGeneration.Load() == generation {
+		t.applySecrets(resolved)
+	}
+}
+
+// applySecrets sets the target's data source name and credentials from the provided resolved secret references. Must
+// be called with secretMu held.
+func (t *target) applySecrets(resolved map[string]string) {
+	t.mu.RLock()
+	dsn, creds := t.dsn, t.credentials
+	t.mu.RUnlock()
+
+	if t.refs.DSN != "" {
+		dsn = resolved[t.refs.DSN]
+	}
+	if t.refs.Username != "" {
+		creds.Username = config.Secret(resolved[t.refs.Username])
+	}
+	if t.refs.Password != "" {
+		creds.Password = config.Secret(resolved[t.refs.Password])
+	}
+	t.setDSN(dsn, creds)
+	t.secretGeneration.Add(1)
+}
+
+// refreshSecret re-resolves the target's secret references, if any, and applies the resulting data source name and
+// credentials. Concurrent re-resolutions of the same target share the outcome of the one in flight.
+func (t *target) refreshSecret(ctx context.Context) error {
+	if t.refs.IsZero() {
+		return nil
+	}
+	_, err, _ := t.secretFlight.Do("", func() (any, error) {
+		// Resolved under the lock, so that no secrets resolved earlier are applied afterwards.
+		t.secretMu.Lock()
+		defer t.secretMu.Unlock()
+		resolved, err := config.ResolveSecrets(ctx, t.secretRefs())
+		if err != nil {
+			return nil, err
+		}
+		t.applySecrets(resolved)
+		return nil, nil
+	})
+	return err
+}
+
+// onPingError re-resolves the target's secret references in the background if the error is an authentication error,
+// as the credentials may have been rotated. Re-resolutions are at least authRefreshBackoff apart.
+func (t *target) onPingError(err error) {
+	if t.refs.IsZero() || !isAuthError(err) {
+		return
+	}
+	last := t.lastAuthRefreshNs.Load()
+	now := time.Now().UnixNano()
+	if now-last < int64(authRefreshBackoff) || !t.lastAuthRefreshNs.CompareAndSwap(last, now) {
+		return
+	}
+
+	slog.Warn("Authentication failed, re-resolving secrets", "logContext", t.logContext)
+	// Dynamic credentials may have been revoked, request new ones rather than reusing the leased ones.
+	config.InvalidateSecrets(t.secretRefs())
+	// Not waiting: the new data source name is applied once in-flight scrapes, including this one, have finished.
+	go func() {
+		if err := t.refreshSecret(context.Background()); err != nil {
+			slog.Error("Error re-resolving secrets", "logContext", t.logContext, "error", err)
+		}
+	}()
+}
+
+// matches returns whether the target would be created the same from the provided spec and global config, i.e. it
+// can be kept as is, along with its connection pool, prepared statements and cached metrics.
+func (t *target) matches(spec *targetSpec, gc *config.GlobalConfig) bool {
+	if t.name != spec.name || t.jobGroup != spec.jobGroup || t.dsn != spec.dsn || t.credentials != spec.credentials ||
+		t.refs != spec.refs || t.maxConcurrentQueries != spec.maxConcurrentQueries || t.proxyURL != spec.proxyURL ||
+		!reflect.DeepEqual(t.sshTunnel, spec.sshTunnel) || !maps.Equal(t.constLabels, spec.constLabels) ||
+		*t.enablePing != *cmp.Or(spec.enablePing, &config.EnablePing) {
+		return false
+	}
+	return sameYAML(t.globalConfig, spec.connection.Apply(gc)) && sameYAML(t.collectorConfigs, spec.collectors) &&
+		sameYAML(t.auth, spec.auth) && sameYAML(t.tls, spec.tls)
+}
+
+// sameYAML returns whether a and b marshal to the same YAML, as a way of comparing configs, which hold parsed templates
+// and resolved references.
+func sameYAML(a, b any) bool {
+	ya, err := yaml.Marshal(a)
+	if err != nil {
+		return false
+	}
+	yb, err := yaml.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ya, yb)
+}
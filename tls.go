@@ -0,0 +1,162 @@
+package sql_exporter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+)
+
+// newTLSConfig returns a client TLS config built from tc. The CA and client certificate files are read again when
+// modified, so that rotated certificates are used by new connections without reloading the exporter.
+func newTLSConfig(tc *config.TLSConfig, host string) (*tls.Config, error) {
+	forHost, err := newTLSConfigFunc(tc)
+	if err != nil {
+		return nil, err
+	}
+	return forHost(host), nil
+}
+
+// newTLSConfigFunc returns a function returning client TLS configs built from tc, verifying the server certificate
+// against the provided host unless tc sets a server name, for drivers connecting to one of several hosts. The configs
+// share the certificate files.
+func newTLSConfigFunc(tc *config.TLSConfig) (func(host string) *tls.Config, error) {
+	files := &tlsFiles{caFile: tc.CAFile, certFile: tc.CertFile, keyFile: tc.KeyFile}
+	// Fail early on missing or invalid files.
+	if _, _, err := files.current(); err != nil {
+		return nil, err
+	}
+
+	return func(host string) *tls.Config {
+		serverName := host
+		if tc.ServerName != "" {
+			serverName = tc.ServerName
+		}
+		tlsConfig := &tls.Config{
+			ServerName: serverName,
+			MinVersion: config.TLSVersions[tc.MinVersion],
+			// The server certificate is verified by VerifyConnection instead, against the current CA certificates and
+			// the server name.
+			InsecureSkipVerify: true,
+		}
+		if tc.CertFile != "" {
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				_, cert, err := files.current()
+				return cert, err
+			}
+		}
+		if !tc.InsecureSkipVerify {
+			tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+				// Drivers falling back to other hosts with the same config (e.g. lib/pq) set the name of the host
+				// they're connecting to in their copy of it, unless it's an IP address.
+				if tc.ServerName == "" && cs.ServerName != "" {
+					return files.verify(cs, cs.ServerName)
+				}
+				return files.verify(cs, serverName)
+			}
+		}
+		return tlsConfig
+	}, nil
+}
+
+// tlsFiles holds the CA certificates and client certificate read from files, read again when the files are modified.
+type tlsFiles struct {
+	caFile, certFile, keyFile string
+
+	mu          sync.Mutex
+	loaded      bool
+	caModTime   time.Time
+	certModTime time.Time
+	roots       *x509.CertPool   // nil for the system's
+	cert        *tls.Certificate // nil if no client certificate
+}
+
+// current returns the CA certificates and client certificate, reading the files again if they were modified. The
+// previous ones are kept if reading fails, e.g. if a certificate was rotated but not yet its key.
+func (f *tlsFiles) current() (*x509.CertPool, *tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.reload(); err != nil {
+		if !f.loaded {
+			return nil, nil, err
+		}
+		slog.Warn("Unable to reload TLS certificates, using the previous ones", "error", err)
+	}
+	f.loaded = true
+	return f.roots, f.cert, nil
+}
+
+// reload reads the files modified since last read. Must be called with the lock held.
+func (f *tlsFiles) reload() error {
+	if f.caFile != "" {
+		modTime, err := latestModTime(f.caFile)
+		if err != nil {
+			return err
+		}
+		if !modTime.Equal(f.caModTime) {
+			pem, err := os.ReadFile(f.caFile)
+			if err != nil {
+				return fmt.Errorf("unable to read CA certificates: %w", err)
+			}
+			roots := x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no CA certificates found in %q", f.caFile)
+			}
+			f.roots, f.caModTime = roots, modTime
+		}
+	}
+
+	if f.certFile != "" {
+		modTime, err := latestModTime(f.certFile, f.keyFile)
+		if err != nil {
+			return err
+		}
+		if !modTime.Equal(f.certModTime) {
+			cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+			if err != nil {
+				return fmt.Errorf("unable to load client certificate and key: %w", err)
+			}
+			f.cert, f.certModTime = &cert, modTime
+		}
+	}
+	return nil
+}
+
+// verify verifies the server certificate chain against the current CA certificates and the server name.
+func (f *tlsFiles) verify(cs tls.ConnectionState, serverName string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no server certificate")
+	}
+	roots, _, err := f.current()
+	if err != nil {
+		return err
+	}
+	opts := x509.VerifyOptions{Roots: roots, DNSName: serverName, Intermediates: x509.NewCertPool()}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// latestModTime returns the latest modification time of the files.
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
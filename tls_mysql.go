@@ -5,12 +5,12 @@ package sql_exporter
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/url"
 	"os"
-	"sync"
 
 	"github.com/go-sql-driver/mysql"
 )
@@ -24,25 +24,26 @@ const (
 // mysqlTLSParams is a list of TLS parameters that can be used in MySQL DSNs. It is used to identify and strip TLS
 // parameters from the DSN after registering the TLS configuration, as these parameters are not recognized by the MySQL
 // driver and would cause connection failure if left in the DSN.
-var (
-	mysqlTLSParams = []string{mysqlTLSParamCACert, mysqlTLSParamClientCert, mysqlTLSParamClientKey}
+var mysqlTLSParams = []string{mysqlTLSParamCACert, mysqlTLSParamClientCert, mysqlTLSParamClientKey}
 
-	onceMap sync.Map
-)
+func init() {
+	driverConnectors["mysql"] = newMySQLConnector
+	authErrorMatchers = append(authErrorMatchers, isMySQLAuthError)
+}
+
+// isMySQLAuthError returns whether err is a MySQL error rejecting the credentials.
+func isMySQLAuthError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1045 // ER_ACCESS_DENIED_ERROR
+}
 
-// handleMySQLTLSConfig wraps the registration of a MySQL TLS configuration in a thread-safe manner. It uses a
-// sync.Once to ensure that the TLS configuration for a given config name is registered only once, even if multiple
-// goroutines attempt to register it concurrently.
+// handleMySQLTLSConfig registers a MySQL TLS configuration, replacing the one previously registered under the same
+// config name, if any, so that each target has a single one and picks up changed parameters and certificates.
 func handleMySQLTLSConfig(configName string, params url.Values) error {
-	onceConn, _ := onceMap.LoadOrStore(configName, &sync.Once{})
-	once := onceConn.(*sync.Once)
-	var err error
-	once.Do(func() {
-		err = registerMySQLTLSConfig(configName, params)
-		if err != nil {
-			slog.Error("Failed to register MySQL TLS config", "error", err)
-		}
-	})
+	err := registerMySQLTLSConfig(configName, params)
+	if err != nil {
+		slog.Error("Failed to register MySQL TLS config", "error", err)
+	}
 	return err
 }
 
@@ -87,3 +88,19 @@ func registerMySQLTLSConfig(configName string, params url.Values) error {
 
 	return mysql.RegisterTLSConfig(configName, tlsConfig)
 }
+
+// newMySQLConnector returns a MySQL connector applying the options. A TLS config takes precedence over the tls
+// parameter.
+func newMySQLConnector(dsn string, opts driverOptions) (driver.Connector, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if opts.tls != nil {
+		cfg.TLS = opts.tls
+	}
+	if opts.dial != nil {
+		cfg.DialFunc = opts.dial
+	}
+	return mysql.NewConnector(cfg)
+}
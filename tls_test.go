@@ -0,0 +1,244 @@
+package sql_exporter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/burningalchemist/sql_exporter/config"
+)
+
+// testCert is a certificate and key, signed by parent unless self-signed.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid, tmpl.KeyUsage = true, true, x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate (and key, if keyFile is set) as PEM files with the provided modification time.
+func (c *testCert) write(t *testing.T, certFile, keyFile string, modTime time.Time) {
+	t.Helper()
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(certFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(keyFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake runs a TLS handshake between the client config and a server presenting serverCert and requiring a client
+// certificate, returning the common name of the client certificate.
+func handshake(t *testing.T, clientConfig *tls.Config, serverCert, clientCA *testCert) (string, error) {
+	t.Helper()
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCA.cert)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.der}, PrivateKey: serverCert.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+
+	// Over TCP rather than net.Pipe, which deadlocks when both ends write at once.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	serverc := make(chan *tls.Conn, 1)
+	errc := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer c.Close()
+		server := tls.Server(c, serverConfig)
+		err = server.Handshake()
+		// Read the client's first bytes, so that a rejected client certificate fails its side too with TLS 1.3.
+		if err == nil {
+			_, err = server.Read(make([]byte, 1))
+		}
+		serverc <- server
+		errc <- err
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := tls.Client(c, clientConfig)
+	if err := client.Handshake(); err != nil {
+		return "", err
+	}
+	if _, err := client.Write([]byte{0}); err != nil {
+		return "", err
+	}
+	server := <-serverc
+	if err := <-errc; err != nil {
+		return "", err
+	}
+	return server.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestNewTLSConfig_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	tc := &config.TLSConfig{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client-key.pem"),
+	}
+	ca1 := newTestCert(t, "ca1", nil)
+	ca2 := newTestCert(t, "ca2", nil)
+	server1 := newTestCert(t, "db.example.com", ca1)
+	now := time.Now()
+	ca1.write(t, tc.CAFile, "", now)
+	newTestCert(t, "client1", ca1).write(t, tc.CertFile, tc.KeyFile, now)
+
+	tlsConfig, err := newTLSConfig(tc, "db.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn, err := handshake(t, tlsConfig, server1, ca1); err != nil || cn != "client1" {
+		t.Fatalf("expected client1, got %q (%v)", cn, err)
+	}
+
+	// A rotated client certificate is used by new connections.
+	newTestCert(t, "client2", ca1).write(t, tc.CertFile, tc.KeyFile, now.Add(time.Minute))
+	if cn, err := handshake(t, tlsConfig, server1, ca1); err != nil || cn != "client2" {
+		t.Fatalf("expected client2, got %q (%v)", cn, err)
+	}
+
+	// A rotated CA no longer trusts the previous server certificate.
+	ca2.write(t, tc.CAFile, "", now.Add(time.Minute))
+	if _, err := handshake(t, tlsConfig, server1, ca1); err == nil {
+		t.Fatal("expected the server certificate to be rejected after the CA was rotated")
+	}
+	if _, err := handshake(t, tlsConfig, newTestCert(t, "db.example.com", ca2), ca1); err != nil {
+		t.Fatalf("expected the server certificate signed by the rotated CA to be accepted: %v", err)
+	}
+
+	// The previous client certificate is kept while the new one is incomplete.
+	if err := os.WriteFile(tc.KeyFile, []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if cn, err := handshake(t, tlsConfig, newTestCert(t, "db.example.com", ca2), ca1); err != nil || cn != "client2" {
+		t.Fatalf("expected client2, got %q (%v)", cn, err)
+	}
+}
+
+func TestNewTLSConfig_ServerName(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	ca.write(t, filepath.Join(dir, "ca.pem"), "", time.Now())
+	client := newTestCert(t, "client", ca)
+	client.write(t, filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"), time.Now())
+	server := newTestCert(t, "db.internal", ca)
+
+	for _, tc := range []struct {
+		name    string
+		config  config.TLSConfig
+		wantErr bool
+	}{
+		{"host mismatch", config.TLSConfig{}, true},
+		{"server name", config.TLSConfig{ServerName: "db.internal"}, false},
+		{"insecure", config.TLSConfig{InsecureSkipVerify: true}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config.CAFile = filepath.Join(dir, "ca.pem")
+			tc.config.CertFile = filepath.Join(dir, "client.pem")
+			tc.config.KeyFile = filepath.Join(dir, "client-key.pem")
+			tlsConfig, err := newTLSConfig(&tc.config, "10.0.0.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := handshake(t, tlsConfig, server, ca); (err != nil) != tc.wantErr {
+				t.Errorf("expected error %t, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := newTLSConfig(&config.TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}, "db.internal"); err == nil {
+		t.Error("expected an error for a missing CA file")
+	}
+
+	// Multi-host drivers verify each host's certificate against its own name.
+	tc := &config.TLSConfig{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client-key.pem"),
+	}
+	forHost, err := newTLSConfigFunc(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, forHost("db.internal"), server, ca); err != nil {
+		t.Errorf("expected the fallback host's certificate to be accepted: %v", err)
+	}
+	// Same with a single config, the driver setting the server name of the host it connects to (e.g. lib/pq).
+	tlsConfig := forHost("db-primary.internal,db.internal")
+	tlsConfig.ServerName = "db.internal"
+	if _, err := handshake(t, tlsConfig, server, ca); err != nil {
+		t.Errorf("expected the fallback host's certificate to be accepted: %v", err)
+	}
+	tlsConfig.ServerName = "db-primary.internal"
+	if _, err := handshake(t, tlsConfig, server, ca); err == nil {
+		t.Error("expected the certificate to be rejected for another host")
+	}
+}